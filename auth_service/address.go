@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"ecomm/internal/apierr"
+	"ecomm/internal/trace"
+	"encoding/hex"
+	"jin"
+	"log"
+	"net/http"
+	"regexp"
+	"seecool"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// address limit of a user
+	addressMax int = 20
+
+	// log strings
+	addressCreated string = "Address Created For"
+	addressUpdated string = "Address Updated For"
+	addressDeleted string = "Address Deleted For"
+)
+
+var (
+	// address columns set by users and their maximum lengths
+	addressColumns map[string]int = map[string]int{
+		"kind":        8,
+		"full_name":   64,
+		"line1":       128,
+		"line2":       128,
+		"city":        64,
+		"region":      64,
+		"postal_code": 16,
+		"country":     2,
+		"phone":       20,
+		"is_default":  5,
+	}
+	// columns that can not be empty
+	addressRequired []string = []string{"kind", "full_name", "line1", "city", "country"}
+	// address kinds, every kind has its own default address
+	addressKinds map[string]bool = map[string]bool{"shipping": true, "billing": true}
+	// returned address columns
+	addressRead []string = []string{"address_id", "kind", "full_name", "line1", "line2", "city", "region", "postal_code", "country", "phone", "is_default"}
+
+	// postal code formats by iso 3166 alpha-2 country code,
+	// countries that are not listed accept any code up to the column length
+	postalFormats map[string]*regexp.Regexp = map[string]*regexp.Regexp{
+		"AU": regexp.MustCompile(`^\d{4}$`),
+		"BR": regexp.MustCompile(`^\d{5}-?\d{3}$`),
+		"CA": regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+		"DE": regexp.MustCompile(`^\d{5}$`),
+		"ES": regexp.MustCompile(`^\d{5}$`),
+		"FR": regexp.MustCompile(`^\d{5}$`),
+		"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+		"IE": regexp.MustCompile(`^[A-Z]\d[\dW] ?[A-Z\d]{4}$`),
+		"IN": regexp.MustCompile(`^\d{6}$`),
+		"IT": regexp.MustCompile(`^\d{5}$`),
+		"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+		"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+		"TR": regexp.MustCompile(`^\d{5}$`),
+		"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+	}
+	// countries without postal codes
+	postalNone map[string]bool = map[string]bool{"AE": true, "HK": true, "QA": true}
+	// country codes are two upper case letters
+	countryFormat *regexp.Regexp = regexp.MustCompile(`^[A-Z]{2}$`)
+	// phone numbers are e.164 like '+905551234567'
+	phoneFormat *regexp.Regexp = regexp.MustCompile(`^\+[1-9]\d{6,14}$`)
+
+	// json format schemes
+	addressSnapshotScheme *jin.Scheme = jin.MakeScheme("address", "snapshot_at")
+)
+
+// addressListHandle returns addresses of the user.
+// request: {"user_id": "..."}
+func addressListHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	if userID == "" {
+		failHandle(w, emptyField.Detail("user_id", "required"), http.StatusBadRequest)
+		return
+	}
+	query := seecool.Select(conf.AddressTable, addressRead...).
+		Equal("user_id", userID).
+		Order("created")
+	result, err := trace.QueryJson(r.Context(), base, query)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	doneHandle(w, result)
+}
+
+// addressCreateHandle adds an address to the book of the user,
+// the first address of a kind becomes its default.
+// request: {"user_id": "...", "kind": "shipping", "full_name": "...", "line1": "...", "city": "...", "postal_code": "...", "country": "TR"}
+func addressCreateHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	fields, err := jin.GetMap(json)
+	if err != nil {
+		failHandle(w, apierr.InvalidJSON, http.StatusBadRequest)
+		return
+	}
+	userID := fields["user_id"]
+	if userID == "" {
+		failHandle(w, emptyField.Detail("user_id", "required"), http.StatusBadRequest)
+		return
+	}
+	delete(fields, "user_id")
+	address, err := addressClean(fields)
+	if err != nil {
+		failHandle(w, err, http.StatusBadRequest)
+		return
+	}
+	query := seecool.Select(conf.AddressTable, "kind").Equal("user_id", userID)
+	result, err := trace.QueryJson(r.Context(), base, query)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	count, _ := jin.Length(result)
+	if count >= addressMax {
+		failHandle(w, apierr.Conflict.Detail("address", "at most "+strconv.Itoa(addressMax)+" addresses"), http.StatusConflict)
+		return
+	}
+	first := true
+	for i := 0; i < count; i++ {
+		if kind, _ := jsonText(result, strconv.Itoa(i), "kind"); kind == address["kind"] {
+			first = false
+			break
+		}
+	}
+	if first {
+		address["is_default"] = "true"
+	}
+	addressID, err := newUUID()
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	// the old default is cleared only when the new one is written
+	tx, err := base.BeginTx(r.Context(), nil)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if address["is_default"] == "true" {
+		err = addressClearDefault(r.Context(), tx, userID, address["kind"])
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	keys := []string{"address_id", "user_id"}
+	values := []string{addressID, userID}
+	for key, value := range address {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	query = seecool.Insert(conf.AddressTable).Keys(keys...).Values(values...)
+	_, err = trace.Exec(r.Context(), tx, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(addressCreated, userID)
+	record, status, err := addressRecord(r.Context(), userID, addressID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	doneHandle(w, record)
+}
+
+// addressUpdateHandle changes columns of an address.
+// changes are validated together with the stored columns,
+// a postal code is checked against the new country for example.
+// request: {"user_id": "...", "address_id": "...", "city": "..."}
+func addressUpdateHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	fields, err := jin.GetMap(json)
+	if err != nil {
+		failHandle(w, apierr.InvalidJSON, http.StatusBadRequest)
+		return
+	}
+	userID, addressID := fields["user_id"], fields["address_id"]
+	if userID == "" || addressID == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	delete(fields, "user_id")
+	delete(fields, "address_id")
+	record, status, err := addressRecord(r.Context(), userID, addressID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	stored, err := jin.GetMap(record)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	for key, value := range fields {
+		stored[key] = value
+	}
+	delete(stored, "address_id")
+	address, err := addressClean(stored)
+	if err != nil {
+		failHandle(w, err, http.StatusBadRequest)
+		return
+	}
+	// the old default is cleared only when the new one is written
+	tx, err := base.BeginTx(r.Context(), nil)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if address["is_default"] == "true" {
+		err = addressClearDefault(r.Context(), tx, userID, address["kind"])
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	keys := make([]string, 0, len(address))
+	values := make([]string, 0, len(address))
+	for key, value := range address {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	query := seecool.Update(conf.AddressTable).
+		Keys(keys...).
+		Values(values...).
+		Equal("user_id", userID).
+		Equal("address_id", addressID)
+	_, err = trace.Exec(r.Context(), tx, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(addressUpdated, userID)
+	record, status, err = addressRecord(r.Context(), userID, addressID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	doneHandle(w, record)
+}
+
+// addressDeleteHandle removes an address from the book,
+// the oldest address of the kind becomes default when the default is removed.
+// orders keep their snapshots, so deleting never changes a placed order.
+// request: {"user_id": "...", "address_id": "..."}
+func addressDeleteHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	addressID, _ := jsonText(json, "address_id")
+	if userID == "" || addressID == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	record, status, err := addressRecord(r.Context(), userID, addressID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	query := seecool.Delete(conf.AddressTable).
+		Equal("user_id", userID).
+		Equal("address_id", addressID)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(addressDeleted, userID)
+	if isDefault, _ := jsonText(record, "is_default"); isDefault == "true" {
+		kind, _ := jsonText(record, "kind")
+		err = addressPromote(r.Context(), userID, kind)
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	doneHandle(w, []byte("null"))
+}
+
+// addressSnapshotHandle returns a copy of an address to review before ordering.
+// orders store the same copy instead of the address id,
+// later edits of the book never change an order.
+// request: {"user_id": "...", "address_id": "..."}
+func addressSnapshotHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	addressID, _ := jsonText(json, "address_id")
+	if userID == "" || addressID == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	record, status, err := addressRecord(r.Context(), userID, addressID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	doneHandle(w, addressSnapshotScheme.MakeJson(string(record), strconv.FormatInt(time.Now().Unix(), 10)))
+}
+
+// addressRecord returns an address of the user, 404 when the user has no such address.
+func addressRecord(ctx context.Context, userID, addressID string) ([]byte, int, error) {
+	if !isUUID(addressID) {
+		return nil, http.StatusNotFound, recordNotExist
+	}
+	query := seecool.Select(conf.AddressTable, addressRead...).
+		Equal("user_id", userID).
+		Equal("address_id", addressID)
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	record, err := jin.Get(result, "0")
+	if err != nil {
+		return nil, http.StatusNotFound, recordNotExist
+	}
+	return record, http.StatusOK, nil
+}
+
+// addressClearDefault removes the default flag of the addresses of a kind,
+// db is the transaction that writes the new default.
+func addressClearDefault(ctx context.Context, db trace.Execer, userID, kind string) error {
+	query := seecool.Update(conf.AddressTable).
+		Keys("is_default").
+		Values("false").
+		Equal("user_id", userID).
+		Equal("kind", kind)
+	_, err := trace.Exec(ctx, db, query.String())
+	return err
+}
+
+// addressPromote makes the oldest address of the kind default.
+func addressPromote(ctx context.Context, userID, kind string) error {
+	query := seecool.Select(conf.AddressTable, "address_id").
+		Equal("user_id", userID).
+		Equal("kind", kind).
+		Order("created")
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return err
+	}
+	addressID, err := jsonText(result, "0", "address_id")
+	if err != nil {
+		// no address of the kind is left
+		return nil
+	}
+	query = seecool.Update(conf.AddressTable).
+		Keys("is_default").
+		Values("true").
+		Equal("address_id", addressID)
+	_, err = trace.Exec(ctx, base, query.String())
+	return err
+}
+
+// addressClean validates a whole address and returns it in the stored form.
+// every problem is reported at once.
+func addressClean(fields map[string]string) (map[string]string, error) {
+	invalid := apierr.InvalidField
+	address := make(map[string]string, len(fields))
+	for key, value := range fields {
+		limit, ok := addressColumns[key]
+		if !ok {
+			invalid = invalid.Detail(key, "unknown address field")
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if utf8.RuneCountInString(value) > limit {
+			invalid = invalid.Detail(key, "at most "+strconv.Itoa(limit)+" characters")
+			continue
+		}
+		address[key] = value
+	}
+	for _, key := range addressRequired {
+		if address[key] == "" {
+			invalid = invalid.Detail(key, "required")
+		}
+	}
+	if kind := address["kind"]; kind != "" && !addressKinds[kind] {
+		invalid = invalid.Detail("kind", "must be shipping or billing")
+	}
+	switch address["is_default"] {
+	case "":
+		address["is_default"] = "false"
+	case "true", "false":
+	default:
+		invalid = invalid.Detail("is_default", "must be true or false")
+	}
+	country := strings.ToUpper(address["country"])
+	address["country"] = country
+	if country != "" && !countryFormat.MatchString(country) {
+		invalid = invalid.Detail("country", "must be an iso 3166 alpha-2 code like TR")
+	}
+	postal := strings.ToUpper(address["postal_code"])
+	address["postal_code"] = postal
+	if format, ok := postalFormats[country]; ok {
+		if !format.MatchString(postal) {
+			invalid = invalid.Detail("postal_code", "not a valid postal code of "+country)
+		}
+	} else if postalNone[country] && postal != "" {
+		invalid = invalid.Detail("postal_code", country+" has no postal codes")
+	}
+	if phone := address["phone"]; phone != "" && !phoneFormat.MatchString(phone) {
+		invalid = invalid.Detail("phone", "must be like +905551234567")
+	}
+	if len(invalid.Details) > 0 {
+		return nil, invalid
+	}
+	return address, nil
+}
+
+// newUUID creates a random version 4 uuid.
+func newUUID() (string, error) {
+	buff := make([]byte, 16)
+	_, err := rand.Read(buff)
+	if err != nil {
+		return "", err
+	}
+	buff[6] = buff[6]&0x0f | 0x40
+	buff[8] = buff[8]&0x3f | 0x80
+	h := hex.EncodeToString(buff)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// isUUID reports whether s looks like a uuid,
+// other values would fail the uuid column cast in the database.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"ecomm/internal/trace"
+	"encoding/hex"
+	"jin"
+	"log"
+	"net/http"
+	"seecool"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// keys look like 'ecomm_<prefix>_<secret>',
+	// prefix is public and identifies the key, secret is never stored.
+	apiKeyTag       string = "ecomm"
+	apiKeyPrefixLen int    = 4
+	apiKeySecretLen int    = 32
+
+	// last_used is written at most once in this interval
+	apiKeyTouchEvery int64 = 60
+
+	// log strings
+	apiKeyCreated string = "API Key Created:"
+	apiKeyRevoked string = "API Key Revoked:"
+)
+
+var (
+	// listed key columns, hash is never returned
+	apiKeyColumns []string = []string{"prefix", "name", "scopes", "created", "expires", "last_used", "revoked"}
+)
+
+// apiKeyNew is the response of a created key,
+// name is free text so responses are built with encoding/json.
+type apiKeyNew struct {
+	Key     string   `json:"key"`
+	Prefix  string   `json:"prefix"`
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	Expires int64    `json:"expires"`
+}
+
+// apiPrincipal is the response of a verified key.
+type apiPrincipal struct {
+	UserID    string   `json:"user_id"`
+	Type      string   `json:"type"`
+	Email     string   `json:"email"`
+	Scopes    []string `json:"scopes"`
+	KeyPrefix string   `json:"key_prefix"`
+}
+
+// apiKeyCreateHandle creates a new key for the user.
+// the plain key is returned only once.
+// request: {"user_id": "...", "name": "erp sync", "scopes": ["data:read"], "expires_days": 90}
+func apiKeyCreateHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	name, _ := jin.GetString(json, "name")
+	scopes, _ := jin.GetStringArray(json, "scopes")
+	if userID == "" || name == "" || len(scopes) == 0 {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	status, err := apiKeyScopes(scopes)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	var expires int64
+	days, _ := jsonText(json, "expires_days")
+	if days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			failHandle(w, apiKeyBadExpire, http.StatusBadRequest)
+			return
+		}
+		expires = time.Now().Add(time.Duration(n) * 24 * time.Hour).Unix()
+	}
+	prefix, err := randomToken(apiKeyPrefixLen)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	secret, err := randomToken(apiKeySecretLen)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	key := apiKeyTag + "_" + prefix + "_" + secret
+	query := seecool.Insert(conf.APIKeyTable).
+		Keys("prefix", "key_hash", "user_id", "name", "scopes", "expires").
+		Values(prefix, apiKeyHash(key), userID, name, strings.Join(scopes, ","), strconv.FormatInt(expires, 10))
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(apiKeyCreated, prefix, userID)
+	resp, err := encodeJson(apiKeyNew{Key: key, Prefix: prefix, Name: name, Scopes: scopes, Expires: expires})
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	doneHandle(w, resp)
+}
+
+// apiKeyListHandle lists the keys of the user.
+// request: {"user_id": "..."}
+func apiKeyListHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	if userID == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	query := seecool.Select(conf.APIKeyTable, apiKeyColumns...).
+		Equal("user_id", userID).
+		Order("created")
+	result, err := trace.QueryJson(r.Context(), base, query)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	doneHandle(w, result)
+}
+
+// apiKeyRevokeHandle revokes a key of the user.
+// request: {"user_id": "...", "prefix": "..."}
+func apiKeyRevokeHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	prefix, _ := jsonText(json, "prefix")
+	if userID == "" || prefix == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	// user can revoke only its own keys
+	query := seecool.Select(conf.APIKeyTable, "prefix").
+		Equal("prefix", prefix).
+		Equal("user_id", userID)
+	result, err := trace.QueryJson(r.Context(), base, query)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	if string(result) == "[]" {
+		failHandle(w, recordNotExist, http.StatusNotFound)
+		return
+	}
+	query = seecool.Update(conf.APIKeyTable).
+		Keys("revoked").
+		Values("true").
+		Equal("prefix", prefix)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(apiKeyRevoked, prefix, userID)
+	doneHandle(w, []byte("null"))
+}
+
+// apiKeyVerifyHandle resolves a key to its user and scopes.
+// request: {"key": "ecomm_..."}
+// response: {"user_id": "...", "type": "...", "email": "...", "scopes": [...], "key_prefix": "..."}
+func apiKeyVerifyHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	key, _ := jin.GetString(json, "key")
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyTag {
+		failHandle(w, apiKeyInvalid, http.StatusUnauthorized)
+		return
+	}
+	prefix := parts[1]
+	query := seecool.Select(conf.APIKeyTable, "key_hash", "user_id", "scopes", "expires", "last_used", "revoked").
+		Equal("prefix", prefix)
+	result, err := trace.QueryJson(r.Context(), base, query)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	hash, err := jsonText(result, "0", "key_hash")
+	if err != nil {
+		failHandle(w, apiKeyInvalid, http.StatusUnauthorized)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(apiKeyHash(key))) != 1 {
+		failHandle(w, apiKeyInvalid, http.StatusUnauthorized)
+		return
+	}
+	revoked, _ := jsonText(result, "0", "revoked")
+	if revoked == "true" {
+		failHandle(w, apiKeyInvalid, http.StatusUnauthorized)
+		return
+	}
+	now := time.Now().Unix()
+	text, _ := jsonText(result, "0", "expires")
+	expires, _ := strconv.ParseInt(text, 10, 64)
+	if expires != 0 && now > expires {
+		failHandle(w, apiKeyExpired, http.StatusUnauthorized)
+		return
+	}
+	text, _ = jsonText(result, "0", "last_used")
+	lastUsed, _ := strconv.ParseInt(text, 10, 64)
+	if now-lastUsed >= apiKeyTouchEvery {
+		query = seecool.Update(conf.APIKeyTable).
+			Keys("last_used").
+			Values(strconv.FormatInt(now, 10)).
+			Equal("prefix", prefix)
+		_, err = trace.Exec(r.Context(), base, query.String())
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	userID, _ := jsonText(result, "0", "user_id")
+	scopes, _ := jsonText(result, "0", "scopes")
+	record, err := userRecord(r.Context(), base, "user_id", userID)
+	if err != nil {
+		failHandle(w, err, http.StatusUnauthorized)
+		return
+	}
+	userType, _ := jsonText(record, "type")
+	email, _ := jsonText(record, "email")
+	resp, err := encodeJson(apiPrincipal{UserID: userID, Type: userType, Email: email, Scopes: strings.Split(scopes, ","), KeyPrefix: prefix})
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	doneHandle(w, resp)
+}
+
+// apiKeyScopes checks requested scopes against 'apiScopes' environment list.
+func apiKeyScopes(scopes []string) (int, error) {
+	allowed := make(map[string]bool)
+	for _, scope := range conf.APIScopes {
+		allowed[scope] = true
+	}
+	for _, scope := range scopes {
+		if !allowed[scope] {
+			return http.StatusBadRequest, apiKeyBadScope
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// apiKeyHash returns the stored form of a key.
+// keys carry 256 bits of entropy, a plain sha256 is sufficient.
+func apiKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAPIKeyJson(t *testing.T) {
+	names := []string{"erp sync", `quote " name`, `back\slash`, "new\nline\ttab", "\x00\x1f", `","key":"x`}
+	for _, name := range names {
+		resp, err := encodeJson(apiKeyNew{Key: "ecomm_abcd_secret", Prefix: "abcd", Name: name, Scopes: []string{"data:read"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := apiKeyNew{}
+		err = json.Unmarshal(resp, &got)
+		if err != nil {
+			t.Fatalf("name %q: invalid json %s: %v", name, resp, err)
+		}
+		if got.Name != name || got.Key != "ecomm_abcd_secret" {
+			t.Fatalf("name %q: got %+v", name, got)
+		}
+	}
+}
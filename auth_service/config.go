@@ -0,0 +1,62 @@
+package main
+
+import (
+	"ecomm/internal/config"
+	"time"
+)
+
+// authConfig is the configuration of authentication service.
+// keys of '.env_database' are read with 'db_' prefix.
+type authConfig struct {
+	config.Database
+	config.Tracing
+	config.Logging
+	config.Mail
+
+	Port    string   `env:"auth_service_port" required:"true"`
+	CertDir string   `env:"cert_dir" required:"true"`
+	Peers   []string `env:"auth_service_peers" required:"true"`
+
+	// self registration, enabled when both urls exist
+	RegistryURL  string        `env:"registry_url"`
+	AdvertiseURL string        `env:"auth_service_url"`
+	Heartbeat    time.Duration `env:"registry_heartbeat" default:"10s"`
+
+	// internal listener of '/metrics', disabled when empty
+	MetricsPort string `env:"auth_metrics_port"`
+
+	// verification links of email changes point to the web site
+	WebURL string `env:"web_url" required:"true"`
+
+	// active requests are drained in this duration on shutdown
+	ShutdownTimeout time.Duration `env:"shutdown_timeout" default:"15s"`
+
+	UserTable     string        `env:"userTable" required:"true"`
+	PrimKey       string        `env:"primKey" required:"true"`
+	PassKey       string        `env:"passKey" required:"true"`
+	IDKey         string        `env:"idKey" default:"login"`
+	IDColumns     []string      `env:"idColumns"`
+	CIColumns     []string      `env:"ciColumns"`
+	MFATable      string        `env:"mfaTable" required:"true"`
+	RecoveryTable string        `env:"recoveryTable" required:"true"`
+	PendingTable  string        `env:"pendingTable" required:"true"`
+	IdentityTable string        `env:"identityTable" required:"true"`
+	StateTable    string        `env:"stateTable" required:"true"`
+	APIKeyTable   string        `env:"apiKeyTable" required:"true"`
+	APIScopes     []string      `env:"apiScopes"`
+	MFAIssuer     string        `env:"mfaIssuer" default:"ecomm"`
+	EmailTable    string        `env:"emailTable" required:"true"`
+	EmailTTL      time.Duration `env:"emailTTL" default:"24h"`
+	AddressTable  string        `env:"addressTable" required:"true"`
+	OrderTable    string        `env:"orderTable" required:"true"`
+	ProductTable  string        `env:"productTable" required:"true"`
+}
+
+var (
+	// environment files in override order
+	configSources []config.Source = []config.Source{
+		{Path: envMainDir},
+		{Path: envAuthDir},
+		{Path: envDatabaseDir, Prefix: "db_"},
+	}
+)
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"ecomm/internal/openapi"
+	"net/http/httptest"
+	"testing"
+)
+
+// documented returns the operation of the path.
+func documented(t *testing.T, path string) openapi.Operation {
+	for _, route := range routes() {
+		for _, op := range route.Operations {
+			if op.Path == path {
+				return op
+			}
+		}
+	}
+	t.Fatalf("%s is not documented", path)
+	return openapi.Operation{}
+}
+
+// TestContractResponses builds responses the way handlers do
+// and checks them against the document, keys that are documented
+// but not returned fail like keys that are returned but not documented.
+func TestContractResponses(t *testing.T) {
+	conf.PassKey = "password"
+	// a login record as lookupRecord selects it
+	row := make(map[string]string)
+	for _, column := range retColumns {
+		row[column] = column + "-1"
+	}
+	row[conf.PassKey] = "secret"
+	record, err := encodeJson(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := recordWithout(record, conf.PassKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encodeJson(apiKeyNew{Key: "ecomm_abcd_secret", Prefix: "abcd", Name: "erp", Scopes: []string{"data:read"}, Expires: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, err := encodeJson(apiPrincipal{UserID: "user-1", Type: "standart", Email: "ada@example.com", Scopes: []string{"data:read"}, KeyPrefix: "abcd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an address as addressRecord selects it
+	address := make(map[string]interface{})
+	for _, column := range addressRead {
+		address[column] = column + "-1"
+	}
+	address["kind"] = "shipping"
+	address["is_default"] = true
+	snapshot, err := encodeJson(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed, err := encodeJson(order{
+		OrderID:    "order-1",
+		Status:     orderPlaced,
+		Items:      []orderItem{{ProductID: "product-1", Name: "Notebook", PriceCents: 875, Quantity: 2}},
+		TotalCents: 1750,
+		Shipping:   snapshot,
+		Billing:    snapshot,
+		Created:    1700000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		data []byte
+	}{
+		{"/", user},
+		{"/signup", user},
+		{"/mfa/login", user},
+		{"/oidc/callback", user},
+		{"/apikey/create", key},
+		{"/apikey/verify", principal},
+		{"/order/create", placed},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		doneHandle(rec, tt.data)
+		op := documented(t, tt.path)
+		err := op.ValidateSuccess(rec.Body.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %s: %v", tt.path, rec.Body.String(), err)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"ecomm/internal/trace"
+	"io/ioutil"
+	"jin"
+	"log"
+	"net/http"
+	"seecool"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// pending login lifetime after a successful password check
+	mfaPendingTTL time.Duration = 5 * time.Minute
+	// wrong code limit for a pending login
+	mfaMaxTries int = 5
+
+	// log strings
+	mfaEnrolled string = "MFA Enrollment Started For"
+	mfaEnabled  string = "MFA Enabled For"
+	mfaDisabled string = "MFA Disabled For"
+)
+
+var (
+	// json format schemes
+	mfaChallengeScheme *jin.Scheme = jin.MakeScheme("mfa_token")
+	mfaEnrollScheme    *jin.Scheme = jin.MakeScheme("secret", "uri")
+	mfaRecoveryScheme  *jin.Scheme = jin.MakeScheme("recovery_codes")
+)
+
+// mfaChallenge creates a pending login if the user has enabled mfa.
+// it returns nil when no second factor is necessary.
+// pending logins are stored in the database, any instance can complete them.
+func mfaChallenge(ctx context.Context, record []byte) ([]byte, error) {
+	userID, err := jsonText(record, "user_id")
+	if err != nil {
+		return nil, err
+	}
+	_, enabled, _, found, err := mfaRecord(ctx, base, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !found || !enabled {
+		return nil, nil
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	// drop expired logins
+	query := seecool.Delete(conf.PendingTable).Cond("expires", "<", strconv.FormatInt(now.Unix(), 10))
+	_, err = trace.Exec(ctx, base, query.String())
+	if err != nil {
+		return nil, err
+	}
+	query = seecool.Insert(conf.PendingTable).
+		Keys("token_hash", "user_id", "expires").
+		Values(apiKeyHash(token), userID, strconv.FormatInt(now.Add(mfaPendingTTL).Unix(), 10))
+	_, err = trace.Exec(ctx, base, query.String())
+	if err != nil {
+		return nil, err
+	}
+	return mfaChallengeScheme.MakeJson(token), nil
+}
+
+// mfaEnrollHandle creates a new not yet enabled secret for the user.
+// request: {"user_id": "..."}
+func mfaEnrollHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, err := jsonText(json, "user_id")
+	if err != nil || userID == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	// account name shown in authenticator apps
+	query := seecool.Select(conf.UserTable, "email").Equal("user_id", userID)
+	result, err := trace.QueryJson(r.Context(), base, query)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	account, err := jsonText(result, "0", "email")
+	if err != nil {
+		failHandle(w, recordNotExist, http.StatusBadRequest)
+		return
+	}
+	_, enabled, _, found, err := mfaRecord(r.Context(), base, userID)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		failHandle(w, mfaAlreadyOn, http.StatusConflict)
+		return
+	}
+	secret, err := totpSecret()
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	// restarting an unfinished enrollment replaces the old secret
+	if found {
+		query = seecool.Update(conf.MFATable).
+			Keys("secret", "last_step").
+			Values(secret, "0").
+			Equal("user_id", userID)
+	} else {
+		query = seecool.Insert(conf.MFATable).
+			Keys("user_id", "secret").
+			Values(userID, secret)
+	}
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(mfaEnrolled, userID)
+	uri := totpURI(conf.MFAIssuer, account, secret)
+	doneHandle(w, mfaEnrollScheme.MakeJson(secret, uri))
+}
+
+// mfaConfirmHandle enables mfa after the first valid code
+// and returns the recovery codes. codes are shown only once.
+// request: {"user_id": "...", "code": "123456"}
+func mfaConfirmHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	code, _ := jsonText(json, "code")
+	if userID == "" || code == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	secret, enabled, lastStep, found, err := mfaRecord(r.Context(), base, userID)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		failHandle(w, mfaNotEnrolled, http.StatusBadRequest)
+		return
+	}
+	if enabled {
+		failHandle(w, mfaAlreadyOn, http.StatusConflict)
+		return
+	}
+	step, valid := totpVerify(secret, code, time.Now(), lastStep)
+	if !valid {
+		failHandle(w, mfaWrongCode, http.StatusUnauthorized)
+		return
+	}
+	codes, err := recoveryCodes()
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	tx, err := base.BeginTx(r.Context(), nil)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	// conditional update, concurrent requests can not both use the code
+	query := seecool.Update(conf.MFATable).
+		Keys("enabled", "last_step").
+		Values("true", strconv.FormatInt(step, 10)).
+		Equal("user_id", userID).
+		Equal("enabled", "false").
+		Cond("last_step", "<", strconv.FormatInt(step, 10))
+	result, err := trace.Exec(r.Context(), tx, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	if rows != 1 {
+		failHandle(w, mfaWrongCode, http.StatusUnauthorized)
+		return
+	}
+	// old codes of an earlier enrollment are not valid anymore
+	query = seecool.Delete(conf.RecoveryTable).Equal("user_id", userID)
+	_, err = trace.Exec(r.Context(), tx, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	for _, c := range codes {
+		query = seecool.Insert(conf.RecoveryTable).
+			Keys("user_id", "code_hash").
+			Values(userID, recoveryHash(c))
+		_, err = trace.Exec(r.Context(), tx, query.String())
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(mfaEnabled, userID)
+	doneHandle(w, mfaRecoveryScheme.MakeJson(stringArray(codes)))
+}
+
+// mfaDisableHandle removes the second factor of the user.
+// a valid code or a recovery code is necessary.
+// request: {"user_id": "...", "code": "123456"} or {"user_id": "...", "recovery_code": "..."}
+func mfaDisableHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	if userID == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	status, err := mfaSecondFactor(r.Context(), base, userID, json)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	query := seecool.Delete(conf.RecoveryTable).Equal("user_id", userID)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	query = seecool.Delete(conf.MFATable).Equal("user_id", userID)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(mfaDisabled, userID)
+	doneHandle(w, []byte("null"))
+}
+
+// mfaLoginHandle completes a pending login.
+// on success response is same with the password login.
+// request: {"mfa_token": "...", "code": "123456"} or {"mfa_token": "...", "recovery_code": "..."}
+func mfaLoginHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	token, _ := jsonText(json, "mfa_token")
+	if token == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	userID, status, err := mfaPendingTry(r.Context(), token)
+	if err != nil {
+		loginCount.Inc("mfa", "failed")
+		failHandle(w, err, status)
+		return
+	}
+	status, err = mfaSecondFactor(r.Context(), base, userID, json)
+	if err != nil {
+		loginCount.Inc("mfa", "failed")
+		failHandle(w, err, status)
+		return
+	}
+	// pending login is single use
+	query := seecool.Delete(conf.PendingTable).Equal("token_hash", apiKeyHash(token))
+	result, err := trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	if rows != 1 {
+		loginCount.Inc("mfa", "failed")
+		failHandle(w, mfaNoPending, http.StatusUnauthorized)
+		return
+	}
+	record, err := userRecord(r.Context(), base, "user_id", userID)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	loginCount.Inc("mfa", "granted")
+	doneHandle(w, record)
+}
+
+// mfaPendingTry counts a second factor attempt of a pending login
+// and returns the user of it.
+func mfaPendingTry(ctx context.Context, token string) (string, int, error) {
+	hash := apiKeyHash(token)
+	query := seecool.Select(conf.PendingTable, "user_id", "expires", "tries").Equal("token_hash", hash)
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	userID, err := jsonText(result, "0", "user_id")
+	if err != nil {
+		return "", http.StatusUnauthorized, mfaNoPending
+	}
+	expires, _ := jsonText(result, "0", "expires")
+	deadline, _ := strconv.ParseInt(expires, 10, 64)
+	tries, _ := jsonText(result, "0", "tries")
+	count, _ := strconv.Atoi(tries)
+	// expired or too many wrong codes, password step must be done again.
+	if time.Now().Unix() > deadline || count >= mfaMaxTries {
+		query = seecool.Delete(conf.PendingTable).Equal("token_hash", hash)
+		_, err = trace.Exec(ctx, base, query.String())
+		if err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		return "", http.StatusUnauthorized, mfaNoPending
+	}
+	// conditional on the read count, concurrent attempts can not share a try
+	query = seecool.Update(conf.PendingTable).
+		Keys("tries").
+		Values(strconv.Itoa(count+1)).
+		Equal("token_hash", hash).
+		Equal("tries", tries)
+	counted, err := trace.Exec(ctx, base, query.String())
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	rows, err := counted.RowsAffected()
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if rows != 1 {
+		return "", http.StatusUnauthorized, mfaNoPending
+	}
+	return userID, http.StatusOK, nil
+}
+
+// mfaSecondFactor verifies 'code' or 'recovery_code' of the request.
+// used codes can not be used again.
+func mfaSecondFactor(ctx context.Context, db *sql.DB, userID string, json []byte) (int, error) {
+	secret, enabled, lastStep, found, err := mfaRecord(ctx, db, userID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !found || !enabled {
+		return http.StatusBadRequest, mfaNotEnrolled
+	}
+	// a single conditional update for both kinds,
+	// concurrent requests can not both use the same code
+	var query *seecool.Query
+	code, _ := jsonText(json, "code")
+	recovery, _ := jsonText(json, "recovery_code")
+	switch {
+	case code != "":
+		step, valid := totpVerify(secret, code, time.Now(), lastStep)
+		if !valid {
+			return http.StatusUnauthorized, mfaWrongCode
+		}
+		query = seecool.Update(conf.MFATable).
+			Keys("last_step").
+			Values(strconv.FormatInt(step, 10)).
+			Equal("user_id", userID).
+			Cond("last_step", "<", strconv.FormatInt(step, 10))
+	case recovery != "":
+		query = seecool.Update(conf.RecoveryTable).
+			Keys("used").
+			Values("true").
+			Equal("user_id", userID).
+			Equal("code_hash", recoveryHash(recovery)).
+			Equal("used", "false")
+	default:
+		return http.StatusBadRequest, emptyField
+	}
+	result, err := trace.Exec(ctx, db, query.String())
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if rows != 1 {
+		return http.StatusUnauthorized, mfaWrongCode
+	}
+	return http.StatusOK, nil
+}
+
+// mfaRecord reads the mfa row of the user.
+func mfaRecord(ctx context.Context, db *sql.DB, userID string) (string, bool, int64, bool, error) {
+	query := seecool.Select(conf.MFATable, "secret", "enabled", "last_step").
+		Equal("user_id", userID)
+	result, err := trace.QueryJson(ctx, db, query)
+	if err != nil {
+		return "", false, 0, false, err
+	}
+	lenr, err := jin.Length(result)
+	if err != nil || lenr == 0 {
+		return "", false, 0, false, nil
+	}
+	secret, err := jsonText(result, "0", "secret")
+	if err != nil {
+		return "", false, 0, false, err
+	}
+	enabled, _ := jsonText(result, "0", "enabled")
+	last, _ := jsonText(result, "0", "last_step")
+	lastStep, _ := strconv.ParseInt(last, 10, 64)
+	return secret, enabled == "true", lastStep, true, nil
+}
+
+// requestBody applies the common method check and reads the request body.
+// it writes the failure response by itself.
+func requestBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	w.Header().Set("Content-Type", "application/json")
+	// request log
+	log.Println(reqArrived, r.URL.Path, r.RemoteAddr, trace.ID(r.Context()))
+
+	// method check
+	if string(r.Method) != http.MethodPost {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	json, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	defer r.Body.Close()
+	return json, true
+}
+
+// jsonText returns the value at path as plain text.
+// unlike jin.GetString it also accepts numbers and booleans,
+// codes like 123456 may arrive as json numbers.
+func jsonText(json []byte, path ...string) (string, error) {
+	value, err := jin.Get(json, path...)
+	if err != nil {
+		return "", err
+	}
+	text := string(value)
+	if len(text) > 1 && text[0] == '"' && text[len(text)-1] == '"' {
+		text = text[1 : len(text)-1]
+	}
+	return text, nil
+}
+
+// stringArray creates a json string array.
+// elements must not contain quotes.
+func stringArray(arr []string) string {
+	if len(arr) == 0 {
+		return "[]"
+	}
+	return `["` + strings.Join(arr, `","`) + `"]`
+}
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"ecomm/internal/config"
+	"ecomm/internal/trace"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"jin"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"seecool"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidc environment file, federation is disabled without it.
+	envOIDCDir string = "curr/.env_oidc"
+
+	// authorization request lifetime
+	oidcStateTTL time.Duration = 10 * time.Minute
+	// allowed clock difference with the issuer
+	oidcLeeway time.Duration = time.Minute
+	// cached discovery documents and keys are refreshed after
+	oidcCacheTTL time.Duration = time.Hour
+	// unknown key ids fetch the keys again at most once in
+	oidcKeyRefetch time.Duration = time.Minute
+
+	// log strings
+	oidcLinked  string = "External Identity Linked:"
+	oidcCreated string = "Account Created For External Identity:"
+)
+
+// oidcProvider is a configured external identity provider.
+type oidcProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURI  string
+	scopes       string
+
+	// discovery and key cache
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]*rsa.PublicKey
+	fetched     time.Time
+	keysFetched time.Time
+}
+
+// oidcDiscovery is the part of the discovery document we use.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// oidcClaims are the id token claims we use.
+type oidcClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	Expires       int64           `json:"exp"`
+	IssuedAt      int64           `json:"iat"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified interface{}     `json:"email_verified"`
+}
+
+// oidcStore keeps users, their external identities
+// and the authorization requests waiting for a callback.
+type oidcStore interface {
+	// identity returns the user id linked to the identity, empty when not linked.
+	identity(ctx context.Context, provider, subject string) (string, error)
+	// user returns the login record of a single user, recordNotExist when missing.
+	user(ctx context.Context, column, value string) ([]byte, error)
+	// create creates a user without a password and returns its login record.
+	create(ctx context.Context, email string) ([]byte, error)
+	// link links the identity to the user.
+	link(ctx context.Context, provider, subject, userID, email string) error
+	// saveState stores an authorization request with its state key.
+	saveState(ctx context.Context, key string, state *oidcState) error
+	// takeState removes the request of the state key and returns it, nil when missing.
+	takeState(ctx context.Context, key string) (*oidcState, error)
+}
+
+// oidcDB is the database oidcStore.
+type oidcDB struct{}
+
+// oidcState is an authorization request waiting for its callback.
+type oidcState struct {
+	provider string
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+var (
+	// configured providers by name
+	oidcProviders map[string]*oidcProvider = make(map[string]*oidcProvider)
+
+	// client for issuer requests
+	oidcClient *http.Client = &http.Client{Timeout: 10 * time.Second}
+
+	// users of external identities
+	oidcUsers oidcStore = oidcDB{}
+
+	// json format schemes
+	oidcStartScheme *jin.Scheme = jin.MakeScheme("url", "state")
+)
+
+// oidcInit reads the provider list from oidc environment file.
+// format:
+//
+//	providers           = example
+//	example_issuer      = https://id.example.com
+//	example_client_id   = ...
+//	example_secret      = ...
+//	example_redirect    = https://localhost:5436/oidc/callback
+//	example_scopes      = openid email profile
+func oidcInit() {
+	env, err := config.Map(config.Source{Path: envOIDCDir})
+	if err != nil {
+		log.Println(oidcDisabled)
+		return
+	}
+	for _, name := range config.List(env["providers"]) {
+		provider := &oidcProvider{
+			name:         name,
+			issuer:       strings.TrimRight(env[name+"_issuer"], "/"),
+			clientID:     env[name+"_client_id"],
+			clientSecret: env[name+"_secret"],
+			redirectURI:  env[name+"_redirect"],
+			scopes:       env[name+"_scopes"],
+		}
+		if provider.issuer == "" || provider.clientID == "" || provider.redirectURI == "" {
+			log.Fatalln(srvConfigErr, oidcBadProvider.Wrap(errors.New(name)))
+		}
+		if provider.scopes == "" {
+			provider.scopes = "openid email profile"
+		}
+		oidcProviders[name] = provider
+	}
+}
+
+// oidcStartHandle creates an authorization code request with pkce.
+// request: {"provider": "example"}
+// response: {"url": "...", "state": "..."}
+func oidcStartHandle(w http.ResponseWriter, r *http.Request) {
+	body, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	name, _ := jsonText(body, "provider")
+	provider, exists := oidcProviders[name]
+	if !exists {
+		failHandle(w, oidcNoProvider, http.StatusBadRequest)
+		return
+	}
+	discovery, err := provider.discover()
+	if err != nil {
+		failHandle(w, err, http.StatusBadGateway)
+		return
+	}
+	state, err := randomToken(32)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomToken(32)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	verifier, err := randomToken(48)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", provider.clientID)
+	values.Set("redirect_uri", provider.redirectURI)
+	values.Set("scope", provider.scopes)
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	values.Set("code_challenge_method", "S256")
+
+	// callback may arrive to another instance, state is kept in the store
+	err = oidcUsers.saveState(r.Context(), state, &oidcState{
+		provider: name,
+		verifier: verifier,
+		nonce:    nonce,
+		expires:  time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	authURL := discovery.AuthorizationEndpoint
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + values.Encode()
+	} else {
+		authURL += "?" + values.Encode()
+	}
+	doneHandle(w, oidcStartScheme.MakeJson(authURL, state))
+}
+
+// oidcCallbackHandle exchanges the authorization code and logs the user in.
+// response is same with the password login.
+// request: {"state": "...", "code": "..."}
+func oidcCallbackHandle(w http.ResponseWriter, r *http.Request) {
+	body, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	stateKey, _ := jsonText(body, "state")
+	code, _ := jsonText(body, "code")
+	if stateKey == "" || code == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	record, status, err := oidcLogin(r.Context(), stateKey, code)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	// external login does not skip the second factor
+	challenge, err := mfaChallenge(r.Context(), record)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil {
+		mfaHandle(w, challenge)
+		return
+	}
+	doneHandle(w, record)
+}
+
+// oidcLogin completes the authorization request of the state,
+// returns the login record of the user.
+func oidcLogin(ctx context.Context, stateKey, code string) ([]byte, int, error) {
+	// state is single use
+	state, err := oidcUsers.takeState(ctx, stateKey)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if state == nil || time.Now().After(state.expires) {
+		return nil, http.StatusBadRequest, oidcBadState
+	}
+	provider := oidcProviders[state.provider]
+	idToken, err := provider.exchange(code, state.verifier)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	claims, err := provider.verify(idToken, state.nonce)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	record, err := oidcAccount(ctx, provider.name, claims)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	return record, http.StatusOK, nil
+}
+
+// oidcAccount finds the user of an external identity.
+// identities are linked to existing users by verified email,
+// a new user is created on first login otherwise.
+func oidcAccount(ctx context.Context, provider string, claims *oidcClaims) ([]byte, error) {
+	// already linked
+	userID, err := oidcUsers.identity(ctx, provider, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if userID != "" {
+		return oidcUsers.user(ctx, "user_id", userID)
+	}
+	// linking and account creation both trust the email.
+	if !claims.emailVerified() {
+		return nil, oidcUnverified
+	}
+	email := strings.ToLower(claims.Email)
+	record, err := oidcUsers.user(ctx, "email", email)
+	if err == recordNotExist {
+		record, err = oidcUsers.create(ctx, email)
+		if err == nil {
+			log.Println(oidcCreated, provider, claims.Subject)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	userID, err = jsonText(record, "user_id")
+	if err != nil {
+		return nil, err
+	}
+	err = oidcUsers.link(ctx, provider, claims.Subject, userID, email)
+	if err != nil {
+		return nil, err
+	}
+	log.Println(oidcLinked, provider, claims.Subject)
+	return record, nil
+}
+
+func (oidcDB) identity(ctx context.Context, provider, subject string) (string, error) {
+	query := seecool.Select(conf.IdentityTable, "user_id").
+		Equal("provider", provider).
+		Equal("subject", subject)
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return "", err
+	}
+	userID, err := jsonText(result, "0", "user_id")
+	if err != nil {
+		return "", nil
+	}
+	return userID, nil
+}
+
+func (oidcDB) user(ctx context.Context, column, value string) ([]byte, error) {
+	return userRecord(ctx, base, column, value)
+}
+
+// create stores an empty password, password login never matches it.
+func (oidcDB) create(ctx context.Context, email string) ([]byte, error) {
+	username, err := oidcUsername(email)
+	if err != nil {
+		return nil, err
+	}
+	query := seecool.Insert(conf.UserTable).
+		Keys("username", "email", "password").
+		Values(username, email, "")
+	_, err = trace.Exec(ctx, base, query.String())
+	if err != nil {
+		return nil, err
+	}
+	return userRecord(ctx, base, "email", email)
+}
+
+func (oidcDB) link(ctx context.Context, provider, subject, userID, email string) error {
+	query := seecool.Insert(conf.IdentityTable).
+		Keys("provider", "subject", "user_id", "email").
+		Values(provider, subject, userID, email)
+	_, err := trace.Exec(ctx, base, query.String())
+	return err
+}
+
+// saveState also drops the expired requests.
+func (oidcDB) saveState(ctx context.Context, key string, state *oidcState) error {
+	query := seecool.Delete(conf.StateTable).Cond("expires", "<", strconv.FormatInt(time.Now().Unix(), 10))
+	_, err := trace.Exec(ctx, base, query.String())
+	if err != nil {
+		return err
+	}
+	query = seecool.Insert(conf.StateTable).
+		Keys("state_hash", "provider", "verifier", "nonce", "expires").
+		Values(apiKeyHash(key), state.provider, state.verifier, state.nonce, strconv.FormatInt(state.expires.Unix(), 10))
+	_, err = trace.Exec(ctx, base, query.String())
+	return err
+}
+
+// takeState reads the request and deletes it with a single row check,
+// concurrent callbacks can not both use the state.
+func (oidcDB) takeState(ctx context.Context, key string) (*oidcState, error) {
+	hash := apiKeyHash(key)
+	query := seecool.Select(conf.StateTable, "provider", "verifier", "nonce", "expires").Equal("state_hash", hash)
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := jsonText(result, "0", "provider")
+	if err != nil {
+		return nil, nil
+	}
+	verifier, _ := jsonText(result, "0", "verifier")
+	nonce, _ := jsonText(result, "0", "nonce")
+	expires, _ := jsonText(result, "0", "expires")
+	deadline, _ := strconv.ParseInt(expires, 10, 64)
+	query = seecool.Delete(conf.StateTable).Equal("state_hash", hash)
+	deleted, err := trace.Exec(ctx, base, query.String())
+	if err != nil {
+		return nil, err
+	}
+	rows, err := deleted.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows != 1 {
+		return nil, nil
+	}
+	return &oidcState{provider: provider, verifier: verifier, nonce: nonce, expires: time.Unix(deadline, 0)}, nil
+}
+
+// userRecord returns the login response record of a single user.
+func userRecord(ctx context.Context, db *sql.DB, column, value string) ([]byte, error) {
+	query := seecool.Select(conf.UserTable, retColumns...).Equal(column, value)
+	result, err := trace.QueryJson(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	lenr, err := jin.Length(result)
+	if err != nil || lenr == 0 {
+		return nil, recordNotExist
+	}
+	if lenr > 1 {
+		return nil, moreExist
+	}
+	return jin.Get(result, "0")
+}
+
+// oidcUsername creates a free username from email local part.
+func oidcUsername(email string) (string, error) {
+	local := email
+	if i := strings.Index(local, "@"); i > 0 {
+		local = local[:i]
+	}
+	if len(local) > 20 {
+		local = local[:20]
+	}
+	suffix, err := randomToken(4)
+	if err != nil {
+		return "", err
+	}
+	// username must be longer than 4 characters
+	return local + "_" + suffix, nil
+}
+
+// emailVerified accepts both boolean and string claim values,
+// some providers send "true".
+func (c *oidcClaims) emailVerified() bool {
+	if c.Email == "" {
+		return false
+	}
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// audience checks the 'aud' claim, it can be a string or an array.
+func (c *oidcClaims) audience(clientID string) bool {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return single == clientID
+	}
+	var list []string
+	if json.Unmarshal(c.Audience, &list) == nil {
+		for _, aud := range list {
+			if aud == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discover returns the cached discovery document of the provider.
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.fetched) < oidcCacheTTL {
+		return p.discovery, nil
+	}
+	discovery := &oidcDiscovery{}
+	err := oidcGet(p.issuer+"/.well-known/openid-configuration", discovery)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != p.issuer {
+		return nil, oidcBadIssuer
+	}
+	p.discovery = discovery
+	p.keys = nil
+	p.fetched = time.Now()
+	return discovery, nil
+}
+
+// key returns the signing key with the key id.
+// keys are fetched again for an unknown key id, providers rotate keys,
+// but not more often than oidcKeyRefetch.
+func (p *oidcProvider) key(kid string) (*rsa.PublicKey, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, exists := p.keys[kid]; exists {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetched) < oidcKeyRefetch {
+		return nil, oidcBadToken
+	}
+	set := struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+	err = oidcGet(discovery.JwksURI, &set)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = make(map[string]*rsa.PublicKey)
+	p.keysFetched = time.Now()
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	key, exists := p.keys[kid]
+	if !exists {
+		return nil, oidcBadToken
+	}
+	return key, nil
+}
+
+// exchange trades the authorization code with the id token.
+func (p *oidcProvider) exchange(code, verifier string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURI)
+	form.Set("client_id", p.clientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", oidcExchange.Wrap(errors.New(string(body)))
+	}
+	token := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", err
+	}
+	if token.IDToken == "" {
+		return "", oidcExchange
+	}
+	return token.IDToken, nil
+}
+
+// verify checks the signature and the claims of an RS256 id token.
+func (p *oidcProvider) verify(idToken, nonce string) (*oidcClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, oidcBadToken
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(raw, &header) != nil {
+		return nil, oidcBadToken
+	}
+	// never accept 'none' or hmac algorithms
+	if header.Alg != "RS256" {
+		return nil, oidcBadToken
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, oidcBadToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, oidcBadToken
+	}
+	claims := &oidcClaims{}
+	raw, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(raw, claims) != nil {
+		return nil, oidcBadToken
+	}
+	now := time.Now()
+	switch {
+	case strings.TrimRight(claims.Issuer, "/") != p.issuer:
+		return nil, oidcBadIssuer
+	case !claims.audience(p.clientID):
+		return nil, oidcBadToken
+	case now.After(time.Unix(claims.Expires, 0).Add(oidcLeeway)):
+		return nil, oidcBadToken
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(oidcLeeway)):
+		return nil, oidcBadToken
+	case claims.Nonce != nonce:
+		return nil, oidcBadToken
+	case claims.Subject == "":
+		return nil, oidcBadToken
+	}
+	return claims, nil
+}
+
+// oidcGet reads a json document of the issuer.
+func oidcGet(address string, v interface{}) error {
+	resp, err := oidcClient.Get(address)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return oidcBadIssuer
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"ecomm/internal/apierr"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubIssuer is an oidc issuer with a single signing key.
+type stubIssuer struct {
+	srv      *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	jwksHits int
+
+	// the token endpoint accepts only this code and the verifier of challenge
+	code      string
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := &stubIssuer{key: key, kid: "key-1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 is.srv.URL,
+			"authorization_endpoint": is.srv.URL + "/authorize",
+			"token_endpoint":         is.srv.URL + "/token",
+			"jwks_uri":               is.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		is.jwksHits++
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": is.kid,
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != is.code {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != is.challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id_token": is.sign(t, is.claims)})
+	})
+	is.srv = httptest.NewServer(mux)
+	t.Cleanup(is.srv.Close)
+	return is
+}
+
+// sign creates an RS256 id token.
+func (is *stubIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": is.kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, is.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// provider registers a provider of the issuer.
+func (is *stubIssuer) provider(t *testing.T) *oidcProvider {
+	p := &oidcProvider{
+		name:        "stub",
+		issuer:      is.srv.URL,
+		clientID:    "client-1",
+		redirectURI: "https://localhost/oidc/callback",
+		scopes:      "openid email",
+	}
+	oidcProviders[p.name] = p
+	t.Cleanup(func() { delete(oidcProviders, p.name) })
+	return p
+}
+
+// validClaims are accepted claims for the nonce.
+func (is *stubIssuer) validClaims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            is.srv.URL,
+		"sub":            "subject-1",
+		"aud":            "client-1",
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "Ada@Example.com",
+		"email_verified": true,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// memStore is an in memory oidcStore.
+type memStore struct {
+	users      []map[string]string
+	identities map[string]string
+	states     map[string]*oidcState
+	created    int
+}
+
+func useMemStore(t *testing.T, users ...map[string]string) *memStore {
+	store := &memStore{users: users, identities: make(map[string]string), states: make(map[string]*oidcState)}
+	previous := oidcUsers
+	oidcUsers = store
+	t.Cleanup(func() { oidcUsers = previous })
+	return store
+}
+
+func (m *memStore) identity(ctx context.Context, provider, subject string) (string, error) {
+	return m.identities[provider+"/"+subject], nil
+}
+
+func (m *memStore) user(ctx context.Context, column, value string) ([]byte, error) {
+	for _, user := range m.users {
+		if user[column] == value {
+			return json.Marshal(user)
+		}
+	}
+	return nil, recordNotExist
+}
+
+func (m *memStore) create(ctx context.Context, email string) ([]byte, error) {
+	m.created++
+	m.users = append(m.users, map[string]string{"user_id": "new-user", "type": "standart", "email": email})
+	return m.user(ctx, "email", email)
+}
+
+func (m *memStore) link(ctx context.Context, provider, subject, userID, email string) error {
+	m.identities[provider+"/"+subject] = userID
+	return nil
+}
+
+func (m *memStore) saveState(ctx context.Context, key string, state *oidcState) error {
+	m.states[key] = state
+	return nil
+}
+
+func (m *memStore) takeState(ctx context.Context, key string) (*oidcState, error) {
+	state := m.states[key]
+	delete(m.states, key)
+	return state, nil
+}
+
+// startLogin runs the start handler, returns the state and the authorization url query.
+func startLogin(t *testing.T, provider string) (string, url.Values) {
+	req := httptest.NewRequest(http.MethodPost, "/oidc/start", strings.NewReader(`{"provider":"`+provider+`"}`))
+	rec := httptest.NewRecorder()
+	oidcStartHandle(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("start status %d: %s", rec.Code, rec.Body.String())
+	}
+	envelope := apierr.Envelope{}
+	data := struct {
+		URL   string `json:"url"`
+		State string `json:"state"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := url.Parse(data.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data.State, authURL.Query()
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	is := newStubIssuer(t)
+	p := is.provider(t)
+
+	discovery, err := p.discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if discovery.TokenEndpoint != is.srv.URL+"/token" || discovery.JwksURI != is.srv.URL+"/jwks" {
+		t.Fatalf("unexpected discovery %+v", discovery)
+	}
+	for i := 0; i < 2; i++ {
+		key, err := p.key(is.kid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.N.Cmp(is.key.N) != 0 || key.E != is.key.E {
+			t.Fatal("fetched key does not match the issuer key")
+		}
+	}
+	if is.jwksHits != 1 {
+		t.Fatalf("keys fetched %d times, want 1", is.jwksHits)
+	}
+}
+
+func TestOIDCUnknownKeyRefetch(t *testing.T) {
+	is := newStubIssuer(t)
+	p := is.provider(t)
+
+	if _, err := p.key(is.kid); err != nil {
+		t.Fatal(err)
+	}
+	// unknown key ids right after a fetch are rejected without a request
+	for i := 0; i < 3; i++ {
+		if _, err := p.key("rotated"); err != oidcBadToken {
+			t.Fatalf("got %v, want %v", err, oidcBadToken)
+		}
+	}
+	if is.jwksHits != 1 {
+		t.Fatalf("keys fetched %d times, want 1", is.jwksHits)
+	}
+	p.keysFetched = p.keysFetched.Add(-oidcKeyRefetch)
+	if _, err := p.key("rotated"); err != oidcBadToken {
+		t.Fatalf("got %v, want %v", err, oidcBadToken)
+	}
+	if is.jwksHits != 2 {
+		t.Fatalf("keys fetched %d times, want 2", is.jwksHits)
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	is := newStubIssuer(t)
+	is.provider(t)
+	useMemStore(t, map[string]string{"user_id": "user-1", "type": "standart", "email": "ada@example.com"})
+
+	state, query := startLogin(t, "stub")
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "client-1" {
+		t.Fatalf("unexpected authorization request %v", query)
+	}
+	is.code = "code-1"
+	is.challenge = query.Get("code_challenge")
+	is.claims = is.validClaims(query.Get("nonce"))
+
+	record, status, err := oidcLogin(context.Background(), state, is.code)
+	if err != nil {
+		t.Fatalf("login failed %d: %v", status, err)
+	}
+	userID, _ := jsonText(record, "user_id")
+	if userID != "user-1" {
+		t.Fatalf("logged in as %q, want user-1", userID)
+	}
+	// state is single use
+	_, status, err = oidcLogin(context.Background(), state, is.code)
+	if err != oidcBadState || status != http.StatusBadRequest {
+		t.Fatalf("reused state: got %d %v, want %v", status, err, oidcBadState)
+	}
+}
+
+func TestOIDCLoginVerifier(t *testing.T) {
+	is := newStubIssuer(t)
+	is.provider(t)
+	store := useMemStore(t)
+
+	state, query := startLogin(t, "stub")
+	is.code = "code-1"
+	is.challenge = query.Get("code_challenge")
+	is.claims = is.validClaims(query.Get("nonce"))
+
+	// a verifier that does not match the challenge is refused by the issuer
+	store.states[state].verifier = "wrong-verifier"
+	_, status, err := oidcLogin(context.Background(), state, is.code)
+	if err == nil || status != http.StatusBadGateway {
+		t.Fatalf("got %d %v, want exchange failure", status, err)
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	is := newStubIssuer(t)
+	p := is.provider(t)
+	nonce := "nonce-1"
+
+	tests := []struct {
+		name   string
+		change func(claims map[string]interface{})
+		want   error
+	}{
+		{"valid", func(c map[string]interface{}) {}, nil},
+		{"audience list", func(c map[string]interface{}) { c["aud"] = []string{"other", "client-1"} }, nil},
+		{"bad nonce", func(c map[string]interface{}) { c["nonce"] = "other" }, oidcBadToken},
+		{"bad audience", func(c map[string]interface{}) { c["aud"] = "other" }, oidcBadToken},
+		{"bad issuer", func(c map[string]interface{}) { c["iss"] = "https://issuer.invalid" }, oidcBadIssuer},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-2 * oidcLeeway).Unix() }, oidcBadToken},
+		{"issued later", func(c map[string]interface{}) { c["iat"] = time.Now().Add(2 * oidcLeeway).Unix() }, oidcBadToken},
+		{"no subject", func(c map[string]interface{}) { delete(c, "sub") }, oidcBadToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := is.validClaims(nonce)
+			tt.change(claims)
+			_, err := p.verify(is.sign(t, claims), nonce)
+			if err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("bad signature", func(t *testing.T) {
+		token := is.sign(t, is.validClaims(nonce))
+		token = token[:len(token)-4] + "AAAA"
+		if _, err := p.verify(token, nonce); err != oidcBadToken {
+			t.Fatalf("got %v, want %v", err, oidcBadToken)
+		}
+	})
+}
+
+func TestOIDCAccount(t *testing.T) {
+	existing := map[string]string{"user_id": "user-1", "type": "standart", "email": "ada@example.com"}
+	claims := func(email string, verified bool) *oidcClaims {
+		return &oidcClaims{Subject: "subject-1", Email: email, EmailVerified: verified}
+	}
+
+	t.Run("link by verified email", func(t *testing.T) {
+		store := useMemStore(t, existing)
+		record, err := oidcAccount(context.Background(), "stub", claims("Ada@Example.com", true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userID, _ := jsonText(record, "user_id"); userID != "user-1" {
+			t.Fatalf("got user %q, want user-1", userID)
+		}
+		if store.identities["stub/subject-1"] != "user-1" || store.created != 0 {
+			t.Fatalf("identity not linked to the existing user: %v, created %d", store.identities, store.created)
+		}
+	})
+
+	t.Run("create new account", func(t *testing.T) {
+		store := useMemStore(t, existing)
+		record, err := oidcAccount(context.Background(), "stub", claims("grace@example.com", true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if email, _ := jsonText(record, "email"); email != "grace@example.com" {
+			t.Fatalf("got email %q", email)
+		}
+		if store.created != 1 || store.identities["stub/subject-1"] != "new-user" {
+			t.Fatalf("account not created and linked: %v, created %d", store.identities, store.created)
+		}
+	})
+
+	t.Run("already linked", func(t *testing.T) {
+		store := useMemStore(t, existing)
+		store.identities["stub/subject-1"] = "user-1"
+		record, err := oidcAccount(context.Background(), "stub", claims("", false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userID, _ := jsonText(record, "user_id"); userID != "user-1" {
+			t.Fatalf("got user %q, want user-1", userID)
+		}
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		store := useMemStore(t, existing)
+		_, err := oidcAccount(context.Background(), "stub", claims("ada@example.com", false))
+		if err != oidcUnverified {
+			t.Fatalf("got %v, want %v", err, oidcUnverified)
+		}
+		if len(store.identities) != 0 || store.created != 0 {
+			t.Fatal("unverified identity changed the store")
+		}
+	})
+}
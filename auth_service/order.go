@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"ecomm/internal/apierr"
+	"ecomm/internal/trace"
+	"encoding/json"
+	"log"
+	"net/http"
+	"seecool"
+	"strconv"
+	"time"
+)
+
+const (
+	// limits of an order
+	orderMaxItems    int = 50
+	orderMaxQuantity int = 99
+
+	// status of a new order
+	orderPlaced string = "placed"
+
+	// log strings
+	orderCreated string = "Order Placed For"
+)
+
+// orderItem is a product line of an order,
+// name and price are copied so catalog changes never change an order.
+type orderItem struct {
+	ProductID  string `json:"product_id"`
+	Name       string `json:"name"`
+	PriceCents int64  `json:"price_cents"`
+	Quantity   int    `json:"quantity"`
+}
+
+// order is the stored order, addresses are snapshots of the address book.
+type order struct {
+	OrderID    string          `json:"order_id"`
+	Status     string          `json:"status"`
+	Items      []orderItem     `json:"items"`
+	TotalCents int64           `json:"total_cents"`
+	Shipping   json.RawMessage `json:"shipping"`
+	Billing    json.RawMessage `json:"billing"`
+	Created    int64           `json:"created"`
+}
+
+// orderCreateHandle places an order of catalog products.
+// prices are read from the catalog, addresses are copied from the book,
+// later edits of either never change a placed order.
+// request: {"user_id": "...", "shipping_address_id": "...", "billing_address_id": "...", "items": [{"product_id": "...", "quantity": 1}]}
+func orderCreateHandle(w http.ResponseWriter, r *http.Request) {
+	body, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		UserID   string `json:"user_id"`
+		Shipping string `json:"shipping_address_id"`
+		Billing  string `json:"billing_address_id"`
+		Items    []struct {
+			ProductID string `json:"product_id"`
+			Quantity  int    `json:"quantity"`
+		} `json:"items"`
+	}
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		failHandle(w, apierr.InvalidJSON, http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		failHandle(w, emptyField.Detail("user_id", "required"), http.StatusBadRequest)
+		return
+	}
+	invalid := apierr.InvalidField
+	if req.Shipping == "" {
+		invalid = invalid.Detail("shipping_address_id", "required")
+	}
+	if req.Billing == "" {
+		invalid = invalid.Detail("billing_address_id", "required")
+	}
+	if len(req.Items) == 0 || len(req.Items) > orderMaxItems {
+		invalid = invalid.Detail("items", "1 to "+strconv.Itoa(orderMaxItems)+" products")
+	}
+	o := order{Status: orderPlaced, Items: make([]orderItem, 0, len(req.Items))}
+	listed := make(map[string]bool, len(req.Items))
+	for i, item := range req.Items {
+		path := "items." + strconv.Itoa(i)
+		if item.Quantity < 1 || item.Quantity > orderMaxQuantity {
+			invalid = invalid.Detail(path+".quantity", "must be 1 to "+strconv.Itoa(orderMaxQuantity))
+		}
+		if listed[item.ProductID] {
+			invalid = invalid.Detail(path+".product_id", "listed twice")
+			continue
+		}
+		listed[item.ProductID] = true
+		line, found, err := orderProduct(r.Context(), item.ProductID)
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			invalid = invalid.Detail(path+".product_id", "not on sale")
+			continue
+		}
+		line.Quantity = item.Quantity
+		o.Items = append(o.Items, line)
+		o.TotalCents += line.PriceCents * int64(line.Quantity)
+	}
+	if len(invalid.Details) > 0 {
+		failHandle(w, invalid, http.StatusBadRequest)
+		return
+	}
+	shipping, status, err := addressRecord(r.Context(), req.UserID, req.Shipping)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	billing, status, err := addressRecord(r.Context(), req.UserID, req.Billing)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	o.Shipping, o.Billing = shipping, billing
+	o.OrderID, err = newUUID()
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	o.Created = time.Now().Unix()
+	items, err := encodeJson(o.Items)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	query := seecool.Insert(conf.OrderTable).
+		Keys("order_id", "user_id", "status", "items", "total_cents", "shipping", "billing", "created").
+		Values(o.OrderID, req.UserID, o.Status, string(items), strconv.FormatInt(o.TotalCents, 10),
+			string(shipping), string(billing), strconv.FormatInt(o.Created, 10))
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(orderCreated, req.UserID)
+	response, err := encodeJson(o)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	doneHandle(w, response)
+}
+
+// orderProduct returns the product line of a product on sale.
+func orderProduct(ctx context.Context, productID string) (orderItem, bool, error) {
+	if !isUUID(productID) {
+		return orderItem{}, false, nil
+	}
+	query := seecool.Select(conf.ProductTable, "name", "price_cents").
+		Equal("product_id", productID).
+		Equal("active", "true")
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return orderItem{}, false, err
+	}
+	name, err := jsonText(result, "0", "name")
+	if err != nil {
+		return orderItem{}, false, nil
+	}
+	price, _ := jsonText(result, "0", "price_cents")
+	cents, err := strconv.ParseInt(price, 10, 64)
+	if err != nil {
+		return orderItem{}, false, err
+	}
+	return orderItem{ProductID: productID, Name: name, PriceCents: cents}, true, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestOrderInvalid covers requests that are rejected before the database,
+// product ids that are not uuids are never looked up.
+func TestOrderInvalid(t *testing.T) {
+	addresses := `"user_id":"user-1","shipping_address_id":"a-1","billing_address_id":"a-2"`
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"empty", `{"user_id":"user-1"}`, []string{"shipping_address_id", "billing_address_id", "items"}},
+		{"no items", `{` + addresses + `,"items":[]}`, []string{"items"}},
+		{"quantity", `{` + addresses + `,"items":[{"product_id":"p-1","quantity":0}]}`, []string{"items.0.quantity", "items.0.product_id"}},
+		{"listed twice", `{` + addresses + `,"items":[{"product_id":"p-1","quantity":1},{"product_id":"p-1","quantity":1}]}`, []string{"items.0.product_id", "items.1.product_id"}},
+		{"too many", `{` + addresses + `,"items":[` + strings.Repeat(`{"quantity":1},`, orderMaxItems) + `{"quantity":1}]}`, []string{"items"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			orderCreateHandle(rec, httptest.NewRequest(http.MethodPost, "/order/create", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want 400: %s", rec.Code, rec.Body.String())
+			}
+			var envelope struct {
+				Error struct {
+					Details map[string]string `json:"details"`
+				} `json:"error"`
+			}
+			err := json.Unmarshal(rec.Body.Bytes(), &envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range tt.fields {
+				if envelope.Error.Details[field] == "" {
+					t.Fatalf("no detail for %s in %v", field, envelope.Error.Details)
+				}
+			}
+		})
+	}
+}
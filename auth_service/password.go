@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+const (
+	// stored form: pbkdf2_sha256$<iterations>$<salt>$<key>
+	passwordScheme string = "pbkdf2_sha256"
+	// owasp recommendation for pbkdf2 with sha256
+	passwordIterations int = 600000
+	passwordSaltLen    int = 16
+	passwordKeyLen     int = 32
+)
+
+// passwordHash returns the stored form of a password.
+func passwordHash(password string) (string, error) {
+	salt := make([]byte, passwordSaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	return passwordDerive(password, salt, passwordIterations)
+}
+
+func passwordDerive(password string, salt []byte, iterations int) (string, error) {
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, passwordKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return passwordScheme + "$" + strconv.Itoa(iterations) + "$" +
+		base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(key), nil
+}
+
+// passwordMatch compares the password with its stored form.
+// rows written before hashing keep plain passwords, they are compared as they are.
+// empty stored passwords never match, accounts of external identities have none.
+func passwordMatch(stored, password string) bool {
+	if stored == "" {
+		return false
+	}
+	parts := strings.Split(stored, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	derived, err := passwordDerive(password, salt, iterations)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(derived)) == 1
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordMatch(t *testing.T) {
+	stored, err := passwordHash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, passwordScheme+"$") || strings.Contains(stored, "correct horse") {
+		t.Fatalf("unexpected stored form %q", stored)
+	}
+	// users column is VARCHAR(128)
+	if len(stored) > 128 {
+		t.Fatalf("stored form has %d characters", len(stored))
+	}
+	again, err := passwordHash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == stored {
+		t.Fatal("same salt used twice")
+	}
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		want     bool
+	}{
+		{"hashed", stored, "correct horse", true},
+		{"hashed wrong", stored, "correct horse ", false},
+		{"plain row", "secret123", "secret123", true},
+		{"plain row wrong", "secret123", "secret124", false},
+		{"no password", "", "", false},
+		{"broken salt", passwordScheme + "$1$!!$key", "x", false},
+		{"broken iterations", passwordScheme + "$0$c2FsdA$key", "x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := passwordMatch(tt.stored, tt.password); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
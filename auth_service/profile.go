@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"ecomm/internal/apierr"
+	"ecomm/internal/trace"
+	"jin"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"seecool"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// password length limits, only the hash is stored
+	passwordMin int = 8
+	passwordMax int = 64
+	// email length limits of the users table
+	emailMin int = 6
+	emailMax int = 64
+
+	// verification link of the web site, token is appended
+	emailVerifyPath string = "/verify-email?token="
+
+	// log strings
+	profileUpdated  string = "Profile Updated For"
+	passwordChanged string = "Password Changed For"
+	emailRequested  string = "Email Change Requested For"
+	emailChanged    string = "Email Changed For"
+)
+
+var (
+	// self-service columns and their maximum lengths,
+	// other columns like 'type' or 'email' can not be set with a profile update
+	profileColumns map[string]int = map[string]int{
+		"first_name": 32,
+		"last_name":  32,
+		"gender":     6,
+		"country":    64,
+		"city":       64,
+		"birth_date": 10,
+	}
+	// accepted gender values
+	profileGenders map[string]bool = map[string]bool{"female": true, "male": true, "other": true}
+	// oldest accepted birth date, same with the table check
+	birthDateMin time.Time = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// returned profile columns, password is never returned
+	profileRead []string = []string{"user_id", "username", "email", "type", "first_name", "last_name", "gender", "country", "city", "birth_date"}
+
+	// json format schemes
+	profileScheme      *jin.Scheme = jin.MakeScheme("profile", "email_pending")
+	emailPendingScheme *jin.Scheme = jin.MakeScheme("email_pending")
+	emailScheme        *jin.Scheme = jin.MakeScheme("email")
+)
+
+// profileHandle returns the profile of the user
+// with the email address that waits for verification.
+// request: {"user_id": "..."}
+func profileHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	if userID == "" {
+		failHandle(w, emptyField.Detail("user_id", "required"), http.StatusBadRequest)
+		return
+	}
+	profile, status, err := profileRecord(r.Context(), userID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	pending, err := emailPending(r.Context(), userID)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	doneHandle(w, profileScheme.MakeJson(string(profile), pending))
+}
+
+// profileUpdateHandle updates self-service columns of the user.
+// every problem is reported at once, nothing is written when one exists.
+// request: {"user_id": "...", "first_name": "...", "city": "..."}
+func profileUpdateHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	fields, err := jin.GetMap(json)
+	if err != nil {
+		failHandle(w, apierr.InvalidJSON, http.StatusBadRequest)
+		return
+	}
+	userID := fields["user_id"]
+	if userID == "" {
+		failHandle(w, emptyField.Detail("user_id", "required"), http.StatusBadRequest)
+		return
+	}
+	delete(fields, "user_id")
+	invalid := apierr.InvalidField
+	keys := make([]string, 0, len(fields))
+	values := make([]string, 0, len(fields))
+	for key, value := range fields {
+		value = strings.TrimSpace(value)
+		if problem := profileProblem(key, value); problem != "" {
+			invalid = invalid.Detail(key, problem)
+			continue
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	if len(invalid.Details) > 0 {
+		failHandle(w, invalid, http.StatusBadRequest)
+		return
+	}
+	if len(keys) == 0 {
+		failHandle(w, emptyField.Detail("body", "no profile field"), http.StatusBadRequest)
+		return
+	}
+	query := seecool.Update(conf.UserTable).
+		Keys(keys...).
+		Values(values...).
+		Equal("user_id", userID)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(profileUpdated, userID)
+	profile, status, err := profileRecord(r.Context(), userID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	pending, err := emailPending(r.Context(), userID)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	doneHandle(w, profileScheme.MakeJson(string(profile), pending))
+}
+
+// profileProblem returns why a profile value is not accepted,
+// empty when it is valid.
+func profileProblem(key, value string) string {
+	limit, ok := profileColumns[key]
+	if !ok {
+		return "not editable"
+	}
+	if utf8.RuneCountInString(value) > limit {
+		return "at most " + strconv.Itoa(limit) + " characters"
+	}
+	switch key {
+	case "gender":
+		if !profileGenders[value] {
+			return "must be one of female, male, other"
+		}
+	case "birth_date":
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return "must be a date like 1990-12-31"
+		}
+		if !date.After(birthDateMin) || date.After(time.Now()) {
+			return "must be between 1900-01-01 and today"
+		}
+	}
+	return ""
+}
+
+// passwordChangeHandle replaces the password after checking the current one.
+// request: {"user_id": "...", "current_password": "...", "new_password": "..."}
+func passwordChangeHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	current, _ := jin.GetString(json, "current_password")
+	next, _ := jin.GetString(json, "new_password")
+	if userID == "" || current == "" || next == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	length := utf8.RuneCountInString(next)
+	if length < passwordMin || length > passwordMax {
+		failHandle(w, apierr.InvalidField.Detail("new_password", "must be "+strconv.Itoa(passwordMin)+" to "+strconv.Itoa(passwordMax)+" characters"), http.StatusBadRequest)
+		return
+	}
+	if next == current {
+		failHandle(w, apierr.InvalidField.Detail("new_password", "must differ from the current password"), http.StatusBadRequest)
+		return
+	}
+	status, err := checkPassword(r.Context(), userID, current)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	hash, err := passwordHash(next)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	query := seecool.Update(conf.UserTable).
+		Keys(conf.PassKey).
+		Values(hash).
+		Equal("user_id", userID)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(passwordChanged, userID)
+	doneHandle(w, []byte("null"))
+}
+
+// emailChangeHandle starts an email change, the address is changed
+// only after the link sent to the new address is opened.
+// a newer request replaces the pending one.
+// request: {"user_id": "...", "email": "...", "password": "..."}
+func emailChangeHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := jsonText(json, "user_id")
+	email, _ := jin.GetString(json, "email")
+	password, _ := jin.GetString(json, "password")
+	if userID == "" || email == "" || password == "" {
+		failHandle(w, emptyField, http.StatusBadRequest)
+		return
+	}
+	email, ok = emailAddress(email)
+	if !ok {
+		failHandle(w, apierr.InvalidField.Detail("email", "must be a valid address"), http.StatusBadRequest)
+		return
+	}
+	status, err := checkPassword(r.Context(), userID, password)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	status, err = emailFree(r.Context(), email, userID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	query := seecool.Delete(conf.EmailTable).Equal("user_id", userID)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	expires := time.Now().Add(conf.EmailTTL).Unix()
+	query = seecool.Insert(conf.EmailTable).
+		Keys("user_id", "email", "token_hash", "expires").
+		Values(userID, email, apiKeyHash(token), strconv.FormatInt(expires, 10))
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	link := strings.TrimRight(conf.WebURL, "/") + emailVerifyPath + url.QueryEscape(token)
+	body := "Open the link below to use this address for your account.\n\n" + link +
+		"\n\nThe link expires in " + conf.EmailTTL.String() + ". If you did not ask for it, ignore this mail."
+	err = mailer.Send(email, "Verify your email address", body)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(emailRequested, userID)
+	doneHandle(w, emailPendingScheme.MakeJson(email))
+}
+
+// emailVerifyHandle completes an email change with the token of the link.
+// the user does not need to be logged in, the token proves the request.
+// request: {"token": "..."}
+func emailVerifyHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	token, _ := jin.GetString(json, "token")
+	if token == "" {
+		failHandle(w, emptyField.Detail("token", "required"), http.StatusBadRequest)
+		return
+	}
+	query := seecool.Select(conf.EmailTable, "user_id", "email", "expires").Equal("token_hash", apiKeyHash(token))
+	result, err := trace.QueryJson(r.Context(), base, query)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	userID, err := jsonText(result, "0", "user_id")
+	if err != nil {
+		failHandle(w, emailBadToken, http.StatusBadRequest)
+		return
+	}
+	email, _ := jsonText(result, "0", "email")
+	expires, _ := jsonText(result, "0", "expires")
+	deadline, _ := strconv.ParseInt(expires, 10, 64)
+	if time.Now().Unix() > deadline {
+		failHandle(w, emailBadToken, http.StatusBadRequest)
+		return
+	}
+	// the address may be taken while the link was waiting
+	status, err := emailFree(r.Context(), email, userID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	query = seecool.Update(conf.UserTable).
+		Keys("email").
+		Values(email).
+		Equal("user_id", userID)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	query = seecool.Delete(conf.EmailTable).Equal("user_id", userID)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println(emailChanged, userID)
+	doneHandle(w, emailScheme.MakeJson(email))
+}
+
+// profileRecord returns profile columns of the user.
+func profileRecord(ctx context.Context, userID string) ([]byte, int, error) {
+	query := seecool.Select(conf.UserTable, profileRead...).Equal("user_id", userID)
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	record, err := jin.Get(result, "0")
+	if err != nil {
+		return nil, http.StatusNotFound, recordNotExist
+	}
+	return record, http.StatusOK, nil
+}
+
+// checkPassword compares the password with the stored one of the user.
+func checkPassword(ctx context.Context, userID, password string) (int, error) {
+	query := seecool.Select(conf.UserTable, conf.PassKey).Equal("user_id", userID)
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	correct, err := jin.GetString(result, "0", conf.PassKey)
+	if err != nil {
+		return http.StatusNotFound, recordNotExist
+	}
+	if !passwordMatch(correct, password) {
+		return http.StatusUnauthorized, wrongPassword
+	}
+	return http.StatusOK, nil
+}
+
+// emailPending returns the address that waits for verification,
+// empty when there is none or it expired.
+func emailPending(ctx context.Context, userID string) (string, error) {
+	query := seecool.Select(conf.EmailTable, "email", "expires").Equal("user_id", userID)
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return "", err
+	}
+	email, err := jsonText(result, "0", "email")
+	if err != nil {
+		return "", nil
+	}
+	expires, _ := jsonText(result, "0", "expires")
+	deadline, _ := strconv.ParseInt(expires, 10, 64)
+	if time.Now().Unix() > deadline {
+		return "", nil
+	}
+	return email, nil
+}
+
+// emailFree fails when a user already has the address.
+func emailFree(ctx context.Context, email, userID string) (int, error) {
+	record, err := userRecord(ctx, base, "email", email)
+	if err == recordNotExist {
+		return http.StatusOK, nil
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if owner, _ := jsonText(record, "user_id"); owner == userID {
+		return http.StatusBadRequest, apierr.InvalidField.Detail("email", "same with the current address")
+	}
+	return http.StatusConflict, emailTaken
+}
+
+// emailAddress validates a bare address like 'user@example.com'
+// and returns it in the stored form.
+func emailAddress(email string) (string, bool) {
+	email = strings.TrimSpace(email)
+	if ciColumns["email"] {
+		email = strings.ToLower(email)
+	}
+	if len(email) < emailMin || len(email) > emailMax {
+		return "", false
+	}
+	address, err := netmail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", false
+	}
+	return email, true
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"ecomm/internal/openapi"
+	"net/http"
+)
+
+var (
+	// openapi document of the service, served at '/openapi.json'
+	apiDoc *openapi.Document = openapi.New("Authentication Service", "1.0.0")
+
+	// schemas of response data
+	// login records have retColumns, the password is never returned
+	userSchema *openapi.Schema = openapi.Object(map[string]*openapi.Schema{
+		"*user_id": openapi.String("uuid of the user"),
+		"*type":    openapi.String("user type like 'standart' or 'admin'"),
+		"*email":   openapi.String(""),
+	})
+	challengeSchema *openapi.Schema = openapi.Object(map[string]*openapi.Schema{
+		"*mfa_token": openapi.String("token of the pending login, sent to '/mfa/login'"),
+	})
+	secondFactor map[string]*openapi.Schema = map[string]*openapi.Schema{
+		"code":          openapi.String("totp code, or 'recovery_code'"),
+		"recovery_code": openapi.String("one time recovery code, or 'code'"),
+	}
+	profileSchema *openapi.Schema = openapi.Object(map[string]*openapi.Schema{
+		"*profile": openapi.Object(map[string]*openapi.Schema{
+			"*user_id":   openapi.String(""),
+			"*username":  openapi.String(""),
+			"*email":     openapi.String(""),
+			"*type":      openapi.String(""),
+			"first_name": openapi.String(""),
+			"last_name":  openapi.String(""),
+			"gender":     openapi.Enum("", "female", "male", "other"),
+			"country":    openapi.String(""),
+			"city":       openapi.String(""),
+			"birth_date": openapi.String("date like 1990-12-31"),
+		}),
+		"*email_pending": openapi.String("address that waits for verification, empty when none"),
+	})
+	addressFields map[string]*openapi.Schema = map[string]*openapi.Schema{
+		"kind":        openapi.Enum("every kind has one default address", "shipping", "billing"),
+		"full_name":   openapi.String(""),
+		"line1":       openapi.String(""),
+		"line2":       openapi.String(""),
+		"city":        openapi.String(""),
+		"region":      openapi.String("state or province"),
+		"postal_code": openapi.String("checked against the format of the country"),
+		"country":     openapi.String("iso 3166 alpha-2 code like 'TR'"),
+		"phone":       openapi.String("e.164 like '+905551234567'"),
+		"is_default":  openapi.Bool(""),
+	}
+	addressSchema *openapi.Schema = openapi.Object(map[string]*openapi.Schema{
+		"*address_id":  openapi.String(""),
+		"*kind":        addressFields["kind"],
+		"*full_name":   addressFields["full_name"],
+		"*line1":       addressFields["line1"],
+		"*line2":       addressFields["line2"],
+		"*city":        addressFields["city"],
+		"*region":      addressFields["region"],
+		"*postal_code": addressFields["postal_code"],
+		"*country":     addressFields["country"],
+		"*phone":       addressFields["phone"],
+		"*is_default":  addressFields["is_default"],
+	})
+	orderSchema *openapi.Schema = openapi.Object(map[string]*openapi.Schema{
+		"*order_id": openapi.String(""),
+		"*status":   openapi.Enum("", "placed"),
+		"*items": openapi.Array(openapi.Object(map[string]*openapi.Schema{
+			"*product_id":  openapi.String(""),
+			"*name":        openapi.String("name when the order was placed"),
+			"*price_cents": openapi.Integer("price when the order was placed"),
+			"*quantity":    openapi.Integer(""),
+		})),
+		"*total_cents": openapi.Integer(""),
+		"*shipping":    addressSchema,
+		"*billing":     addressSchema,
+		"*created":     openapi.Integer("unix time"),
+	})
+	principalSchema *openapi.Schema = openapi.Object(map[string]*openapi.Schema{
+		"*user_id":    openapi.String(""),
+		"*type":       openapi.String(""),
+		"*email":      openapi.String(""),
+		"*scopes":     openapi.Array(openapi.String("")),
+		"*key_prefix": openapi.String(""),
+	})
+)
+
+// routes returns routes of the service,
+// built after configuration because login field names are configurable.
+func routes() []openapi.Route {
+	tags := []string{"auth"}
+	login := map[string]*openapi.Schema{
+		"*" + conf.PassKey: openapi.String("password of the user"),
+	}
+	for _, key := range append([]string{conf.IDKey, conf.PrimKey}, idColumns...) {
+		if key != "" {
+			login[key] = openapi.String("login identifier, one of the identifier fields is required")
+		}
+	}
+	address := func(required ...string) map[string]*openapi.Schema {
+		fields := make(map[string]*openapi.Schema)
+		for k, v := range addressFields {
+			fields[k] = v
+		}
+		for _, k := range required {
+			fields["*"+k] = fields[k]
+			delete(fields, k)
+		}
+		return fields
+	}
+	addressID := map[string]*openapi.Schema{"*address_id": openapi.String("")}
+	addressUpdate := address()
+	addressUpdate["*address_id"] = addressID["*address_id"]
+	withUser := func(properties map[string]*openapi.Schema) *openapi.Schema {
+		all := map[string]*openapi.Schema{"*user_id": openapi.String("user id, set by the gateway")}
+		for k, v := range properties {
+			all[k] = v
+		}
+		return openapi.Object(all)
+	}
+	post := func(path, summary string, body, data *openapi.Schema, errors ...int) openapi.Operation {
+		return openapi.Operation{
+			Method:  http.MethodPost,
+			Path:    path,
+			Summary: summary,
+			Tags:    tags,
+			Body:    body,
+			Data:    data,
+			Errors:  append(errors, http.StatusMethodNotAllowed, http.StatusInternalServerError),
+		}
+	}
+	route := func(handler http.HandlerFunc, op openapi.Operation) openapi.Route {
+		return openapi.Route{Pattern: op.Path, Handler: handler, Operations: []openapi.Operation{op}}
+	}
+	return []openapi.Route{
+		route(authHandle, post("/", "Password login, data is the user or an mfa challenge with status 'MFA Required'.",
+			openapi.Object(login), &openapi.Schema{OneOf: []*openapi.Schema{userSchema, challengeSchema}},
+			http.StatusBadRequest, http.StatusUnauthorized)),
+		route(signupHandle, post("/signup", "Creates a user with a password, data is the user. The user logs in afterwards.",
+			openapi.Object(map[string]*openapi.Schema{
+				"*username":  openapi.String("5 to 32 letters, digits, '_', '.' or '-'"),
+				"*email":     openapi.String(""),
+				"*password":  openapi.String("8 to 64 characters, only its hash is stored"),
+				"first_name": openapi.String("at most 32 characters"),
+				"last_name":  openapi.String("at most 32 characters"),
+			}), userSchema, http.StatusBadRequest, http.StatusConflict)),
+		route(mfaEnrollHandle, post("/mfa/enroll", "Creates a not yet enabled totp secret.",
+			withUser(nil), openapi.Object(map[string]*openapi.Schema{
+				"*secret": openapi.String("base32 totp secret"),
+				"*uri":    openapi.String("otpauth uri for qr codes"),
+			}), http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)),
+		route(mfaConfirmHandle, post("/mfa/confirm", "Enables mfa with the first valid code, returns recovery codes.",
+			withUser(map[string]*openapi.Schema{"*code": openapi.String("totp code")}),
+			openapi.Object(map[string]*openapi.Schema{"*recovery_codes": openapi.Array(openapi.String(""))}),
+			http.StatusBadRequest, http.StatusUnauthorized, http.StatusConflict)),
+		route(mfaDisableHandle, post("/mfa/disable", "Removes the second factor.",
+			withUser(secondFactor), nil, http.StatusBadRequest, http.StatusUnauthorized)),
+		route(mfaLoginHandle, post("/mfa/login", "Completes a pending login, data is the user.",
+			openapi.Object(map[string]*openapi.Schema{
+				"*mfa_token":    openapi.String(""),
+				"code":          secondFactor["code"],
+				"recovery_code": secondFactor["recovery_code"],
+			}), userSchema, http.StatusBadRequest, http.StatusUnauthorized)),
+		route(oidcStartHandle, post("/oidc/start", "Starts an external login, data has the provider url.",
+			openapi.Object(map[string]*openapi.Schema{"*provider": openapi.String("configured provider name")}),
+			openapi.Object(map[string]*openapi.Schema{"*url": openapi.String(""), "*state": openapi.String("")}),
+			http.StatusBadRequest, http.StatusBadGateway)),
+		route(oidcCallbackHandle, post("/oidc/callback", "Completes an external login, data is the user or an mfa challenge.",
+			openapi.Object(map[string]*openapi.Schema{"*state": openapi.String(""), "*code": openapi.String("")}),
+			&openapi.Schema{OneOf: []*openapi.Schema{userSchema, challengeSchema}},
+			http.StatusBadRequest, http.StatusUnauthorized, http.StatusBadGateway)),
+		route(apiKeyCreateHandle, post("/apikey/create", "Creates an api key, the plain key is returned only once.",
+			withUser(map[string]*openapi.Schema{
+				"*name":        openapi.String(""),
+				"*scopes":      openapi.Array(openapi.String("configured scope like 'data:read'")),
+				"expires_days": openapi.Integer("days until the key expires, never when empty"),
+			}), openapi.Object(map[string]*openapi.Schema{
+				"*key":     openapi.String("ecomm_<prefix>_<secret>"),
+				"*prefix":  openapi.String(""),
+				"*name":    openapi.String(""),
+				"*scopes":  openapi.Array(openapi.String("")),
+				"*expires": openapi.Integer("unix time, 0 is never"),
+			}), http.StatusBadRequest)),
+		route(apiKeyListHandle, post("/apikey/list", "Lists api keys of the user.",
+			withUser(nil), openapi.Array(openapi.Map(nil)), http.StatusBadRequest)),
+		route(apiKeyRevokeHandle, post("/apikey/revoke", "Revokes an api key of the user.",
+			withUser(map[string]*openapi.Schema{"*prefix": openapi.String("")}), nil,
+			http.StatusBadRequest, http.StatusNotFound)),
+		route(profileHandle, post("/profile", "Returns the profile of the user.",
+			withUser(nil), profileSchema, http.StatusBadRequest, http.StatusNotFound)),
+		route(profileUpdateHandle, post("/profile/update", "Updates self-service profile fields, returns the profile.",
+			withUser(map[string]*openapi.Schema{
+				"first_name": openapi.String("at most 32 characters"),
+				"last_name":  openapi.String("at most 32 characters"),
+				"gender":     openapi.Enum("", "female", "male", "other"),
+				"country":    openapi.String("at most 64 characters"),
+				"city":       openapi.String("at most 64 characters"),
+				"birth_date": openapi.String("date like 1990-12-31"),
+			}), profileSchema, http.StatusBadRequest, http.StatusNotFound)),
+		route(passwordChangeHandle, post("/password/change", "Replaces the password, the current password is required.",
+			withUser(map[string]*openapi.Schema{
+				"*current_password": openapi.String(""),
+				"*new_password":     openapi.String("8 to 64 characters"),
+			}), nil, http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound)),
+		route(emailChangeHandle, post("/email/change", "Sends a verification link to the new address, email changes when it is opened.",
+			withUser(map[string]*openapi.Schema{
+				"*email":    openapi.String("new address"),
+				"*password": openapi.String("current password"),
+			}), openapi.Object(map[string]*openapi.Schema{"*email_pending": openapi.String("")}),
+			http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict)),
+		route(emailVerifyHandle, post("/email/verify", "Completes an email change with the token of the verification link.",
+			openapi.Object(map[string]*openapi.Schema{"*token": openapi.String("")}),
+			openapi.Object(map[string]*openapi.Schema{"*email": openapi.String("")}),
+			http.StatusBadRequest, http.StatusConflict)),
+		route(addressListHandle, post("/address/list", "Lists addresses of the user.",
+			withUser(nil), openapi.Array(addressSchema), http.StatusBadRequest)),
+		route(addressCreateHandle, post("/address/create", "Adds an address, the first address of a kind becomes default.",
+			withUser(address(addressRequired...)), addressSchema, http.StatusBadRequest, http.StatusConflict)),
+		route(addressUpdateHandle, post("/address/update", "Changes columns of an address.",
+			withUser(addressUpdate), addressSchema, http.StatusBadRequest, http.StatusNotFound)),
+		route(addressDeleteHandle, post("/address/delete", "Removes an address, placed orders keep their snapshots.",
+			withUser(addressID), nil, http.StatusBadRequest, http.StatusNotFound)),
+		route(addressSnapshotHandle, post("/address/snapshot", "Returns a copy of an address for orders to store.",
+			withUser(addressID), openapi.Object(map[string]*openapi.Schema{
+				"*address":     addressSchema,
+				"*snapshot_at": openapi.Integer("unix time"),
+			}), http.StatusBadRequest, http.StatusNotFound)),
+		route(orderCreateHandle, post("/order/create", "Places an order, product prices and addresses are copied into it.",
+			withUser(map[string]*openapi.Schema{
+				"*shipping_address_id": openapi.String(""),
+				"*billing_address_id":  openapi.String(""),
+				"*items": openapi.Array(openapi.Object(map[string]*openapi.Schema{
+					"*product_id": openapi.String("product on sale"),
+					"*quantity":   openapi.Integer("1 to 99"),
+				})),
+			}), orderSchema, http.StatusBadRequest, http.StatusNotFound)),
+		route(apiKeyVerifyHandle, post("/apikey/verify", "Resolves an api key to its user and scopes.",
+			openapi.Object(map[string]*openapi.Schema{"*key": openapi.String("")}), principalSchema,
+			http.StatusUnauthorized)),
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"ecomm/internal/apierr"
+	"ecomm/internal/trace"
+	"jin"
+	"log"
+	"net/http"
+	"regexp"
+	"seecool"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// username length limits, same with the table check
+	usernameMin int = 5
+	usernameMax int = 32
+
+	// log strings
+	userCreated string = "User Created:"
+)
+
+var (
+	// usernames are also login identifiers, they can not look like an email
+	usernamePattern *regexp.Regexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+	// errors
+	usernameTaken *apierr.Error = apierr.New("username_taken", http.StatusConflict, "Username is used by another account")
+)
+
+// signupHandle creates a user with a password, the user logs in afterwards.
+// every problem is reported at once, nothing is written when one exists.
+// response is the login record of the new user.
+// request: {"username": "...", "email": "...", "password": "...", "first_name": "...", "last_name": "..."}
+func signupHandle(w http.ResponseWriter, r *http.Request) {
+	json, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+	fields, err := jin.GetMap(json)
+	if err != nil {
+		failHandle(w, apierr.InvalidJSON, http.StatusBadRequest)
+		return
+	}
+	username := strings.TrimSpace(fields["username"])
+	email := fields["email"]
+	password := fields["password"]
+	delete(fields, "username")
+	delete(fields, "email")
+	delete(fields, "password")
+
+	invalid := apierr.InvalidField
+	if length := utf8.RuneCountInString(username); length < usernameMin || length > usernameMax || !usernamePattern.MatchString(username) {
+		invalid = invalid.Detail("username", strconv.Itoa(usernameMin)+" to "+strconv.Itoa(usernameMax)+" letters, digits, '_', '.' or '-'")
+	}
+	email, ok = emailAddress(email)
+	if !ok {
+		invalid = invalid.Detail("email", "must be a valid address")
+	}
+	if length := utf8.RuneCountInString(password); length < passwordMin || length > passwordMax {
+		invalid = invalid.Detail("password", "must be "+strconv.Itoa(passwordMin)+" to "+strconv.Itoa(passwordMax)+" characters")
+	}
+	keys := []string{"username", "email", conf.PassKey}
+	values := []string{username, email, ""}
+	for key, value := range fields {
+		value = strings.TrimSpace(value)
+		if key != "first_name" && key != "last_name" {
+			invalid = invalid.Detail(key, "not accepted")
+			continue
+		}
+		if problem := profileProblem(key, value); problem != "" {
+			invalid = invalid.Detail(key, problem)
+			continue
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	if len(invalid.Details) > 0 {
+		failHandle(w, invalid, http.StatusBadRequest)
+		return
+	}
+	// unique columns, the table rejects a concurrent duplicate
+	unique := []struct {
+		column, value string
+		taken         *apierr.Error
+	}{
+		{"username", username, usernameTaken},
+		{"email", email, emailTaken},
+	}
+	for _, u := range unique {
+		_, err = userRecord(r.Context(), base, u.column, u.value)
+		if err == nil {
+			failHandle(w, u.taken, http.StatusConflict)
+			return
+		}
+		if err != recordNotExist {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	values[2], err = passwordHash(password)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	query := seecool.Insert(conf.UserTable).Keys(keys...).Values(values...)
+	_, err = trace.Exec(r.Context(), base, query.String())
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	record, err := userRecord(r.Context(), base, "email", email)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	userID, _ := jsonText(record, "user_id")
+	log.Println(userCreated, userID)
+	doneHandle(w, record)
+}
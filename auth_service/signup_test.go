@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestSignupInvalid covers requests that are rejected before the database.
+func TestSignupInvalid(t *testing.T) {
+	conf.PassKey = "password"
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"empty", `{}`, []string{"username", "email", "password"}},
+		{"short password", `{"username":"ada_l","email":"ada@example.com","password":"short"}`, []string{"password"}},
+		{"email as username", `{"username":"ada@example.com","email":"ada@example.com","password":"secret123"}`, []string{"username"}},
+		{"bad email", `{"username":"ada_l","email":"ada","password":"secret123"}`, []string{"email"}},
+		{"not accepted", `{"username":"ada_l","email":"ada@example.com","password":"secret123","type":"admin"}`, []string{"type"}},
+		{"long name", `{"username":"ada_l","email":"ada@example.com","password":"secret123","first_name":"` + strings.Repeat("a", 33) + `"}`, []string{"first_name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			signupHandle(rec, httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want 400: %s", rec.Code, rec.Body.String())
+			}
+			var envelope struct {
+				Error struct {
+					Details map[string]string `json:"details"`
+				} `json:"error"`
+			}
+			err := json.Unmarshal(rec.Body.Bytes(), &envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(envelope.Error.Details) != len(tt.fields) {
+				t.Fatalf("got details %v, want %v", envelope.Error.Details, tt.fields)
+			}
+			for _, field := range tt.fields {
+				if envelope.Error.Details[field] == "" {
+					t.Fatalf("no detail for %s in %v", field, envelope.Error.Details)
+				}
+			}
+		})
+	}
+}
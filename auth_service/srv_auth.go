@@ -1,14 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
-	"errorx"
+	"ecomm/internal/apierr"
+	"ecomm/internal/config"
+	"ecomm/internal/cors"
+	"ecomm/internal/health"
+	"ecomm/internal/logging"
+	"ecomm/internal/mail"
+	"ecomm/internal/metrics"
+	"ecomm/internal/mtls"
+	"ecomm/internal/registry"
+	"ecomm/internal/trace"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"jin"
 	"log"
 	"net/http"
+	"path/filepath"
 	"penman"
 	"seecool"
+	"strings"
 
 	_ "github.com/lib/pq"
 )
@@ -20,84 +35,179 @@ const (
 	envDatabaseDir string = "curr/.env_database"
 	envAuthDir     string = "curr/.env_service"
 	envMainDir     string = "curr/../.env_main"
+	envCorsDir     string = "curr/.env_cors"
 
 	// log strings
-	srvStart    string = ">> Authentication Service Started."
-	srvEnd      string = ">> Authentication Service Shutdown Unexpectedly. Error:"
-	reqArrived  string = ">> Request Arrived At"
-	reqBody     string = ">> Request Body:"
-	authGranted string = "Authentication Request Granted"
+	srvConfig    string = ">> Authentication Service Configuration:"
+	srvConfigErr string = ">> Authentication Service Configuration Failed. Error:"
+	srvStart     string = ">> Authentication Service Started."
+	srvEnd       string = ">> Authentication Service Shutdown Unexpectedly. Error:"
+	reqArrived   string = ">> Request Arrived At"
+	reqBody      string = ">> Request Body:"
+	authGranted  string = "Authentication Request Granted"
+	mfaRequired  string = "Authentication Request Waits Second Factor"
+	authFailed   string = ">> Authentication Request Failed:"
+	oidcDisabled string = ">> OIDC environment file not found, external login disabled."
 )
 
 var (
-	// service environment map
-	envServiceMap map[string]string
+	// service configuration
+	conf authConfig
 
-	// main environment environment map
-	envMainMap map[string]string
+	// my service name, also the certificate name
+	myServiceName string = "auth_service"
 
-	// database environment file
-	dbEnv string
+	// mutual tls configuration of the main server
+	tlsConfig *tls.Config
 
-	// authentication service main port
-	mainPort string
+	// main database pool
+	base *sql.DB
 
-	// return columns
-	retColumns []string = []string{"user_id", "type", "email", "password"}
+	// liveness, readiness and graceful shutdown
+	checks *health.Health
 
-	// json format schemes
-	responseScheme *jin.Scheme
+	// cross origin policies of the service
+	corsPolicy *cors.CORS
 
-	// errors
-	missingEnvFile *errorx.Error = errorx.New("Fatal Error", "Missing environment file or wrong file directory", 0)
-	portNotExist   *errorx.Error = errorx.New("Fatal Error", "Main service port does not exist in the main environment file.", 1)
-	retrunNotExist *errorx.Error = errorx.New("Fatal Error", "return array does not exist in the main environment file.", 2)
-	authFail       *errorx.Error = errorx.New("Database", "Wrong password", 3)
-	recordNotExist *errorx.Error = errorx.New("Database", "Record Not Exists", 4)
-	moreExist      *errorx.Error = errorx.New("Database", "More then one record exists with your primary key value", 5)
-	statError      *errorx.Error = errorx.New("Service", "Status method not allowed", 6)
-	authFailed     *errorx.Error = errorx.New("Service", "Authentication Request Failed", 7)
+	// verification mails of email changes
+	mailer mail.Sender
+
+	// login attempts by method (password, mfa) and result
+	loginCount *metrics.Counter = metrics.NewCounter("auth_logins_total", "Login attempts by method and result.", "method", "result")
+
+	// returned user columns, the password is never returned
+	retColumns []string = []string{"user_id", "type", "email"}
+
+	// login identifier columns, searched in order
+	idColumns []string
+
+	// identifier columns compared case insensitive
+	ciColumns map[string]bool
+
+	// errors, wrong password and unknown identifier look the same to clients
+	invalidLogin    *apierr.Error = apierr.New("invalid_credentials", http.StatusUnauthorized, "Wrong identifier or password")
+	recordNotExist  *apierr.Error = apierr.NotFound
+	moreExist       *apierr.Error = apierr.Internal.Wrap(errors.New("more then one record exists with your primary key value"))
+	statError       *apierr.Error = apierr.MethodNotAllowed
+	emptyField      *apierr.Error = apierr.MissingField
+	mfaAlreadyOn    *apierr.Error = apierr.New("mfa_already_enabled", http.StatusConflict, "Two factor authentication is already enabled")
+	mfaNotEnrolled  *apierr.Error = apierr.New("mfa_not_enabled", http.StatusBadRequest, "Two factor authentication is not enabled")
+	mfaWrongCode    *apierr.Error = apierr.New("mfa_wrong_code", http.StatusUnauthorized, "Wrong authentication code")
+	mfaNoPending    *apierr.Error = apierr.New("mfa_no_pending_login", http.StatusUnauthorized, "No pending login or login expired")
+	oidcNoProvider  *apierr.Error = apierr.New("oidc_unknown_provider", http.StatusBadRequest, "Unknown identity provider")
+	oidcBadProvider *apierr.Error = apierr.New("oidc_bad_provider", http.StatusInternalServerError, "Identity provider configuration is missing issuer, client_id or redirect")
+	oidcBadState    *apierr.Error = apierr.New("oidc_bad_state", http.StatusBadRequest, "Unknown or expired authorization state")
+	oidcBadIssuer   *apierr.Error = apierr.New("oidc_bad_issuer", http.StatusBadGateway, "Identity provider issuer mismatch or unreachable")
+	oidcBadToken    *apierr.Error = apierr.New("oidc_bad_token", http.StatusUnauthorized, "Invalid id token")
+	oidcExchange    *apierr.Error = apierr.New("oidc_exchange_failed", http.StatusBadGateway, "Authorization code exchange failed")
+	oidcUnverified  *apierr.Error = apierr.New("oidc_unverified_email", http.StatusUnauthorized, "Identity provider did not verify the email address")
+	apiKeyInvalid   *apierr.Error = apierr.New("api_key_invalid", http.StatusUnauthorized, "Invalid or revoked api key")
+	apiKeyExpired   *apierr.Error = apierr.New("api_key_expired", http.StatusUnauthorized, "Api key expired")
+	apiKeyBadScope  *apierr.Error = apierr.New("api_key_bad_scope", http.StatusBadRequest, "Unknown api key scope")
+	apiKeyBadExpire *apierr.Error = apierr.InvalidField.Detail("expires_days", "must be a positive integer")
+	wrongPassword   *apierr.Error = apierr.New("wrong_password", http.StatusUnauthorized, "Current password is wrong")
+	emailTaken      *apierr.Error = apierr.New("email_taken", http.StatusConflict, "Email address is used by another account")
+	emailBadToken   *apierr.Error = apierr.New("email_bad_token", http.StatusBadRequest, "Unknown or expired verification link")
 )
 
-func init() {
-	var err error
-	// read main env. file
-	envMainMap, err = seecool.GetEnv(envMainDir)
+// setup reads the configuration and prepares shared clients,
+// it runs from main so tests of the package need no environment.
+func setup() {
+	// read main, service and database environment files
+	err := config.Load(&conf, configSources...)
 	if err != nil {
-		panic(err)
+		log.Fatalln(srvConfigErr, err)
+	}
+	logging.Setup(myServiceName, conf.LogLevel, conf.LogRedact)
+	log.Println(srvConfig + "\n" + config.Redact(&conf))
+	// internal calls are authenticated with mutual tls
+	certDir := config.Path(conf.CertDir)
+	if !filepath.IsAbs(certDir) {
+		certDir = filepath.Join(penman.GetCurrentDir(), certDir)
 	}
-	mainPort = envMainMap["auth_service_port"]
-	if mainPort == "" {
-		panic(err)
+	tlsConfig, err = mtls.ServerConfig(certDir, myServiceName, conf.Peers)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	// optional self registration, static endpoints need no heartbeat
+	if conf.RegistryURL != "" && conf.AdvertiseURL != "" {
+		client, err := mtls.Client(certDir, myServiceName)
+		if err != nil {
+			log.Fatalln(srvConfigErr, err)
+		}
+		go registry.Heartbeat(client, conf.RegistryURL, myServiceName, conf.AdvertiseURL, conf.Heartbeat)
+	}
+	// spans of the service
+	err = trace.Setup(myServiceName, conf.TraceExporter, conf.TraceEndpoint)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	// cross origin policies
+	envCors, err := config.Map(config.Source{Path: envCorsDir})
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
 	}
-	// read env_service file
-	envServiceMap, err = seecool.GetEnv(envAuthDir)
+	corsPolicy, err = cors.New(envCors)
 	if err != nil {
-		panic(err)
+		log.Fatalln(srvConfigErr, err)
 	}
-	// read env_database file
-	dbEnv = penman.SRead(envDatabaseDir)
-	if dbEnv == "" {
-		panic(dbEnv)
+	// identifier columns, primary key is the default.
+	idColumns = conf.IDColumns
+	if len(idColumns) == 0 {
+		idColumns = []string{conf.PrimKey}
 	}
-	// response scheme
-	responseScheme = jin.MakeScheme("status", "response", "error")
+	ciColumns = make(map[string]bool)
+	for _, column := range conf.CIColumns {
+		ciColumns[column] = true
+	}
+	// outgoing mails
+	mailer, err = mail.New(conf.Mail)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	// external identity providers
+	oidcInit()
 }
 
 func main() {
-	log.Println(srvStart, "port:", mainPort)
-	http.HandleFunc("/", authHandle)
-	err := http.ListenAndServe(":"+mainPort, nil)
-	// handle later
-	log.Println(srvEnd, err)
+	setup()
+	dbConn()
+	checks = health.New()
+	checks.OnStop(trace.Shutdown)
+	checks.Add("database", health.Ping(base))
+	checks.OnStop(base.Close)
+	checks.Register(http.DefaultServeMux)
+	metrics.DBStats(base)
+	if conf.MetricsPort != "" {
+		go metrics.Listen(conf.MetricsPort)
+	}
+	log.Println(srvStart, "port:", conf.Port)
+	// routes are registered and documented together
+	apiDoc.Register(http.DefaultServeMux, routes()...)
+	http.Handle("/openapi.json", apiDoc)
+	server := &http.Server{
+		Addr:      ":" + conf.Port,
+		Handler:   trace.Handler(true, logging.Handler(nil, metrics.Instrument(http.DefaultServeMux, corsPolicy.Handler(http.DefaultServeMux)))),
+		TLSConfig: tlsConfig,
+	}
+	err := checks.Serve(server, func() error { return server.ListenAndServeTLS("", "") }, conf.ShutdownTimeout)
+	if err != nil {
+		log.Println(srvEnd, err)
+	}
+}
+
+func dbConn() {
+	var err error
+	base, err = sql.Open(conf.Driver, conf.DSN())
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
 }
 
 func authHandle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Origin, cache-control")
 	w.Header().Set("Content-Type", "application/json")
 	// request log
-	log.Println(reqArrived, r.RemoteAddr)
+	log.Println(reqArrived, r.RemoteAddr, trace.ID(r.Context()))
 
 	// method check
 	if string(r.Method) != http.MethodPost {
@@ -112,86 +222,155 @@ func authHandle(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 	// request body log.
-	log.Println(reqBody, string(json))
+	logging.From(r.Context()).Debug(reqBody, "body", logging.Body(json))
 
 	// record check core function.
-	key, status, err := checkRecord(envServiceMap["userTable"], json)
+	key, status, err := checkRecord(r.Context(), conf.UserTable, json)
 	if err != nil {
+		loginCount.Inc("password", "failed")
 		failHandle(w, err, status)
 		return
 	}
+	// users with two factor authentication get a pending login
+	challenge, err := mfaChallenge(r.Context(), key)
+	if err != nil {
+		loginCount.Inc("password", "error")
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil {
+		loginCount.Inc("password", "mfa_required")
+		mfaHandle(w, challenge)
+		return
+	}
+	loginCount.Inc("password", "granted")
+	userID, _ := jsonText(key, "user_id")
+	logging.SetUser(r.Context(), userID)
 	doneHandle(w, key)
 }
 
-func checkRecord(table string, json []byte) ([]byte, int, error) {
-	db, err := sql.Open(envServiceMap["user"], dbEnv)
-	if err != nil {
-		return nil, -1, err
-	}
-	defer db.Close()
+func checkRecord(ctx context.Context, table string, json []byte) ([]byte, int, error) {
 	// get control keys
-	primaryKey := envServiceMap["primKey"]
-	passKey := envServiceMap["passKey"]
+	passKey := conf.PassKey
 
-	// get received primary key from request
+	// get received identifier from request
 	// get received primary password key from request
-	jsonMap, err := jin.GetAllMap(json, []string{primaryKey, passKey})
+	identifier := loginIdentifier(json)
+	passKeyReceive, err := jin.GetString(json, passKey)
+	if identifier == "" {
+		return nil, http.StatusBadRequest, emptyField.Detail(conf.IDKey, "required")
+	}
 	if err != nil {
-		return nil, http.StatusInternalServerError, err
+		return nil, http.StatusBadRequest, emptyField.Detail(passKey, "required")
 	}
-	primKeyReceive := jsonMap[primaryKey]
-	passKeyReceive := jsonMap[passKey]
 
-	// create a query from primary key search
-	query := seecool.Select(table, retColumns...).Equal(primaryKey, primKeyReceive)
-	result, err := seecool.QueryJson(db, query)
+	// search identifier columns in order, first match wins.
+	result, err := lookupRecord(ctx, base, table, identifier)
 	if err != nil {
 		return nil, http.StatusInternalServerError, err
 	}
 	lenr, err := jin.Length(result)
-	if err != nil {
-		return nil, http.StatusBadRequest, recordNotExist
+	if err != nil || lenr == 0 {
+		return nil, http.StatusUnauthorized, invalidLogin
 	}
 	if lenr > 1 {
 		return nil, http.StatusInternalServerError, moreExist
 	}
-	if lenr == 0 {
-		return nil, http.StatusInternalServerError, recordNotExist
-	}
 	// get correct password from database response
 	correctPass, err := jin.GetString(result, "0", passKey)
 	if err != nil {
 		return nil, http.StatusInternalServerError, err
 	}
-	// password check
-	if passKeyReceive != correctPass {
-		return nil, http.StatusOK, authFail
+	// password check, accounts of external identities have no password
+	if !passwordMatch(correctPass, passKeyReceive) {
+		return nil, http.StatusUnauthorized, invalidLogin
 	}
-	//  get response body for return
+	//  get response body for return, without the password
 	response, err := jin.Get(result, "0")
 	if err != nil {
 		return nil, http.StatusInternalServerError, err
 	}
+	response, err = recordWithout(response, passKey)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
 	return response, http.StatusOK, nil
 }
 
-func statusFailed(err error) []byte {
-	return responseScheme.MakeJson("Failed", "null", seecool.EscapeQuote(err.Error()))
+// encodeJson marshals a response value,
+// handlers name their request bodies 'json' so they can not call json.Marshal.
+func encodeJson(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// recordWithout returns the record without the key.
+func recordWithout(record []byte, key string) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	err := json.Unmarshal(record, &fields)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, key)
+	return json.Marshal(fields)
+}
+
+// loginIdentifier returns the login identifier of the request.
+// 'idKey' field is preferred, identifier column names
+// and legacy 'primKey' field are accepted too.
+func loginIdentifier(json []byte) string {
+	keys := append([]string{conf.IDKey}, idColumns...)
+	keys = append(keys, conf.PrimKey)
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		value, err := jin.GetString(json, key)
+		if err == nil && value != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
 }
 
-func statusGranted(response []byte) []byte {
-	return responseScheme.MakeJson("OK", string(response), "null")
+// lookupRecord searches the identifier in identifier columns.
+// case insensitive columns are compared in lowercase,
+// those columns must be stored in lowercase.
+// records have the password for the check, it is never returned to clients.
+func lookupRecord(ctx context.Context, db *sql.DB, table, identifier string) ([]byte, error) {
+	result := []byte("[]")
+	columns := append(append([]string{}, retColumns...), conf.PassKey)
+	for _, column := range idColumns {
+		value := identifier
+		if ciColumns[column] {
+			value = strings.ToLower(value)
+		}
+		query := seecool.Select(table, columns...).Equal(column, value)
+		var err error
+		result, err = trace.QueryJson(ctx, db, query)
+		if err != nil {
+			return nil, err
+		}
+		lenr, err := jin.Length(result)
+		if err == nil && lenr > 0 {
+			return result, nil
+		}
+	}
+	return result, nil
 }
 
+// failHandle writes the error envelope,
+// status is used only when err is not an *apierr.Error.
 func failHandle(w http.ResponseWriter, err error, status int) {
-	log.Println(authFailed.Link(err))
-	w.WriteHeader(status)
-	w.Write(statusFailed(authFailed))
-	authFailed.ClearLink()
+	e := apierr.Fail(w, err, status)
+	log.Println(authFailed, e.Status, e, e.Fields())
 }
 
 func doneHandle(w http.ResponseWriter, response []byte) {
 	log.Println(authGranted)
-	w.WriteHeader(http.StatusOK)
-	w.Write(statusGranted(response))
+	apierr.Done(w, response)
+}
+
+func mfaHandle(w http.ResponseWriter, response []byte) {
+	log.Println(mfaRequired)
+	apierr.Write(w, http.StatusOK, apierr.StatusMFA, response)
 }
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestRecordWithout(t *testing.T) {
+	tests := []struct {
+		record string
+		want   string
+		ok     bool
+	}{
+		{`{"user_id":"user-1","email":"ada@example.com","password":"secret"}`, `{"email":"ada@example.com","user_id":"user-1"}`, true},
+		{`{"user_id":"user-1"}`, `{"user_id":"user-1"}`, true},
+		{`[]`, ``, false},
+	}
+	for _, tt := range tests {
+		got, err := recordWithout([]byte(tt.record), "password")
+		if (err == nil) != tt.ok || string(got) != tt.want {
+			t.Fatalf("recordWithout(%s) = %s %v, want %s", tt.record, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// rfc 6238 defaults, most authenticator apps only support these.
+	totpPeriod int64 = 30
+	totpDigits int   = 6
+	totpSkew   int64 = 1
+
+	// secret length in bytes, rfc 4226 recommends 160 bits.
+	totpSecretLen int = 20
+
+	// recovery code settings
+	recoveryCount    int    = 10
+	recoveryLen      int    = 10
+	recoveryAlphabet string = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+var (
+	// secrets are exchanged as unpadded base32
+	totpEncoding *base32.Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// totpSecret creates a new random base32 encoded shared secret.
+func totpSecret() (string, error) {
+	buff := make([]byte, totpSecretLen)
+	_, err := rand.Read(buff)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buff), nil
+}
+
+// totpURI creates a provisioning uri for authenticator apps.
+// format: otpauth://totp/issuer:account?secret=...&issuer=...
+func totpURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// totpStep returns the time step number of given time.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// hotp computes rfc 4226 one time password for given counter.
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, code%mod)
+}
+
+// totpVerify checks the code against the secret with a small clock skew window.
+// it returns the matched time step so callers can reject replays,
+// steps lower than or equal to 'lastStep' never match.
+func totpVerify(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	current := totpStep(now)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + i
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// recoveryCodes creates a new set of single use recovery codes.
+func recoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCount)
+	buff := make([]byte, recoveryLen)
+	for i := range codes {
+		_, err := rand.Read(buff)
+		if err != nil {
+			return nil, err
+		}
+		code := make([]byte, recoveryLen)
+		for j, b := range buff {
+			code[j] = recoveryAlphabet[int(b)%len(recoveryAlphabet)]
+		}
+		// xxxxx-xxxxx for readability
+		codes[i] = string(code[:recoveryLen/2]) + "-" + string(code[recoveryLen/2:])
+	}
+	return codes, nil
+}
+
+// recoveryHash returns the stored form of a recovery code.
+// codes are random and long enough that a plain sha256 is sufficient.
+func recoveryHash(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.Replace(code, "-", "", -1)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken creates a hex encoded random token with n bytes of entropy.
+func randomToken(n int) (string, error) {
+	buff := make([]byte, n)
+	_, err := rand.Read(buff)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buff), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc 6238 appendix b, sha1 key "12345678901234567890".
+// codes are the last six digits of the eight digit test values.
+var totpVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestHOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, tt := range totpVectors {
+		got := hotp(key, totpStep(time.Unix(tt.unix, 0)))
+		if got != tt.code {
+			t.Fatalf("hotp at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestTOTPVerify(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+	tests := []struct {
+		name     string
+		code     string
+		now      time.Time
+		lastStep int64
+		want     int64
+		ok       bool
+	}{
+		{"current step", "050471", now, 0, step, true},
+		{"previous step in skew", "050471", now.Add(30 * time.Second), 0, step, true},
+		{"next step in skew", "050471", now.Add(-30 * time.Second), 0, step, true},
+		{"out of skew", "050471", now.Add(90 * time.Second), 0, 0, false},
+		{"replayed step", "050471", now, step, 0, false},
+		{"wrong code", "123456", now, 0, 0, false},
+		{"wrong length", "50471", now, 0, 0, false},
+		{"spaces trimmed", " 050471 ", now, 0, step, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := totpVerify(secret, tt.code, tt.now, tt.lastStep)
+			if ok != tt.ok || got != tt.want {
+				t.Fatalf("totpVerify(%s) = %d %v, want %d %v", tt.code, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"ecomm/internal/trace"
+	"log"
+	"net/http"
+	"seecool"
+)
+
+var (
+	// public columns of the catalog table, others stay internal
+	catalogColumns []string = []string{"product_id", "name", "description", "price_cents"}
+)
+
+// catalogHandle lists the products on sale by name.
+// the storefront reads it through the gateway without a login.
+// GET /catalog
+func catalogHandle(w http.ResponseWriter, r *http.Request) {
+	log.Println(reqArrived, r.RemoteAddr, r.Method, r.URL.Path)
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	query := seecool.Select(conf.CatalogTable, catalogColumns...).
+		Equal("active", "true").
+		Order("name")
+	result, err := trace.QueryJson(r.Context(), base, query)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	doneHandle(w, result)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"ecomm/internal/config"
+	"time"
+)
+
+// dataConfig is the configuration of data service.
+// keys of '.env_database' are read with 'db_' prefix.
+type dataConfig struct {
+	config.Database
+	config.Tracing
+	config.Logging
+
+	Port    string   `env:"data_service_port" required:"true"`
+	CertDir string   `env:"cert_dir" required:"true"`
+	Peers   []string `env:"data_service_peers" required:"true"`
+
+	// self registration, enabled when both urls exist
+	RegistryURL  string        `env:"registry_url"`
+	AdvertiseURL string        `env:"data_service_url"`
+	Heartbeat    time.Duration `env:"registry_heartbeat" default:"10s"`
+
+	// internal listener of '/metrics', disabled when empty
+	MetricsPort string `env:"data_metrics_port"`
+
+	// active requests are drained in this duration on shutdown
+	ShutdownTimeout time.Duration `env:"shutdown_timeout" default:"15s"`
+
+	Table string `env:"table" required:"true"`
+	// primary key of the table, '{id}' of rest routes
+	IDColumn string `env:"id_column" required:"true"`
+	// products of the storefront, served read only at '/catalog'
+	CatalogTable string `env:"catalog_table" required:"true"`
+
+	// graphql limits, a list field costs 'list_cost', other fields cost 1
+	GraphQLMaxDepth int `env:"graphql_max_depth" default:"3"`
+	GraphQLMaxCost  int `env:"graphql_max_cost" default:"100"`
+	GraphQLListCost int `env:"graphql_list_cost" default:"10"`
+}
+
+var (
+	// environment files in override order
+	configSources []config.Source = []config.Source{
+		{Path: envMainDir},
+		{Path: envServiceDir},
+		{Path: envDatabaseDir, Prefix: "db_"},
+	}
+)
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"ecomm/internal/openapi"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// contractMux registers the routes with a known table,
+// column types are cached so requests that fail validation need no database.
+func contractMux(t *testing.T) *http.ServeMux {
+	conf.Table = "test_users"
+	conf.IDColumn = "user_id"
+	conf.CatalogTable = "test_products"
+	columnTypes = map[string]string{
+		"user_id":  "uuid",
+		"username": "character varying",
+		"email":    "character varying",
+		"password": "character varying",
+		"type":     "character varying",
+	}
+	gqlRules = map[string][]string{
+		"password":   {"none"},
+		ruleMutation: {"admin"},
+	}
+	t.Cleanup(func() { columnTypes = nil })
+	apiDoc = openapi.New("Data Service", "test")
+	mux := http.NewServeMux()
+	apiDoc.Register(mux, routes()...)
+	return mux
+}
+
+// documented returns the operation of the method and path template.
+func documented(t *testing.T, method, path string) openapi.Operation {
+	for _, op := range apiDoc.Operations() {
+		if op.Method == method && op.Path == path {
+			return op
+		}
+	}
+	t.Fatalf("%s %s is not documented", method, path)
+	return openapi.Operation{}
+}
+
+// checkResponse checks the status and the body against the document.
+func checkResponse(t *testing.T, op openapi.Operation, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code == op.Success() {
+		err := op.ValidateSuccess(rec.Body.Bytes())
+		if err != nil {
+			t.Fatalf("%s %s: success body %s: %v", op.Method, op.Path, rec.Body.String(), err)
+		}
+		return
+	}
+	known := false
+	for _, code := range op.Errors {
+		known = known || code == rec.Code
+	}
+	if !known {
+		t.Fatalf("%s %s: status %d is not documented: %s", op.Method, op.Path, rec.Code, rec.Body.String())
+	}
+	err := openapi.Failure().Validate(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("%s %s: error body %s: %v", op.Method, op.Path, rec.Body.String(), err)
+	}
+}
+
+func TestContractRoutes(t *testing.T) {
+	mux := contractMux(t)
+	seen := make(map[string]bool)
+	for _, route := range routes() {
+		for _, op := range route.Operations {
+			key := op.Method + " " + op.Path
+			if seen[key] {
+				t.Fatalf("%s is documented twice", key)
+			}
+			seen[key] = true
+			path := strings.Replace(op.Path, "{id}", "id-1", 1)
+			if _, pattern := mux.Handler(httptest.NewRequest(op.Method, path, nil)); pattern != route.Pattern {
+				t.Fatalf("%s is served by %q, documented in %q", key, pattern, route.Pattern)
+			}
+			// every route answers undocumented methods with 405
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, path, strings.NewReader("{}")))
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("PUT %s: got status %d, want 405", path, rec.Code)
+			}
+			checkResponse(t, op, rec)
+		}
+	}
+}
+
+func TestContractResponses(t *testing.T) {
+	mux := contractMux(t)
+	table := "/v1/test_users"
+	tests := []struct {
+		name   string
+		op     string
+		method string
+		path   string
+		body   string
+		header map[string]string
+		status int
+	}{
+		{"action required", "POST /", http.MethodPost, "/", `{}`, nil, http.StatusBadRequest},
+		{"unknown action", "POST /", http.MethodPost, "/", `{"action":"drop","body":{}}`, nil, http.StatusBadRequest},
+		{"invalid json", "POST /", http.MethodPost, "/", `{`, nil, http.StatusBadRequest},
+		{"unknown table", "GET " + table, http.MethodGet, "/v1/other", ``, nil, http.StatusNotFound},
+		{"unknown filter", "GET " + table, http.MethodGet, table + "?nope=1", ``, nil, http.StatusBadRequest},
+		{"unknown column", "GET " + table + "/{id}", http.MethodGet, table + "/id-1?columns=nope", ``, nil, http.StatusBadRequest},
+		{"body not object", "POST " + table, http.MethodPost, table, `[]`, nil, http.StatusBadRequest},
+		{"update unknown column", "PATCH " + table + "/{id}", http.MethodPatch, table + "/id-1", `{"nope":1}`, nil, http.StatusBadRequest},
+		{"delete bad id", "DELETE " + table + "/{id}", http.MethodDelete, table + "/id-1", ``, nil, http.StatusBadRequest},
+		{"schema", "GET /graphql", http.MethodGet, "/graphql", ``, nil, http.StatusOK},
+		{"bad query", "POST /graphql", http.MethodPost, "/graphql", `{"query":"{"}`, nil, http.StatusBadRequest},
+		{"hidden field", "POST /graphql", http.MethodPost, "/graphql", `{"query":"{ test_users { password } }"}`,
+			map[string]string{headerUserType: "standart", headerUserID: "id-1"}, http.StatusBadRequest},
+		{"mutation rule", "POST /graphql", http.MethodPost, "/graphql", `{"query":"mutation { delete_test_users(id: \"id-1\") { user_id } }"}`,
+			map[string]string{headerUserType: "standart", headerUserID: "id-1"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := strings.SplitN(tt.op, " ", 2)
+			op := documented(t, parts[0], parts[1])
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			checkResponse(t, op, rec)
+		})
+	}
+}
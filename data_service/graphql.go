@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"ecomm/internal/apierr"
+	"ecomm/internal/config"
+	"ecomm/internal/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// graphql endpoint of the service table, generated from its columns.
+// with table 'test_users' the schema is:
+//
+//	type Query {
+//	    test_users(filter: Object, search: Object, relation: String,
+//	        order_column: String, order_by: String): [TestUsers]
+//	    test_users_by_id(id: ID!): TestUsers
+//	}
+//	type Mutation {
+//	    insert_test_users(body: Object!): Boolean
+//	    update_test_users(id: ID!, body: Object!): Boolean
+//	    delete_test_users(id: ID!): Boolean
+//	}
+//
+// 'filter' is an exact match, 'search' is a pattern match.
+// with an owner rule, users see and change only rows they own.
+// GET /graphql returns the schema of the table.
+// errors fail the whole request, partial data is not returned.
+
+const (
+	// gateway sends the type of the logged in user,
+	// and scopes when the user is an api key
+	headerUserType  string = "X-User-Type"
+	headerUserID    string = "X-User-ID"
+	headerKeyScopes string = "X-Key-Scopes"
+	scopeWrite      string = "data:write"
+
+	// rule of columns nobody may read
+	ruleNone string = "none"
+	// rule key of mutations, like a column rule
+	ruleMutation string = "mutation"
+	// rule key of the column that holds the owner user id of a row
+	ruleOwner string = "owner"
+	// rule key of user types that are not limited to their own rows
+	ruleAllRows string = "all_rows"
+
+	// request body limit in bytes
+	gqlMaxBody int64 = 1 << 20
+
+	// log strings
+	gqlRejected string = ">> GraphQL Request Rejected:"
+)
+
+var (
+	// read rules of columns and the mutation rule of the table,
+	// columns without a rule are readable by every user type.
+	//	test_users.password = none
+	//	test_users.email    = admin, standart
+	//	test_users.mutation = admin
+	//	test_users.owner    = user_id
+	//	test_users.all_rows = admin
+	gqlRules map[string][]string
+
+	// errors
+	gqlInvalid    *apierr.Error = apierr.New("graphql_invalid", http.StatusBadRequest, "GraphQL request is not valid")
+	gqlTooComplex *apierr.Error = apierr.New("graphql_too_complex", http.StatusBadRequest, "GraphQL query exceeds depth or cost limits")
+	gqlForbidden  *apierr.Error = apierr.New("graphql_forbidden", http.StatusForbidden, "User type may not use these fields")
+)
+
+// gqlRequest is the body of graphql calls.
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// loadGraphQLRules reads rules of the service table from env.
+func loadGraphQLRules(env map[string]string, table string) map[string][]string {
+	rules := make(map[string][]string)
+	for key, value := range env {
+		parts := strings.SplitN(key, ".", 2)
+		if len(parts) != 2 || parts[0] != table {
+			continue
+		}
+		rules[parts[1]] = config.List(strings.ToLower(value))
+	}
+	return rules
+}
+
+// graphqlHandle runs graphql queries and mutations.
+func graphqlHandle(w http.ResponseWriter, r *http.Request) {
+	log.Println(reqArrived, r.RemoteAddr, r.Method, r.URL.Path)
+	columns, err := tableColumns(r.Context())
+	if err != nil {
+		failHandle(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method == http.MethodGet {
+		doneHandle(w, gqlSchema(columns))
+		return
+	}
+	if r.Method != http.MethodPost {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, gqlMaxBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		failHandle(w, apierr.TooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	logging.From(r.Context()).Debug(reqBody, "body", logging.Body(body))
+	request := gqlRequest{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err = dec.Decode(&request)
+	if err != nil {
+		failHandle(w, apierr.InvalidJSON.Wrap(err), http.StatusBadRequest)
+		return
+	}
+	op, err := parseGraphQL(request.Query, request.OperationName, request.Variables, conf.GraphQLMaxDepth)
+	if err != nil {
+		failHandle(w, gqlInvalid.Detail("query", err.Error()), http.StatusBadRequest)
+		return
+	}
+	userType := strings.ToLower(r.Header.Get(headerUserType))
+	if scopes, ok := r.Header[headerKeyScopes]; ok && op.Kind == "mutation" && !contains(config.List(strings.Join(scopes, ",")), scopeWrite) {
+		failHandle(w, apierr.Forbidden.Detail("scope", scopeWrite+" required"), http.StatusForbidden)
+		return
+	}
+	err = gqlCheck(op, columns, userType)
+	if err != nil {
+		log.Println(gqlRejected, userType, err)
+		failHandle(w, err, http.StatusBadRequest)
+		return
+	}
+	owner := gqlOwner(userType)
+	userID := r.Header.Get(headerUserID)
+	if owner != "" && userID == "" {
+		failHandle(w, gqlForbidden.Detail("user", headerUserID+" required"), http.StatusForbidden)
+		return
+	}
+	data, err, status := gqlExecute(r.Context(), op, columns, owner, userID)
+	if err != nil {
+		failHandle(w, err, status)
+		return
+	}
+	doneHandle(w, data)
+}
+
+// root field names of the table
+func gqlRoots() (list, byID, insert, update, remove string) {
+	t := conf.Table
+	return t, t + "_by_id", "insert_" + t, "update_" + t, "delete_" + t
+}
+
+// gqlArgs are the allowed arguments of root fields.
+func gqlArgs(name string) (map[string]bool, bool) {
+	list, byID, insert, update, remove := gqlRoots()
+	switch name {
+	case list:
+		return map[string]bool{"filter": true, "search": true, "relation": true, "order_column": true, "order_by": true}, true
+	case byID:
+		return map[string]bool{"id": true}, true
+	case insert:
+		return map[string]bool{"body": true}, true
+	case update:
+		return map[string]bool{"id": true, "body": true}, true
+	case remove:
+		return map[string]bool{"id": true}, true
+	}
+	return nil, false
+}
+
+// gqlCheck validates the operation before anything runs:
+// fields and arguments, depth and cost limits and user type rules.
+func gqlCheck(op *gqlOperation, columns map[string]string, userType string) error {
+	list, byID, insert, update, remove := gqlRoots()
+	invalid := make(map[string]string)
+	forbidden := make(map[string]string)
+	cost := 0
+	depth := gqlDepth(op.Selections)
+	for _, root := range op.Selections {
+		path := root.Alias
+		args, ok := gqlArgs(root.Name)
+		query := root.Name == list || root.Name == byID
+		mutation := root.Name == insert || root.Name == update || root.Name == remove
+		if !ok || op.Kind == "query" && !query || op.Kind == "mutation" && !mutation {
+			invalid[path] = "unknown " + op.Kind + " field"
+			continue
+		}
+		for arg := range root.Args {
+			if !args[arg] {
+				invalid[path+"."+arg] = "unknown argument"
+			}
+		}
+		if mutation {
+			cost += conf.GraphQLListCost
+			if len(root.Selections) > 0 {
+				invalid[path] = "Boolean field has no selections"
+			}
+			if !allowed(gqlRules[ruleMutation], userType) {
+				forbidden[path] = "mutations are not allowed"
+			}
+			continue
+		}
+		if len(root.Selections) == 0 {
+			invalid[path] = "selection set is required"
+		}
+		if root.Name == list {
+			cost += conf.GraphQLListCost
+			_, filter := root.Args["filter"]
+			_, search := root.Args["search"]
+			if filter == search {
+				invalid[path] = "one of 'filter' or 'search' is required"
+			}
+		} else {
+			cost++
+		}
+		for _, field := range root.Selections {
+			cost++
+			fieldPath := path + "." + field.Alias
+			if columns[field.Name] == "" {
+				invalid[fieldPath] = "unknown field"
+				continue
+			}
+			if len(field.Selections) > 0 || len(field.Args) > 0 {
+				invalid[fieldPath] = "scalar field has no arguments or selections"
+			}
+			if !gqlReadable(field.Name, userType) {
+				forbidden[fieldPath] = "not readable"
+			}
+		}
+		// filtering by a column leaks it as much as reading it
+		for _, arg := range []string{"filter", "search"} {
+			object, _ := root.Args[arg].(map[string]interface{})
+			for column := range object {
+				if !gqlReadable(column, userType) {
+					forbidden[path+"."+arg+"."+column] = "not readable"
+				}
+			}
+		}
+		if column, ok := root.Args["order_column"].(string); ok && !gqlReadable(column, userType) {
+			forbidden[path+".order_column"] = "not readable"
+		}
+	}
+	switch {
+	case len(invalid) > 0:
+		return gqlDetails(gqlInvalid, invalid)
+	case depth > conf.GraphQLMaxDepth:
+		return gqlTooComplex.Detail("depth", strconv.Itoa(depth)+" exceeds "+strconv.Itoa(conf.GraphQLMaxDepth))
+	case cost > conf.GraphQLMaxCost:
+		return gqlTooComplex.Detail("cost", strconv.Itoa(cost)+" exceeds "+strconv.Itoa(conf.GraphQLMaxCost))
+	case len(forbidden) > 0:
+		return gqlDetails(gqlForbidden, forbidden)
+	}
+	return nil
+}
+
+func gqlDepth(fields []*gqlField) int {
+	if len(fields) == 0 {
+		return 0
+	}
+	deepest := 0
+	for _, field := range fields {
+		if d := gqlDepth(field.Selections); d > deepest {
+			deepest = d
+		}
+	}
+	return deepest + 1
+}
+
+func gqlDetails(e *apierr.Error, details map[string]string) *apierr.Error {
+	for path, problem := range details {
+		e = e.Detail(path, problem)
+	}
+	return e
+}
+
+func gqlReadable(column, userType string) bool {
+	rule, ok := gqlRules[column]
+	return !ok || allowed(rule, userType)
+}
+
+// allowed reports whether the user type is in the rule, 'none' allows nobody.
+func allowed(rule []string, userType string) bool {
+	for _, t := range rule {
+		if t != ruleNone && t == userType {
+			return true
+		}
+	}
+	return false
+}
+
+// gqlOwner returns the owner column that limits rows of the user type,
+// empty when the user type may use every row.
+func gqlOwner(userType string) string {
+	rule := gqlRules[ruleOwner]
+	if len(rule) == 0 || allowed(gqlRules[ruleAllRows], userType) {
+		return ""
+	}
+	return rule[0]
+}
+
+// gqlExecute runs root fields in order and writes the data object,
+// fields are written in selection order.
+// when owner is set, rows of other users are dropped from results,
+// their ids can not be updated or deleted and inserts belong to userID.
+func gqlExecute(ctx context.Context, op *gqlOperation, columns map[string]string, owner, userID string) ([]byte, error, int) {
+	list, byID, insert, update, remove := gqlRoots()
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i, root := range op.Selections {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.Write(gqlKey(root.Alias))
+		var (
+			request map[string]interface{}
+			value   []byte
+		)
+		switch root.Name {
+		case list:
+			request = map[string]interface{}{"action": "searchx", "body": root.Args["filter"]}
+			if search, ok := root.Args["search"]; ok {
+				relation := root.Args["relation"]
+				if relation == nil {
+					relation = "and"
+				}
+				request = map[string]interface{}{"action": "search", "body": search, "relation": relation}
+			}
+			for _, arg := range []string{"order_column", "order_by"} {
+				if v, ok := root.Args[arg]; ok {
+					request[arg] = v
+				}
+			}
+		case byID:
+			request = map[string]interface{}{"action": "searchx", "body": map[string]interface{}{conf.IDColumn: root.Args["id"]}}
+		case insert:
+			if body, ok := root.Args["body"].(map[string]interface{}); ok && owner != "" {
+				body[owner] = userID
+			}
+			request = map[string]interface{}{"action": "insert", "body": root.Args["body"]}
+		case update:
+			request = map[string]interface{}{"action": "update", "key": conf.IDColumn, "value": root.Args["id"], "body": root.Args["body"]}
+		case remove:
+			request = map[string]interface{}{"action": "delete", "body": map[string]interface{}{conf.IDColumn: root.Args["id"]}}
+		}
+		if len(root.Selections) > 0 {
+			request["columns"] = gqlColumns(root.Selections, owner)
+		}
+		if owner != "" && (root.Name == update || root.Name == remove) {
+			err, status := gqlOwns(ctx, root.Args["id"], owner, userID)
+			if err != nil {
+				return nil, err, status
+			}
+		}
+		action, _ := request["action"].(string)
+		body, err := json.Marshal(request)
+		if err != nil {
+			return nil, err, http.StatusInternalServerError
+		}
+		result, err, status := runAction(ctx, action, body)
+		if err != nil {
+			return nil, err, status
+		}
+		if owner != "" && len(root.Selections) > 0 {
+			result, err = gqlOwned(result, owner, userID)
+			if err != nil {
+				return nil, err, http.StatusInternalServerError
+			}
+		}
+		switch root.Name {
+		case list:
+			value, err = gqlRecords(result, root.Selections, columns, false)
+		case byID:
+			value, err = gqlRecords(result, root.Selections, columns, true)
+		default:
+			value = []byte("true")
+		}
+		if err != nil {
+			return nil, err, http.StatusInternalServerError
+		}
+		out.Write(value)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil, http.StatusOK
+}
+
+// gqlColumns returns selected column names without duplicates,
+// the owner column is added to filter rows, it is not written unless selected.
+func gqlColumns(fields []*gqlField, owner string) []string {
+	seen := make(map[string]bool)
+	var arr []string
+	for _, field := range fields {
+		if !seen[field.Name] {
+			seen[field.Name] = true
+			arr = append(arr, field.Name)
+		}
+	}
+	if owner != "" && !seen[owner] {
+		arr = append(arr, owner)
+	}
+	return arr
+}
+
+// gqlOwned drops rows that are not owned by the user.
+func gqlOwned(result []byte, owner, userID string) ([]byte, error) {
+	var rows []map[string]interface{}
+	err := json.Unmarshal(result, &rows)
+	if err != nil {
+		return nil, err
+	}
+	owned := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if fmt.Sprint(row[owner]) == userID {
+			owned = append(owned, row)
+		}
+	}
+	return json.Marshal(owned)
+}
+
+// gqlOwns fails when the row with the id is missing or owned by another user.
+func gqlOwns(ctx context.Context, id interface{}, owner, userID string) (error, int) {
+	body, err := json.Marshal(map[string]interface{}{
+		"action":  "searchx",
+		"body":    map[string]interface{}{conf.IDColumn: id},
+		"columns": []string{owner},
+	})
+	if err != nil {
+		return err, http.StatusInternalServerError
+	}
+	result, err, status := runAction(ctx, "searchx", body)
+	if err != nil {
+		return err, status
+	}
+	result, err = gqlOwned(result, owner, userID)
+	if err != nil {
+		return err, http.StatusInternalServerError
+	}
+	if string(result) == "[]" {
+		return recordNotExists, http.StatusNotFound
+	}
+	return nil, http.StatusOK
+}
+
+// gqlRecords writes search results with selected fields,
+// single is for '_by_id' fields, null when nothing matches.
+func gqlRecords(result []byte, fields []*gqlField, columns map[string]string, single bool) ([]byte, error) {
+	var rows []map[string]interface{}
+	err := json.Unmarshal(result, &rows)
+	if err != nil {
+		return nil, err
+	}
+	if single && len(rows) == 0 {
+		return []byte("null"), nil
+	}
+	var out bytes.Buffer
+	if !single {
+		out.WriteByte('[')
+	}
+	for i, row := range rows {
+		if single && i > 0 {
+			break
+		}
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.WriteByte('{')
+		for j, field := range fields {
+			if j > 0 {
+				out.WriteByte(',')
+			}
+			out.Write(gqlKey(field.Alias))
+			out.Write(gqlValue(columns[field.Name], row[field.Name]))
+		}
+		out.WriteByte('}')
+	}
+	if !single {
+		out.WriteByte(']')
+	}
+	return out.Bytes(), nil
+}
+
+func gqlKey(key string) []byte {
+	b, _ := json.Marshal(key)
+	return append(b, ':')
+}
+
+// gqlValue writes a column value with its graphql type,
+// database drivers may return numbers and booleans as strings.
+func gqlValue(dataType string, v interface{}) []byte {
+	if s, ok := v.(string); ok {
+		switch gqlType(dataType) {
+		case "Int", "Float":
+			if isNumber(s) {
+				return []byte(s)
+			}
+		case "Boolean":
+			if b, err := strconv.ParseBool(s); err == nil {
+				return []byte(strconv.FormatBool(b))
+			}
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return []byte("null")
+	}
+	return b
+}
+
+// gqlType maps postgres column types to graphql scalars.
+func gqlType(dataType string) string {
+	switch dataType {
+	case "uuid":
+		return "ID"
+	case "smallint", "integer", "bigint":
+		return "Int"
+	case "numeric", "real", "double precision":
+		return "Float"
+	case "boolean":
+		return "Boolean"
+	}
+	return "String"
+}
+
+// gqlSchema returns the schema of the table in sdl, as a json string.
+func gqlSchema(columns map[string]string) []byte {
+	list, byID, insert, update, remove := gqlRoots()
+	typeName := ""
+	for _, part := range strings.Split(conf.Table, "_") {
+		if part != "" {
+			typeName += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sdl strings.Builder
+	sdl.WriteString("scalar Object\n\ntype " + typeName + " {\n")
+	for _, name := range names {
+		sdl.WriteString("    " + name + ": " + gqlType(columns[name]) + "\n")
+	}
+	sdl.WriteString("}\n\ntype Query {\n")
+	sdl.WriteString("    " + list + "(filter: Object, search: Object, relation: String, order_column: String, order_by: String): [" + typeName + "]\n")
+	sdl.WriteString("    " + byID + "(id: ID!): " + typeName + "\n")
+	sdl.WriteString("}\n\ntype Mutation {\n")
+	sdl.WriteString("    " + insert + "(body: Object!): Boolean\n")
+	sdl.WriteString("    " + update + "(id: ID!, body: Object!): Boolean\n")
+	sdl.WriteString("    " + remove + "(id: ID!): Boolean\n")
+	sdl.WriteString("}\n")
+	b, _ := json.Marshal(sdl.String())
+	return b
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// graphql parser of the subset we serve:
+// operations with variables, fields with aliases and arguments.
+// fragments, directives and block strings are not supported.
+
+// gqlOperation is a parsed query or mutation.
+type gqlOperation struct {
+	Kind       string
+	Name       string
+	Selections []*gqlField
+}
+
+// gqlField is a selected field, arguments have variables resolved.
+type gqlField struct {
+	Alias      string
+	Name       string
+	Args       map[string]interface{}
+	Selections []*gqlField
+}
+
+// gqlToken kinds
+const (
+	gqlEOF int = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	kind  int
+	value string
+	pos   int
+}
+
+type gqlParser struct {
+	src       string
+	pos       int
+	tok       gqlToken
+	variables map[string]interface{}
+	// nesting of selection sets and of values (lists, objects, list types),
+	// both are limited with maxDepth so deep documents can not exhaust the stack.
+	depth    int
+	nesting  int
+	maxDepth int
+}
+
+// parseGraphQL parses the document and returns the operation to run.
+// name is required when the document has more than one operation.
+// selection sets and values deeper than maxDepth are parse errors.
+func parseGraphQL(src, name string, variables map[string]interface{}, maxDepth int) (*gqlOperation, error) {
+	p := &gqlParser{src: src, variables: variables, maxDepth: maxDepth}
+	if p.variables == nil {
+		p.variables = make(map[string]interface{})
+	}
+	err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	// variables are resolved while parsing, so operations are parsed one by one
+	var found *gqlOperation
+	count := 0
+	for p.tok.kind != gqlEOF {
+		start := p.pos
+		op, err := p.operation()
+		if err != nil {
+			return nil, err
+		}
+		count++
+		if name == "" || op.Name == name {
+			if found != nil && name != "" {
+				return nil, p.fail(start, "operation '"+name+"' is defined twice")
+			}
+			found = op
+		}
+	}
+	switch {
+	case count == 0:
+		return nil, errors.New("document has no operation")
+	case name == "" && count > 1:
+		return nil, errors.New("operationName is required for documents with more than one operation")
+	case found == nil:
+		return nil, errors.New("operation '" + name + "' does not exist")
+	}
+	return found, nil
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{Kind: "query"}
+	if p.tok.kind == gqlName {
+		switch p.tok.value {
+		case "query", "mutation":
+			op.Kind = p.tok.value
+		case "fragment":
+			return nil, p.fail(p.tok.pos, "fragments are not supported")
+		default:
+			return nil, p.fail(p.tok.pos, "unexpected '"+p.tok.value+"'")
+		}
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind == gqlName {
+			op.Name = p.tok.value
+			err = p.next()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if p.is("(") {
+			err = p.variableDefinitions()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = selections
+	return op, nil
+}
+
+// variableDefinitions reads '($id: ID!, $n: Int = 10)',
+// types are not checked, defaults fill missing variables.
+func (p *gqlParser) variableDefinitions() error {
+	err := p.expect("(")
+	if err != nil {
+		return err
+	}
+	for !p.is(")") {
+		err = p.expect("$")
+		if err != nil {
+			return err
+		}
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		err = p.expect(":")
+		if err != nil {
+			return err
+		}
+		err = p.typeRef()
+		if err != nil {
+			return err
+		}
+		if p.is("=") {
+			err = p.next()
+			if err != nil {
+				return err
+			}
+			value, err := p.value()
+			if err != nil {
+				return err
+			}
+			if _, ok := p.variables[name]; !ok {
+				p.variables[name] = value
+			}
+		}
+	}
+	return p.next()
+}
+
+func (p *gqlParser) typeRef() error {
+	var err error
+	if p.is("[") {
+		err = p.enter(&p.nesting)
+		if err == nil {
+			err = p.next()
+		}
+		if err == nil {
+			err = p.typeRef()
+		}
+		if err == nil {
+			err = p.expect("]")
+		}
+		p.nesting--
+	} else {
+		_, err = p.name()
+	}
+	if err != nil {
+		return err
+	}
+	if p.is("!") {
+		return p.next()
+	}
+	return nil
+}
+
+func (p *gqlParser) selectionSet() ([]*gqlField, error) {
+	err := p.enter(&p.depth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	err = p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	var fields []*gqlField
+	for !p.is("}") {
+		if p.is("...") {
+			return nil, p.fail(p.tok.pos, "fragments are not supported")
+		}
+		field, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, p.fail(p.tok.pos, "selection set is empty")
+	}
+	return fields, p.next()
+}
+
+func (p *gqlParser) field() (*gqlField, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	field := &gqlField{Alias: name, Name: name, Args: make(map[string]interface{})}
+	if p.is(":") {
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		field.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.is("(") {
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		for !p.is(")") {
+			arg, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect(":")
+			if err != nil {
+				return nil, err
+			}
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			field.Args[arg] = value
+		}
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.is("@") {
+		return nil, p.fail(p.tok.pos, "directives are not supported")
+	}
+	if p.is("{") {
+		field.Selections, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+// value returns string, json.Number, bool, nil, []interface{}
+// or map[string]interface{}, enum values are strings.
+func (p *gqlParser) value() (interface{}, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == gqlPunct && tok.value == "$":
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		return p.variables[name], nil
+	case tok.kind == gqlPunct && tok.value == "[":
+		err := p.enter(&p.nesting)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { p.nesting-- }()
+		list := []interface{}{}
+		err = p.next()
+		for err == nil && !p.is("]") {
+			var item interface{}
+			item, err = p.value()
+			list = append(list, item)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return list, p.next()
+	case tok.kind == gqlPunct && tok.value == "{":
+		err := p.enter(&p.nesting)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { p.nesting-- }()
+		object := make(map[string]interface{})
+		err = p.next()
+		for err == nil && !p.is("}") {
+			var key string
+			var item interface{}
+			key, err = p.name()
+			if err == nil {
+				err = p.expect(":")
+			}
+			if err == nil {
+				item, err = p.value()
+			}
+			object[key] = item
+		}
+		if err != nil {
+			return nil, err
+		}
+		return object, p.next()
+	case tok.kind == gqlInt || tok.kind == gqlFloat:
+		return json.Number(tok.value), p.next()
+	case tok.kind == gqlString:
+		return tok.value, p.next()
+	case tok.kind == gqlName:
+		var value interface{} = tok.value
+		switch tok.value {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		}
+		return value, p.next()
+	}
+	return nil, p.fail(tok.pos, "value expected")
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.tok.kind != gqlName {
+		return "", p.fail(p.tok.pos, "name expected")
+	}
+	name := p.tok.value
+	return name, p.next()
+}
+
+func (p *gqlParser) is(punct string) bool {
+	return p.tok.kind == gqlPunct && p.tok.value == punct
+}
+
+func (p *gqlParser) expect(punct string) error {
+	if !p.is(punct) {
+		return p.fail(p.tok.pos, "'"+punct+"' expected")
+	}
+	return p.next()
+}
+
+// enter increases a nesting counter, callers decrease it when they return.
+func (p *gqlParser) enter(counter *int) error {
+	*counter++
+	if *counter > p.maxDepth {
+		return p.fail(p.tok.pos, "nesting exceeds max depth "+strconv.Itoa(p.maxDepth))
+	}
+	return nil
+}
+
+func (p *gqlParser) fail(pos int, msg string) error {
+	return errors.New(msg + " at " + strconv.Itoa(pos))
+}
+
+// next reads the next token, commas and comments are ignored.
+func (p *gqlParser) next() error {
+	src := p.src
+	for p.pos < len(src) {
+		c := src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+			continue
+		}
+		if c == '#' {
+			for p.pos < len(src) && src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		break
+	}
+	start := p.pos
+	if p.pos >= len(src) {
+		p.tok = gqlToken{kind: gqlEOF, pos: start}
+		return nil
+	}
+	c := src[p.pos]
+	switch {
+	case strings.HasPrefix(src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = gqlToken{kind: gqlPunct, value: "...", pos: start}
+	case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+		p.pos++
+		p.tok = gqlToken{kind: gqlPunct, value: string(c), pos: start}
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for p.pos < len(src) && isNameChar(src[p.pos]) {
+			p.pos++
+		}
+		p.tok = gqlToken{kind: gqlName, value: src[start:p.pos], pos: start}
+	case c == '-' || c >= '0' && c <= '9':
+		kind := gqlInt
+		p.pos++
+		for p.pos < len(src) {
+			d := src[p.pos]
+			if d == '.' || d == 'e' || d == 'E' || d == '+' || d == '-' {
+				kind = gqlFloat
+			} else if d < '0' || d > '9' {
+				break
+			}
+			p.pos++
+		}
+		p.tok = gqlToken{kind: kind, value: src[start:p.pos], pos: start}
+		if !json.Valid([]byte(p.tok.value)) {
+			return p.fail(start, "invalid number")
+		}
+	case c == '"':
+		if strings.HasPrefix(src[p.pos:], `"""`) {
+			return p.fail(start, "block strings are not supported")
+		}
+		p.pos++
+		for p.pos < len(src) && src[p.pos] != '"' && src[p.pos] != '\n' {
+			if src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(src) || src[p.pos] != '"' {
+			return p.fail(start, "unterminated string")
+		}
+		p.pos++
+		var value string
+		err := json.Unmarshal([]byte(src[start:p.pos]), &value)
+		if err != nil {
+			return p.fail(start, "invalid string")
+		}
+		p.tok = gqlToken{kind: gqlString, value: value, pos: start}
+	default:
+		return p.fail(start, "unexpected character")
+	}
+	return nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
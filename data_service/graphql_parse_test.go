@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQLDepth(t *testing.T) {
+	// a few million nested values used to overflow the stack
+	deep := strings.Repeat("[", 3000000)
+	tests := []struct {
+		name  string
+		query string
+		ok    bool
+	}{
+		{"selections at max depth", "{ a { b { c } } }", true},
+		{"selections over max depth", "{ a { b { c { d } } } }", false},
+		{"values at max depth", "{ a(x: {y: [[1]]}) }", true},
+		{"values over max depth", "{ a(x: {y: [[[1]]]}) }", false},
+		{"variable type over max depth", "query q($x: [[[[Int]]]]) { a }", false},
+		{"deep list", "{ a(x: " + deep + ") }", false},
+		{"deep object", "{ a(x: " + strings.Repeat("{y: ", 1000000) + ") }", false},
+		{"deep selections", strings.Repeat("{ a ", 1000000), false},
+		{"deep variable default", "query q($x: Int = " + deep + ") { a }", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseGraphQL(tt.query, "", nil, 3)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got error %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
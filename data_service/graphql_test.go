@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGraphQLBodyLimit(t *testing.T) {
+	mux := contractMux(t)
+	conf.GraphQLMaxDepth = 3
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"too large", `{"query":"` + strings.Repeat(" ", int(gqlMaxBody)) + `{ a }"}`, http.StatusRequestEntityTooLarge},
+		{"too deep", `{"query":"{ test_users(filter: ` + strings.Repeat("[", 100000) + `) { user_id } }"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Fatalf("%s: got status %d, want %d: %.200s", tt.name, rec.Code, tt.status, rec.Body.String())
+		}
+	}
+}
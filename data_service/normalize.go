@@ -0,0 +1,107 @@
+package main
+
+import (
+	"ecomm/internal/apierr"
+	"errors"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizer transforms a column value before it is written or compared.
+type normalizer func(value string) (string, error)
+
+var (
+	// normalizers by name, used in '.env_normalize'
+	//	<table>.<column> = trim, nfc, lower
+	normalizers map[string]normalizer = map[string]normalizer{
+		"trim":     func(s string) (string, error) { return strings.TrimSpace(s), nil },
+		"lower":    func(s string) (string, error) { return strings.ToLower(s), nil },
+		"upper":    func(s string) (string, error) { return strings.ToUpper(s), nil },
+		"nfc":      func(s string) (string, error) { return norm.NFC.String(s), nil },
+		"collapse": func(s string) (string, error) { return strings.Join(strings.Fields(s), " "), nil },
+		"phone":    phoneE164,
+	}
+
+	// normalizer pipelines of the table columns, applied in order
+	columnPipelines map[string][]normalizer
+
+	// errors
+	errPhone error = errors.New("must be an international phone number like +905551234567")
+)
+
+// loadNormalizers reads pipelines of the service table from env,
+// other tables in the file are ignored.
+func loadNormalizers(env map[string]string, table string) (map[string][]normalizer, error) {
+	pipelines := make(map[string][]normalizer)
+	for key, value := range env {
+		parts := strings.SplitN(key, ".", 2)
+		if len(parts) != 2 || parts[0] != table {
+			continue
+		}
+		var pipeline []normalizer
+		for _, name := range strings.Split(value, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			n, ok := normalizers[name]
+			if !ok {
+				return nil, errors.New("unknown normalizer '" + name + "' of " + key)
+			}
+			pipeline = append(pipeline, n)
+		}
+		pipelines[parts[1]] = pipeline
+	}
+	return pipelines, nil
+}
+
+// normalize applies the pipeline of the column to the value.
+func normalize(column, value string) (string, error) {
+	var err error
+	for _, n := range columnPipelines[column] {
+		value, err = n(value)
+		if err != nil {
+			return "", apierr.InvalidField.Detail(column, err.Error())
+		}
+	}
+	return value, nil
+}
+
+// normalizeValues normalizes values of key & value pairs in place.
+func normalizeValues(keys, values []string) error {
+	for i := range keys {
+		value, err := normalize(keys[i], values[i])
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+	return nil
+}
+
+// phoneE164 formats phone numbers as E.164, '+' and 8 to 15 digits.
+// spaces, dots, dashes and parentheses are removed, leading '00' becomes '+'.
+func phoneE164(s string) (string, error) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(s) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '.' || r == '-' || r == '(' || r == ')':
+		default:
+			return "", errPhone
+		}
+	}
+	phone := b.String()
+	if strings.HasPrefix(phone, "00") {
+		phone = "+" + phone[2:]
+	}
+	digits := strings.TrimPrefix(phone, "+")
+	if !strings.HasPrefix(phone, "+") || len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", errPhone
+	}
+	return phone, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"ecomm/internal/apierr"
+	"ecomm/internal/logging"
+	"encoding/json"
+	"io/ioutil"
+	"jin"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// rest routes, only the service table is served
+	//	GET    /v1/{table}/{id}
+	//	GET    /v1/{table}?column=value&columns=a,b&order_column=a&order_by=desc
+	//	POST   /v1/{table}
+	//	PATCH  /v1/{table}/{id}
+	//	DELETE /v1/{table}/{id}
+	restPrefix string = "/v1/"
+
+	// list queries are exact matches, 'relation' switches to pattern search
+	restRelation string = "relation"
+)
+
+var (
+	// query parameters that are not column filters
+	restReserved map[string]bool = map[string]bool{
+		"columns":      true,
+		"order_column": true,
+		"order_by":     true,
+		restRelation:   true,
+	}
+)
+
+// restHandle routes rest requests by method and path segments.
+// method patterns of http.ServeMux are not used, GOPATH builds
+// run with 'httpmuxgo121' and would register them as literal paths.
+func restHandle(w http.ResponseWriter, r *http.Request) {
+	log.Println(reqArrived, r.RemoteAddr, r.Method, r.URL.Path)
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, restPrefix), "/")
+	if segments[0] != conf.Table || len(segments) > 2 || (len(segments) == 2 && segments[1] == "") {
+		failHandle(w, apierr.NotFound.Detail("path", "unknown table or resource"), http.StatusNotFound)
+		return
+	}
+	if len(segments) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			restListHandle(w, r)
+		case http.MethodPost:
+			restCreateHandle(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			failHandle(w, statError, http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	id := segments[1]
+	switch r.Method {
+	case http.MethodGet:
+		restGetHandle(w, r, id)
+	case http.MethodPatch:
+		restUpdateHandle(w, r, id)
+	case http.MethodDelete:
+		restDeleteHandle(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE")
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+	}
+}
+
+// restGetHandle returns a record by id, 404 when it does not exist.
+func restGetHandle(w http.ResponseWriter, r *http.Request, id string) {
+	request := map[string]interface{}{
+		"action": "searchx",
+		"body":   map[string]string{conf.IDColumn: id},
+	}
+	restColumns(r, request)
+	result, ok := restRun(w, r, request)
+	if !ok {
+		return
+	}
+	record, err := jin.Get(result, "0")
+	if err != nil {
+		failHandle(w, recordNotExists, http.StatusNotFound)
+		return
+	}
+	doneHandle(w, record)
+}
+
+// restListHandle returns records that match query parameters.
+// at least one column filter is required.
+func restListHandle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filters := make(map[string]string)
+	for key := range query {
+		if !restReserved[key] {
+			filters[key] = query.Get(key)
+		}
+	}
+	request := map[string]interface{}{
+		"action": "searchx",
+		"body":   filters,
+	}
+	if relation := query.Get(restRelation); relation != "" {
+		request["action"] = "search"
+		request[restRelation] = relation
+	}
+	restColumns(r, request)
+	for _, key := range []string{"order_column", "order_by"} {
+		if value := query.Get(key); value != "" {
+			request[key] = value
+		}
+	}
+	result, ok := restRun(w, r, request)
+	if !ok {
+		return
+	}
+	doneHandle(w, result)
+}
+
+// restCreateHandle inserts the request body, responds 201.
+func restCreateHandle(w http.ResponseWriter, r *http.Request) {
+	body, ok := restBody(w, r)
+	if !ok {
+		return
+	}
+	_, ok = restRun(w, r, map[string]interface{}{"action": "insert", "body": body})
+	if !ok {
+		return
+	}
+	// id is known only when the client sends it, defaults are not read back
+	if id, err := jin.GetString(body, conf.IDColumn); err == nil {
+		w.Header().Set("Location", restPrefix+conf.Table+"/"+id)
+	}
+	log.Println(reqDone)
+	apierr.Write(w, http.StatusCreated, apierr.StatusOK, nil)
+}
+
+// restUpdateHandle updates columns of a record, responds 204.
+func restUpdateHandle(w http.ResponseWriter, r *http.Request, id string) {
+	body, ok := restBody(w, r)
+	if !ok {
+		return
+	}
+	request := map[string]interface{}{
+		"action": "update",
+		"key":    conf.IDColumn,
+		"value":  id,
+		"body":   body,
+	}
+	_, ok = restRun(w, r, request)
+	if !ok {
+		return
+	}
+	log.Println(reqDone)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restDeleteHandle deletes a record, responds 204.
+func restDeleteHandle(w http.ResponseWriter, r *http.Request, id string) {
+	request := map[string]interface{}{
+		"action": "delete",
+		"body":   map[string]string{conf.IDColumn: id},
+	}
+	_, ok := restRun(w, r, request)
+	if !ok {
+		return
+	}
+	log.Println(reqDone)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restColumns copies 'columns=a,b' query parameter to the request.
+func restColumns(r *http.Request, request map[string]interface{}) {
+	value := r.URL.Query().Get("columns")
+	if value == "" {
+		return
+	}
+	columns := strings.Split(value, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+	request["columns"] = columns
+}
+
+// restBody reads the request body, it must be a json object.
+func restBody(w http.ResponseWriter, r *http.Request) (json.RawMessage, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	defer r.Body.Close()
+	logging.From(r.Context()).Debug(reqBody, "body", logging.Body(body))
+	var fields map[string]interface{}
+	err = json.Unmarshal(body, &fields)
+	if err != nil || fields == nil {
+		failHandle(w, apierr.InvalidJSON.Detail("body", "must be a json object"), http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// restRun runs a rest request as an action request.
+func restRun(w http.ResponseWriter, r *http.Request, request map[string]interface{}) ([]byte, bool) {
+	action, _ := request["action"].(string)
+	body, err := json.Marshal(request)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	result, err, status := runAction(r.Context(), action, body)
+	if err != nil {
+		failHandle(w, err, status)
+		return nil, false
+	}
+	return result, true
+}
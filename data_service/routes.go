@@ -0,0 +1,117 @@
+package main
+
+import (
+	"ecomm/internal/openapi"
+	"net/http"
+	"sort"
+)
+
+var (
+	// openapi document of the service, served at '/openapi.json'
+	apiDoc *openapi.Document = openapi.New("Data Service", "1.0.0")
+
+	// products of the catalog, public columns only
+	productSchema *openapi.Schema = openapi.Object(map[string]*openapi.Schema{
+		"*product_id":  openapi.String(""),
+		"*name":        openapi.String(""),
+		"*description": openapi.String(""),
+		"*price_cents": openapi.Integer("price in cents"),
+	})
+)
+
+// routes returns routes of the service,
+// paths use the configured table because only that table is served.
+func routes() []openapi.Route {
+	table := restPrefix + conf.Table
+	record := openapi.Map(nil)
+	record.Description = "column: value pairs of the table"
+	id := openapi.Param{Name: "id", In: "path", Required: true, Schema: openapi.String(conf.IDColumn + " of the record")}
+	columns := openapi.Param{Name: "columns", In: "query", Schema: openapi.String("comma separated columns to return")}
+	errs := []int{http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusInternalServerError}
+	op := func(method, path, summary string, status int, params []openapi.Param, body, data *openapi.Schema) openapi.Operation {
+		return openapi.Operation{
+			Method:  method,
+			Path:    path,
+			Summary: summary,
+			Tags:    []string{conf.Table},
+			Params:  params,
+			Body:    body,
+			Data:    data,
+			Status:  status,
+			Errors:  errs,
+		}
+	}
+	gqlOp := op(http.MethodPost, "/graphql", "Runs a graphql query or mutation.", 0, []openapi.Param{
+		{Name: headerUserType, In: "header", Schema: openapi.String("user type for field rules, set by the gateway")},
+		{Name: headerKeyScopes, In: "header", Schema: openapi.String("api key scopes, set by the gateway")},
+	}, openapi.Object(map[string]*openapi.Schema{
+		"*query":        openapi.String(""),
+		"operationName": openapi.String(""),
+		"variables":     openapi.Map(nil),
+	}), openapi.Map(nil))
+	gqlOp.Errors = append([]int{http.StatusRequestEntityTooLarge}, errs...)
+	catalogOp := op(http.MethodGet, "/catalog", "Lists the products on sale by name.", 0, nil, nil, openapi.Array(productSchema))
+	catalogOp.Tags = []string{conf.CatalogTable}
+	catalogOp.Errors = []int{http.StatusMethodNotAllowed, http.StatusInternalServerError}
+	return []openapi.Route{
+		{Pattern: "/", Handler: dataHandle, Operations: []openapi.Operation{
+			op(http.MethodPost, "/", "Legacy action endpoint, data is the records of searches and null for others.", 0, nil,
+				actionsSchema(), &openapi.Schema{Nullable: true, OneOf: []*openapi.Schema{openapi.Array(record)}}),
+		}},
+		{Pattern: restPrefix, Handler: restHandle, Operations: []openapi.Operation{
+			op(http.MethodGet, table+"/{id}", "Returns a record.", 0, []openapi.Param{id, columns}, nil, record),
+			op(http.MethodGet, table, "Lists records, other query parameters are column filters and one is required.", 0, []openapi.Param{
+				columns,
+				{Name: "relation", In: "query", Schema: openapi.Enum("pattern search of filters, exact match when empty", "and", "or")},
+				{Name: "order_column", In: "query", Schema: openapi.String("")},
+				{Name: "order_by", In: "query", Schema: openapi.Enum("", "asc", "desc")},
+			}, nil, openapi.Array(record)),
+			op(http.MethodPost, table, "Creates a record.", http.StatusCreated, nil, record, nil),
+			op(http.MethodPatch, table+"/{id}", "Updates columns of a record.", http.StatusNoContent, []openapi.Param{id}, record, nil),
+			op(http.MethodDelete, table+"/{id}", "Deletes a record.", http.StatusNoContent, []openapi.Param{id}, nil, nil),
+		}},
+		{Pattern: "/graphql", Handler: graphqlHandle, Operations: []openapi.Operation{
+			op(http.MethodGet, "/graphql", "Returns the graphql schema of the table in sdl.", 0, nil, nil, openapi.String("")),
+			gqlOp,
+		}},
+		{Pattern: "/catalog", Handler: catalogHandle, Operations: []openapi.Operation{catalogOp}},
+	}
+}
+
+// actionsSchema documents action requests from their declared schemas.
+func actionsSchema() *openapi.Schema {
+	actions := make([]string, 0, len(actionSchemas))
+	for action := range actionSchemas {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	schema := &openapi.Schema{}
+	for _, action := range actions {
+		properties := make(map[string]*openapi.Schema)
+		for key, f := range actionSchemas[action].fields {
+			var s *openapi.Schema
+			switch f.kind {
+			case kindString:
+				s = openapi.Enum("", f.enum...)
+				if key == "action" {
+					s = openapi.Enum("", action)
+				}
+			case kindScalar:
+				s = &openapi.Schema{Description: "string, number or boolean"}
+			case kindObject:
+				s = openapi.Map(nil)
+				s.Description = "column: value pairs"
+			case kindColumn:
+				s = openapi.String("column name")
+			case kindColumns:
+				s = openapi.Array(openapi.String("column name"))
+			}
+			if f.required {
+				key = "*" + key
+			}
+			properties[key] = s
+		}
+		schema.OneOf = append(schema.OneOf, openapi.Object(properties))
+	}
+	return schema
+}
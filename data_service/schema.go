@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"ecomm/internal/apierr"
+	"ecomm/internal/trace"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"seecool"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// postgres schema of the table, queries use the default search path
+const tableSchema string = "public"
+
+// json kinds of request fields
+const (
+	kindString int = iota
+	kindScalar
+	kindObject
+	kindColumns
+	kindColumn
+)
+
+// field is a declared top level key of an action request.
+type field struct {
+	kind     int
+	required bool
+	// allowed values, lower case, any value when empty
+	enum []string
+}
+
+// actionSchema declares the keys of an action, other keys are rejected.
+type actionSchema struct {
+	fields map[string]field
+	// body values are checked against column types,
+	// search values are patterns so they are only strings.
+	typedBody bool
+	// body must have exactly one key & value pair
+	onePair bool
+}
+
+var (
+	// schemas of actions
+	actionSchemas map[string]*actionSchema = map[string]*actionSchema{
+		"insert": {
+			fields: map[string]field{
+				"action": {kind: kindString, required: true},
+				"body":   {kind: kindObject, required: true},
+			},
+			typedBody: true,
+		},
+		"update": {
+			fields: map[string]field{
+				"action": {kind: kindString, required: true},
+				"key":    {kind: kindColumn, required: true},
+				"value":  {kind: kindScalar, required: true},
+				"body":   {kind: kindObject, required: true},
+			},
+			typedBody: true,
+		},
+		"delete": {
+			fields: map[string]field{
+				"action": {kind: kindString, required: true},
+				"body":   {kind: kindObject, required: true},
+			},
+			typedBody: true,
+			onePair:   true,
+		},
+		"search": {
+			fields: map[string]field{
+				"action":       {kind: kindString, required: true},
+				"body":         {kind: kindObject, required: true},
+				"columns":      {kind: kindColumns},
+				"relation":     {kind: kindString, required: true, enum: []string{"and", "or"}},
+				"order_column": {kind: kindColumn},
+				"order_by":     {kind: kindString, enum: []string{"asc", "desc"}},
+			},
+		},
+		"searchx": {
+			fields: map[string]field{
+				"action":       {kind: kindString, required: true},
+				"body":         {kind: kindObject, required: true},
+				"columns":      {kind: kindColumns},
+				"order_column": {kind: kindColumn},
+				"order_by":     {kind: kindString, enum: []string{"asc", "desc"}},
+			},
+			typedBody: true,
+		},
+	}
+
+	// column types of the table, read once from the database
+	columnTypes   map[string]string
+	columnTypesMu sync.Mutex
+
+	// errors
+	schemaMismatch   *apierr.Error = apierr.New("schema_mismatch", http.StatusBadRequest, "Request does not match the action schema")
+	errTableNotFound error         = errors.New("table does not exist or has no columns")
+)
+
+// validateRequest checks the request against the schema of its action.
+// every problem is reported in details of a single error.
+func validateRequest(ctx context.Context, action string, body []byte) error {
+	schema, ok := actionSchemas[action]
+	if !ok {
+		return wrongAction
+	}
+	var request map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err := dec.Decode(&request)
+	if err != nil {
+		return apierr.InvalidJSON.Wrap(err)
+	}
+	columns, err := tableColumns(ctx)
+	if err != nil {
+		return err
+	}
+	v := &validation{columns: columns, problems: make(map[string]string)}
+	for key := range request {
+		if _, ok := schema.fields[key]; !ok {
+			v.fail(key, "unknown field")
+		}
+	}
+	for key, f := range schema.fields {
+		value, ok := request[key]
+		if !ok || value == nil {
+			if f.required {
+				v.fail(key, "required")
+			}
+			continue
+		}
+		v.check(key, f, value)
+	}
+	if obj, ok := request["body"].(map[string]interface{}); ok {
+		v.body(schema, obj)
+	}
+	// update value must fit the key column
+	if key, ok := request["key"].(string); ok && columns[key] != "" && request["value"] != nil {
+		v.typed("value", columns[key], request["value"])
+	}
+	return v.err()
+}
+
+// validation collects problems by field path like 'body.email'.
+type validation struct {
+	columns  map[string]string
+	problems map[string]string
+}
+
+func (v *validation) fail(path, problem string) {
+	if _, ok := v.problems[path]; !ok {
+		v.problems[path] = problem
+	}
+}
+
+func (v *validation) check(key string, f field, value interface{}) {
+	switch f.kind {
+	case kindString:
+		s, ok := value.(string)
+		if !ok {
+			v.fail(key, "must be a string")
+			return
+		}
+		if len(f.enum) > 0 && !contains(f.enum, strings.ToLower(s)) {
+			v.fail(key, "must be one of "+strings.Join(f.enum, ", "))
+		}
+	case kindScalar:
+		switch value.(type) {
+		case string, json.Number, bool:
+		default:
+			v.fail(key, "must be a string, number or boolean")
+		}
+	case kindObject:
+		if _, ok := value.(map[string]interface{}); !ok {
+			v.fail(key, "must be an object")
+		}
+	case kindColumn:
+		s, ok := value.(string)
+		if !ok {
+			v.fail(key, "must be a string")
+			return
+		}
+		if v.columns[s] == "" {
+			v.fail(key, "unknown column")
+		}
+	case kindColumns:
+		arr, ok := value.([]interface{})
+		if !ok {
+			v.fail(key, "must be an array of column names")
+			return
+		}
+		for i, item := range arr {
+			path := key + "[" + strconv.Itoa(i) + "]"
+			s, ok := item.(string)
+			if !ok {
+				v.fail(path, "must be a string")
+				continue
+			}
+			if v.columns[s] == "" {
+				v.fail(path, "unknown column")
+			}
+		}
+	}
+}
+
+func (v *validation) body(schema *actionSchema, obj map[string]interface{}) {
+	if len(obj) == 0 {
+		v.fail("body", "must not be empty")
+		return
+	}
+	if schema.onePair && len(obj) != 1 {
+		v.fail("body", "one key and value pair expected")
+	}
+	for column, value := range obj {
+		path := "body." + column
+		dataType := v.columns[column]
+		if dataType == "" {
+			v.fail(path, "unknown column")
+			continue
+		}
+		if !schema.typedBody {
+			if _, ok := value.(string); !ok {
+				v.fail(path, "must be a string pattern")
+			}
+			continue
+		}
+		v.typed(path, dataType, value)
+	}
+}
+
+// typed checks a value against a postgres column type.
+// numbers and booleans may be sent as strings too.
+func (v *validation) typed(path, dataType string, value interface{}) {
+	switch dataType {
+	case "smallint", "integer", "bigint":
+		if !isInteger(value) {
+			v.fail(path, "must be an integer")
+		}
+	case "numeric", "real", "double precision":
+		if !isNumber(value) {
+			v.fail(path, "must be a number")
+		}
+	case "boolean":
+		if !isBool(value) {
+			v.fail(path, "must be a boolean")
+		}
+	case "uuid":
+		if !isUUID(value) {
+			v.fail(path, "must be a uuid")
+		}
+	default:
+		switch value.(type) {
+		case string, json.Number, bool:
+		default:
+			v.fail(path, "must be a "+dataType+" value")
+		}
+	}
+}
+
+func (v *validation) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	e := schemaMismatch
+	for path, problem := range v.problems {
+		e = e.Detail(path, problem)
+	}
+	return e
+}
+
+// tableColumns returns column names and types of the table.
+// failed reads are not cached, next request tries again.
+func tableColumns(ctx context.Context) (map[string]string, error) {
+	columnTypesMu.Lock()
+	defer columnTypesMu.Unlock()
+	if columnTypes != nil {
+		return columnTypes, nil
+	}
+	query := seecool.Select("information_schema.columns", "column_name", "data_type").
+		Equal("table_schema", tableSchema).
+		Equal("table_name", conf.Table)
+	result, err := trace.QueryJson(ctx, base, query)
+	if err != nil {
+		return nil, apierr.Unavailable.Wrap(err)
+	}
+	var rows []map[string]string
+	err = json.Unmarshal(result, &rows)
+	if err != nil {
+		return nil, apierr.Internal.Wrap(err)
+	}
+	if len(rows) == 0 {
+		return nil, apierr.Internal.Wrap(errTableNotFound)
+	}
+	types := make(map[string]string, len(rows))
+	for _, row := range rows {
+		types[row["column_name"]] = row["data_type"]
+	}
+	columnTypes = types
+	return columnTypes, nil
+}
+
+func isInteger(value interface{}) bool {
+	var s string
+	switch t := value.(type) {
+	case json.Number:
+		s = t.String()
+	case string:
+		s = t
+	default:
+		return false
+	}
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+func isNumber(value interface{}) bool {
+	var s string
+	switch t := value.(type) {
+	case json.Number:
+		s = t.String()
+	case string:
+		s = t
+	default:
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+func isBool(value interface{}) bool {
+	switch t := value.(type) {
+	case bool:
+		return true
+	case string:
+		_, err := strconv.ParseBool(t)
+		return err == nil
+	}
+	return false
+}
+
+func isUUID(value interface{}) bool {
+	s, ok := value.(string)
+	if !ok || len(s) != 36 {
+		return false
+	}
+	for i, r := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func contains(arr []string, value string) bool {
+	for _, v := range arr {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
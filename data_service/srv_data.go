@@ -1,12 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
-	"errorx"
+	"ecomm/internal/apierr"
+	"ecomm/internal/config"
+	"ecomm/internal/cors"
+	"ecomm/internal/health"
+	"ecomm/internal/logging"
+	"ecomm/internal/metrics"
+	"ecomm/internal/mtls"
+	"ecomm/internal/registry"
+	"ecomm/internal/trace"
+	"encoding/json"
 	"io/ioutil"
 	"jin"
 	"log"
 	"net/http"
+	"path/filepath"
 	"penman"
 	"seecool"
 	"strings"
@@ -21,86 +33,142 @@ const (
 	envDatabaseDir string = "curr/.env_database"
 	envServiceDir  string = "curr/.env_service"
 	envMainDir     string = "curr/../.env_main"
+	envCorsDir     string = "curr/.env_cors"
+	envNormalize   string = "curr/.env_normalize"
+	envGraphQL     string = "curr/.env_graphql"
 
 	// log strings
-	srvStart   string = ">> Data Service Started"
-	srvEnd     string = ">> Data Service Shutdown Unexpectedly"
-	reqArrived string = ">> Request Arrived At"
-	reqBody    string = ">> Request Body:"
+	srvConfig    string = ">> Data Service Configuration:"
+	srvConfigErr string = ">> Data Service Configuration Failed. Error:"
+	srvStart     string = ">> Data Service Started"
+	srvEnd       string = ">> Data Service Shutdown Unexpectedly"
+	reqArrived   string = ">> Request Arrived At"
+	reqBody      string = ">> Request Body:"
+	reqFailed    string = ">> Data Service Request Failed:"
+	reqDone      string = ">> Data Service Request Done"
 )
 
 var (
 
-	// service environment map
-	envServiceMap map[string]string
+	// service configuration
+	conf dataConfig
 
-	// main environment environment map
-	envMainMap map[string]string
+	// my service name, also the certificate name
+	myServiceName string = "data_service"
 
-	// database environment file
-	dbEnv string
+	// mutual tls configuration of the main server
+	tlsConfig *tls.Config
 
-	// data service main port
-	mainPort string
+	// cross origin policies of the service
+	corsPolicy *cors.CORS
 
 	// main database pointer
 	base *sql.DB
 
-	// json format schemes
-	responseScheme *jin.Scheme
+	// liveness, readiness and graceful shutdown
+	checks *health.Health
 
 	// errors
-	recordNotExists   *errorx.Error = errorx.New("Not Exists Error", "Record does not exists", 0)
-	keyValuePairerror *errorx.Error = errorx.New("Wrong Number", "One key&value pair expected", 1)
-	wrongAction       *errorx.Error = errorx.New("Wrong Action", "Wrong or missing 'action' key", 2)
-	statError         *errorx.Error = errorx.New("Not Allowed", "Status method not allowed", 3)
-	dataFailed        *errorx.Error = errorx.New("Request Failed", "Data Service Request Failed", 3)
-	dataSuccess       *errorx.Error = errorx.New("Request Done", "Data Service Request Done", 3)
-	emptyFields       *errorx.Error = errorx.New("Emtyp Field", "Necassary field is empty", 3)
+	recordNotExists   *apierr.Error = apierr.NotFound
+	keyValuePairerror *apierr.Error = apierr.InvalidField.Detail("body", "one key and value pair expected")
+	wrongAction       *apierr.Error = apierr.InvalidField.Detail("action", "must be insert, update, delete, search or searchx")
+	statError         *apierr.Error = apierr.MethodNotAllowed
+	emptyFields       *apierr.Error = apierr.MissingField
 )
 
-func init() {
-	var err error
-	// read main env. file
-	envMainMap, err = seecool.GetEnv(envMainDir)
+// setup reads the configuration and prepares shared clients,
+// it runs from main so tests of the package need no environment.
+func setup() {
+	// read main, service and database environment files
+	err := config.Load(&conf, configSources...)
 	if err != nil {
-		panic(err)
+		log.Fatalln(srvConfigErr, err)
+	}
+	logging.Setup(myServiceName, conf.LogLevel, conf.LogRedact)
+	log.Println(srvConfig + "\n" + config.Redact(&conf))
+	// internal calls are authenticated with mutual tls
+	certDir := config.Path(conf.CertDir)
+	if !filepath.IsAbs(certDir) {
+		certDir = filepath.Join(penman.GetCurrentDir(), certDir)
 	}
-	mainPort = envMainMap["data_service_port"]
-	// read env_service file
-	envServiceMap, err = seecool.GetEnv(envServiceDir)
+	tlsConfig, err = mtls.ServerConfig(certDir, myServiceName, conf.Peers)
 	if err != nil {
-		panic(err)
+		log.Fatalln(srvConfigErr, err)
+	}
+	// optional self registration, static endpoints need no heartbeat
+	if conf.RegistryURL != "" && conf.AdvertiseURL != "" {
+		client, err := mtls.Client(certDir, myServiceName)
+		if err != nil {
+			log.Fatalln(srvConfigErr, err)
+		}
+		go registry.Heartbeat(client, conf.RegistryURL, myServiceName, conf.AdvertiseURL, conf.Heartbeat)
+	}
+	// spans of the service
+	err = trace.Setup(myServiceName, conf.TraceExporter, conf.TraceEndpoint)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	// cross origin policies
+	envCors, err := config.Map(config.Source{Path: envCorsDir})
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	corsPolicy, err = cors.New(envCors)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	// column normalizers, applied on insert, update and exact matches
+	envNorm, err := config.Map(config.Source{Path: envNormalize, Optional: true})
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
 	}
-	// read env_database file
-	dbEnv = penman.SRead(envDatabaseDir)
-	if dbEnv == "" {
-		panic(dbEnv)
+	columnPipelines, err = loadNormalizers(envNorm, conf.Table)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	// graphql field rules by user type
+	envGql, err := config.Map(config.Source{Path: envGraphQL, Optional: true})
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
 	}
-	// response scheme
-	responseScheme = jin.MakeScheme("status", "error")
+	gqlRules = loadGraphQLRules(envGql, conf.Table)
 }
 
 func main() {
+	setup()
 	dbConn()
-	log.Println(srvStart, "port:", mainPort)
-	http.HandleFunc("/", dataHandle)
-	err := http.ListenAndServe(":"+mainPort, nil)
-	// handle later
-	log.Println(srvEnd, err)
-	defer base.Close()
+	checks = health.New()
+	checks.OnStop(trace.Shutdown)
+	checks.Add("database", health.Ping(base))
+	checks.OnStop(base.Close)
+	checks.Register(http.DefaultServeMux)
+	metrics.DBStats(base)
+	if conf.MetricsPort != "" {
+		go metrics.Listen(conf.MetricsPort)
+	}
+	log.Println(srvStart, "port:", conf.Port)
+	// routes are registered and documented together
+	apiDoc.Register(http.DefaultServeMux, routes()...)
+	http.Handle("/openapi.json", apiDoc)
+	server := &http.Server{
+		Addr:      ":" + conf.Port,
+		Handler:   trace.Handler(true, logging.Handler(nil, metrics.Instrument(http.DefaultServeMux, corsPolicy.Handler(http.DefaultServeMux)))),
+		TLSConfig: tlsConfig,
+	}
+	err := checks.Serve(server, func() error { return server.ListenAndServeTLS("", "") }, conf.ShutdownTimeout)
+	if err != nil {
+		log.Println(srvEnd, err)
+	}
 }
 
 func dataHandle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Origin, cache-control")
 	w.Header().Set("Content-Type", "application/json")
 	// request log
-	log.Println(reqArrived, r.RemoteAddr)
+	log.Println(reqArrived, r.RemoteAddr, trace.ID(r.Context()))
 
 	// method check
 	if string(r.Method) != http.MethodPost {
-		failHandle(w, statError, http.StatusInternalServerError)
+		failHandle(w, statError, http.StatusMethodNotAllowed)
 		return
 	}
 	// body read for json parse.
@@ -111,90 +179,71 @@ func dataHandle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// request body log.
-	log.Println(reqBody, string(json))
+	logging.From(r.Context()).Debug(reqBody, "body", logging.Body(json))
 
 	// action value determines the CRUD ection.
-	action, err := jin.GetString(json, "action")
+	req, err := decodeRequest(json)
 	if err != nil {
-		lene := len(err.Error())
-		errCode := err.Error()[lene-3 : lene-1]
-		if errCode == "08" {
-			failHandle(w, dataFailed, http.StatusBadRequest)
-			return
-		}
-		failHandle(w, dataFailed, http.StatusInternalServerError)
+		failHandle(w, apierr.InvalidJSON.Wrap(err), http.StatusBadRequest)
+		return
+	}
+	if req.Action == "" {
+		failHandle(w, emptyFields.Detail("action", "required"), http.StatusBadRequest)
+		return
+	}
+	result, err, status := runAction(r.Context(), req.Action, json)
+	if err != nil {
+		failHandle(w, err, status)
 		return
 	}
+	doneHandle(w, result)
+}
 
+// runAction validates and runs an action request,
+// legacy action endpoint and rest routes share it.
+// result is nil for actions other than searches.
+func runAction(ctx context.Context, action string, json []byte) ([]byte, error, int) {
+	// declared schema of the action, unknown fields are rejected
+	err := validateRequest(ctx, action, json)
+	if err != nil {
+		return nil, err, http.StatusBadRequest
+	}
+	var status int
 	switch action {
 	case "insert":
-		err, status := insertRecord(json)
-		if err != nil {
-			failHandle(w, err, status)
-			return
-		}
+		err, status = insertRecord(ctx, json)
 	case "update":
-		err, status := updateRecord(json)
-		if err != nil {
-			failHandle(w, err, status)
-			return
-		}
+		err, status = updateRecord(ctx, json)
 	case "delete":
-		err, status := deleteRecord(json)
-		if err != nil {
-			failHandle(w, err, status)
-			return
-		}
-	case "search", "searchx":
-		var (
-			result []byte
-			err    error
-			status int
-		)
-		if action == "search" {
-			result, err, status = searchRecord(json)
-		} else {
-			result, err, status = searchxRecord(json)
-		}
-		if err != nil {
-			failHandle(w, err, status)
-			return
-		}
-		log.Println(dataSuccess)
-		w.WriteHeader(http.StatusOK)
-		w.Write(result)
-		return
+		err, status = deleteRecord(ctx, json)
+	case "search":
+		return searchRecord(ctx, json)
+	case "searchx":
+		return searchxRecord(ctx, json)
 	default:
-		failHandle(w, wrongAction, http.StatusBadRequest)
-		return
+		return nil, wrongAction, http.StatusBadRequest
 	}
-	doneHandle(w)
+	return nil, err, status
 }
 
-func searchRecord(json []byte) ([]byte, error, int) {
+func searchRecord(ctx context.Context, json []byte) ([]byte, error, int) {
 	keys, values, err := jin.GetKeysValues(json, "body")
 	if err != nil {
 		return nil, err, http.StatusInternalServerError
 	}
 	if len(keys) == 0 && len(values) == 0 {
-		return nil, emptyFields, http.StatusBadRequest
+		return nil, emptyFields.Detail("body", "required"), http.StatusBadRequest
 	}
-	cols, err := jin.GetStringArray(json, "columns")
+	req, err := decodeRequest(json)
 	if err != nil {
-		lene := len(err.Error())
-		errCode := err.Error()[lene-3 : lene-1]
-		if errCode != "08" {
-			return nil, err, http.StatusInternalServerError
-		} else {
-			cols = []string{}
-		}
+		return nil, apierr.InvalidJSON.Wrap(err), http.StatusBadRequest
 	}
-	relation, err := jin.GetString(json, "relation")
-	if err != nil {
-		return nil, emptyFields, http.StatusBadRequest
+	cols := req.Columns
+	if req.Relation == "" {
+		return nil, emptyFields.Detail("relation", "required"), http.StatusBadRequest
 	}
-	query := seecool.Select(envServiceMap["table"], cols...)
-	switch strings.ToLower(relation) {
+	query := seecool.Select(conf.Table, cols...)
+	switch strings.ToLower(req.Relation) {
 	case "and":
 		for i := 0; i < len(keys); i++ {
 			query = query.Cond(keys[i], "~*", values[i])
@@ -204,22 +253,7 @@ func searchRecord(json []byte) ([]byte, error, int) {
 			query = query.Or(keys[i], "~*", values[i])
 		}
 	}
-	orderCol, err := jin.GetString(json, "order_column")
-	if err != nil {
-		lene := len(err.Error())
-		errCode := err.Error()[lene-3 : lene-1]
-		if errCode != "08" {
-			return nil, err, http.StatusInternalServerError
-		}
-	}
-	orderBy, err := jin.GetString(json, "order_by")
-	if err != nil {
-		lene := len(err.Error())
-		errCode := err.Error()[lene-3 : lene-1]
-		if errCode != "08" {
-			return nil, err, http.StatusInternalServerError
-		}
-	}
+	orderCol, orderBy := req.OrderColumn, req.OrderBy
 	if orderCol != "" {
 		if orderBy != "" {
 			if strings.ToLower(orderBy) == "desc" {
@@ -231,60 +265,35 @@ func searchRecord(json []byte) ([]byte, error, int) {
 			query = query.Order(orderCol)
 		}
 	}
-	if err != nil {
-		lene := len(err.Error())
-		errCode := err.Error()[lene-3 : lene-1]
-		if errCode != "08" {
-			return nil, err, http.StatusInternalServerError
-		} else {
-			cols = []string{}
-		}
-	}
-	result, err := seecool.QueryJson(base, query)
+	result, err := trace.QueryJson(ctx, base, query)
 	if err != nil {
 		return nil, err, http.StatusInternalServerError
 	}
 	return result, nil, http.StatusOK
 }
 
-func searchxRecord(json []byte) ([]byte, error, int) {
+func searchxRecord(ctx context.Context, json []byte) ([]byte, error, int) {
 	keys, values, err := jin.GetKeysValues(json, "body")
 	if err != nil {
 		return nil, err, http.StatusInternalServerError
 	}
 	if len(keys) == 0 && len(values) == 0 {
-		return nil, emptyFields, http.StatusBadRequest
+		return nil, emptyFields.Detail("body", "required"), http.StatusBadRequest
 	}
-	cols, err := jin.GetStringArray(json, "columns")
+	req, err := decodeRequest(json)
 	if err != nil {
-		lene := len(err.Error())
-		errCode := err.Error()[lene-3 : lene-1]
-		if errCode != "08" {
-			return nil, err, http.StatusInternalServerError
-		} else {
-			cols = []string{}
-		}
-	}
-	query := seecool.Select(envServiceMap["table"], cols...)
-	for i := 0; i < len(keys); i++ {
-		query = query.Equal(keys[i], values[i])
+		return nil, apierr.InvalidJSON.Wrap(err), http.StatusBadRequest
 	}
-	orderCol, err := jin.GetString(json, "order_column")
+	cols := req.Columns
+	err = normalizeValues(keys, values)
 	if err != nil {
-		lene := len(err.Error())
-		errCode := err.Error()[lene-3 : lene-1]
-		if errCode != "08" {
-			return nil, err, http.StatusInternalServerError
-		}
+		return nil, err, http.StatusBadRequest
 	}
-	orderBy, err := jin.GetString(json, "order_by")
-	if err != nil {
-		lene := len(err.Error())
-		errCode := err.Error()[lene-3 : lene-1]
-		if errCode != "08" {
-			return nil, err, http.StatusInternalServerError
-		}
+	query := seecool.Select(conf.Table, cols...)
+	for i := 0; i < len(keys); i++ {
+		query = query.Equal(keys[i], values[i])
 	}
+	orderCol, orderBy := req.OrderColumn, req.OrderBy
 	if orderCol != "" {
 		if orderBy != "" {
 			if strings.ToLower(orderBy) == "desc" {
@@ -296,14 +305,14 @@ func searchxRecord(json []byte) ([]byte, error, int) {
 			query = query.Order(orderCol)
 		}
 	}
-	result, err := seecool.QueryJson(base, query)
+	result, err := trace.QueryJson(ctx, base, query)
 	if err != nil {
 		return nil, err, http.StatusInternalServerError
 	}
 	return result, nil, http.StatusOK
 }
 
-func deleteRecord(json []byte) (error, int) {
+func deleteRecord(ctx context.Context, json []byte) (error, int) {
 
 	keys, values, err := jin.GetKeysValues(json, "body")
 	if err != nil {
@@ -314,64 +323,78 @@ func deleteRecord(json []byte) (error, int) {
 	}
 	// primary or unique key & value pair
 	key := keys[0]
-	value := values[0]
+	value, err := normalize(keys[0], values[0])
+	if err != nil {
+		return err, http.StatusBadRequest
+	}
 	// record exists or not
-	query := seecool.Select(envServiceMap["table"]).Equal(key, value)
-	result, err := seecool.QueryJson(base, query)
+	query := seecool.Select(conf.Table).Equal(key, value)
+	result, err := trace.QueryJson(ctx, base, query)
 	if err != nil {
 		return err, http.StatusInternalServerError
 	}
 	if string(result) == "[]" {
-		return recordNotExists, http.StatusBadRequest
+		return recordNotExists, http.StatusNotFound
 	}
-	query = seecool.Delete(envServiceMap["table"]).Equal(key, value)
-	_, err = base.Query(query.String())
+	query = seecool.Delete(conf.Table).Equal(key, value)
+	_, err = trace.Exec(ctx, base, query.String())
 	if err != nil {
 		return err, http.StatusInternalServerError
 	}
 	return nil, http.StatusOK
 }
 
-func updateRecord(json []byte) (error, int) {
+func updateRecord(ctx context.Context, json []byte) (error, int) {
 	jsonMap, err := jin.GetMap(json)
 	if err != nil {
 		return err, http.StatusInternalServerError
 	}
 	// record exists or not
-	query := seecool.Select(envServiceMap["table"]).
-		Equal(jsonMap["key"], jsonMap["value"])
-	result, err := seecool.QueryJson(base, query)
+	value, err := normalize(jsonMap["key"], jsonMap["value"])
+	if err != nil {
+		return err, http.StatusBadRequest
+	}
+	query := seecool.Select(conf.Table).
+		Equal(jsonMap["key"], value)
+	result, err := trace.QueryJson(ctx, base, query)
 	if err != nil {
 		return err, http.StatusInternalServerError
 	}
 	if string(result) == "[]" {
-		return recordNotExists, http.StatusBadRequest
+		return recordNotExists, http.StatusNotFound
 	}
 	keys, values, err := jin.GetKeysValues(json, "body")
 	if err != nil {
 		return err, http.StatusInternalServerError
 	}
-	query = seecool.Update(envServiceMap["table"]).
+	err = normalizeValues(keys, values)
+	if err != nil {
+		return err, http.StatusBadRequest
+	}
+	query = seecool.Update(conf.Table).
 		Keys(keys...).
 		Values(values...).
-		Equal(jsonMap["key"], jsonMap["value"])
-	_, err = base.Query(query.String())
+		Equal(jsonMap["key"], value)
+	_, err = trace.Exec(ctx, base, query.String())
 	if err != nil {
 		return err, http.StatusInternalServerError
 	}
 	return nil, http.StatusOK
 }
 
-func insertRecord(json []byte) (error, int) {
+func insertRecord(ctx context.Context, json []byte) (error, int) {
 	keys, values, err := jin.GetKeysValues(json, "body")
 	if err != nil {
 		return err, http.StatusInternalServerError
 	}
-	values = toLowerArray(values)
-	query := seecool.Insert(envServiceMap["table"]).
+	err = normalizeValues(keys, values)
+	if err != nil {
+		return err, http.StatusBadRequest
+	}
+	query := seecool.Insert(conf.Table).
 		Keys(keys...).
 		Values(values...)
-	_, err = base.Query(query.String())
+	_, err = trace.Exec(ctx, base, query.String())
 	if err != nil {
 		return err, http.StatusInternalServerError
 	}
@@ -380,36 +403,40 @@ func insertRecord(json []byte) (error, int) {
 
 func dbConn() {
 	var err error
-	base, err = sql.Open(envServiceMap["user"], dbEnv)
+	base, err = sql.Open(conf.Driver, conf.DSN())
 	if err != nil {
 		panic(err)
 	}
 }
 
-func statusFailed(err error) []byte {
-	return responseScheme.MakeJson("Failed", seecool.EscapeQuote(err.Error()))
+// actionRequest holds the optional top level fields of an action request,
+// missing fields are left empty.
+type actionRequest struct {
+	Action      string   `json:"action"`
+	Columns     []string `json:"columns"`
+	Relation    string   `json:"relation"`
+	OrderColumn string   `json:"order_column"`
+	OrderBy     string   `json:"order_by"`
 }
 
-func statusSuccess() []byte {
-	return responseScheme.MakeJson("OK", "null")
+// decodeRequest reads the top level fields of an action request.
+func decodeRequest(body []byte) (*actionRequest, error) {
+	req := &actionRequest{}
+	err := json.Unmarshal(body, req)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
+// failHandle writes the error envelope,
+// status is used only when err is not an *apierr.Error.
 func failHandle(w http.ResponseWriter, err error, status int) {
-	log.Println(dataFailed.Link(err))
-	w.WriteHeader(status)
-	w.Write(statusFailed(dataFailed))
-	dataFailed.ClearLink()
-}
-
-func doneHandle(w http.ResponseWriter) {
-	log.Println(dataSuccess)
-	w.WriteHeader(http.StatusOK)
-	w.Write(statusSuccess())
+	e := apierr.Fail(w, err, status)
+	log.Println(reqFailed, e.Status, e, e.Fields())
 }
 
-func toLowerArray(arr []string) []string {
-	for i, _ := range arr {
-		arr[i] = strings.ToLower(arr[i])
-	}
-	return arr
+func doneHandle(w http.ResponseWriter, data []byte) {
+	log.Println(reqDone)
+	apierr.Done(w, data)
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"ecomm/internal/apierr"
+	"ecomm/internal/upstream"
+	"jin"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// api keys are sent as 'Authorization: Bearer ecomm_...'
+	bearerPrefix string = "Bearer "
+)
+
+var (
+	// json format schemes
+	apiKeyVerifyScheme *jin.Scheme = jin.MakeScheme("key")
+)
+
+// keySessionKey is the context key of api key sessions.
+type keySessionKey struct{}
+
+// apiKeyHandler authenticates api key requests before routing.
+// a route accepts api keys only when it declares a scope in keyScopes
+// and the key has that scope, other routes reject them.
+// the key session is kept in the request context, see keySession.
+func apiKeyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), bearerPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scope, ok := keyScopes[r.URL.Path]
+		if !ok {
+			failHandle(w, apierr.Forbidden.Detail("authorization", "api keys are not accepted on this route"), http.StatusForbidden)
+			return
+		}
+		session, _, err := bearerSession(r)
+		if err != nil {
+			failHandle(w, apierr.BadGateway.Wrap(err), http.StatusBadGateway)
+			return
+		}
+		if session.Values["auth"] != "true" {
+			failHandle(w, notAuthorized, http.StatusUnauthorized)
+			return
+		}
+		if !hasScope(session, scope) {
+			failHandle(w, apierr.Forbidden.Detail("scope", scope+" required"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), keySessionKey{}, session)))
+	})
+}
+
+// keySession returns the api key session of the request,
+// nil when the request was not authenticated with an api key.
+func keySession(r *http.Request) *sessions.Session {
+	session, _ := r.Context().Value(keySessionKey{}).(*sessions.Session)
+	return session
+}
+
+// bearerSession resolves an api key header to a session.
+// the session is never saved, no cookie is set for api key requests.
+// second return value reports whether the request carries a key at all.
+func bearerSession(r *http.Request) (*sessions.Session, bool, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return nil, false, nil
+	}
+	key := strings.TrimSpace(header[len(bearerPrefix):])
+	keySession := sessions.NewSession(store, "login")
+	keySession.Values["auth"] = "false"
+	resp, status, err := authenticationControl(upstream.Idempotent(r.Context()), "/apikey/verify", apiKeyVerifyScheme.MakeJson(key))
+	if err != nil {
+		return keySession, true, err
+	}
+	if status != statusOK {
+		return keySession, true, nil
+	}
+	respMap, err := jin.GetMap(resp, "data")
+	if err != nil {
+		return keySession, true, err
+	}
+	scopes, err := jin.GetStringArray(resp, "data", "scopes")
+	if err != nil {
+		return keySession, true, err
+	}
+	for k, v := range respMap {
+		keySession.Values[k] = v
+	}
+	keySession.Values["scopes"] = strings.Join(scopes, ",")
+	keySession.Values["auth"] = "true"
+	return keySession, true, nil
+}
+
+// hasScope reports whether the session may use the scope.
+// cookie sessions act as the user itself and have every scope.
+func hasScope(session *sessions.Session, scope string) bool {
+	if session.Values["auth"] != "true" {
+		return false
+	}
+	if _, ok := session.Values["key_prefix"]; !ok {
+		return true
+	}
+	scopes, _ := session.Values["scopes"].(string)
+	for _, s := range strings.Split(scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	mux := contractMux(t)
+	handler := apiKeyHandler(mux)
+	principal := func(scopes string) string {
+		return `{"user_id":"user-1","type":"standart","email":"ada@example.com","scopes":` + scopes + `,"key_prefix":"abcd"}`
+	}
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		verify string
+		status int
+	}{
+		{"account route", "/profile", `{}`, principal(`["data:read"]`), http.StatusForbidden},
+		{"login route", "/login", `{"action":"login"}`, principal(`["data:read"]`), http.StatusForbidden},
+		{"missing scope", "/graphql", `{"query":"{ test_users { user_id } }"}`, principal(`["data:write"]`), http.StatusForbidden},
+		{"invalid key", "/graphql", `{"query":"{ test_users { user_id } }"}`, "null", http.StatusUnauthorized},
+		{"declared scope", "/graphql", `{"query":"{ test_users { user_id } }"}`, principal(`["data:read"]`), http.StatusOK},
+	}
+	defer delete(contractData, "/apikey/verify")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contractData["/apikey/verify"] = tt.verify
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", bearerPrefix+"ecomm_abcd_secret")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			for _, op := range apiDoc.Operations() {
+				if op.Method == http.MethodPost && op.Path == tt.path {
+					checkResponse(t, op, rec)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"ecomm/internal/config"
+	"time"
+)
+
+// gatewayConfig is the configuration of gateway service.
+// session secret is the whole content of '.secret' file.
+type gatewayConfig struct {
+	config.Tracing
+	config.Logging
+
+	Port    string `env:"gate_service_port" required:"true"`
+	CertDir string `env:"cert_dir" required:"true"`
+	WebURL  string `env:"web_url" required:"true"`
+	Secret  string `env:"secret" required:"true" secret:"true"`
+
+	RegistryPort     string        `env:"registry_port"`
+	RegistryPeers    []string      `env:"registry_peers"`
+	RegistryTTL      time.Duration `env:"registry_ttl" default:"30s"`
+	RegistryCooldown time.Duration `env:"registry_cooldown" default:"10s"`
+
+	UpstreamTimeout   time.Duration `env:"upstream_timeout" default:"5s"`
+	UpstreamRetries   int           `env:"upstream_retries" default:"2"`
+	UpstreamThreshold int           `env:"upstream_threshold" default:"5"`
+	UpstreamOpenFor   time.Duration `env:"upstream_open_for" default:"30s"`
+
+	// internal listener of '/metrics', disabled when empty
+	MetricsPort string `env:"gate_metrics_port"`
+
+	// active requests are drained in this duration on shutdown
+	ShutdownTimeout time.Duration `env:"shutdown_timeout" default:"15s"`
+
+	TLSCert        string        `env:"tls_cert" required:"true"`
+	TLSKey         string        `env:"tls_key" required:"true"`
+	TLSReload      time.Duration `env:"tls_reload" default:"30s"`
+	RedirectPort   string        `env:"redirect_port"`
+	HSTSMaxAge     int           `env:"hsts_max_age" default:"31536000"`
+	CookieSecure   bool          `env:"cookie_secure" default:"true"`
+	CookieSameSite string        `env:"cookie_samesite" default:"lax"`
+	CSRFOrigins    []string      `env:"csrf_origins"`
+}
+
+var (
+	// environment files in override order
+	configSources []config.Source = []config.Source{
+		{Path: envMainDir},
+		{Path: envGatewayDir},
+		{Path: secretDir, Raw: "secret"},
+	}
+)
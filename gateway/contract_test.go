@@ -0,0 +1,199 @@
+package main
+
+import (
+	"ecomm/internal/openapi"
+	"ecomm/internal/registry"
+	"ecomm/internal/upstream"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// contractData is the 'data' that the stub services answer by path.
+var contractData map[string]string = map[string]string{
+	"/":              `{"user_id":"user-1","type":"standart","email":"ada@example.com"}`,
+	"/catalog":       `[{"product_id":"product-1","name":"Notebook","description":"A5, dotted.","price_cents":875}]`,
+	"/signup":        `{"user_id":"user-1","type":"standart","email":"ada@example.com"}`,
+	"/oidc/start":    `{"url":"https://id.example.com/authorize?state=state-1","state":"state-1"}`,
+	"/oidc/callback": `{"user_id":"user-1","type":"standart","email":"ada@example.com"}`,
+	"/email/verify":  `{"email":"ada@example.com"}`,
+	"/graphql":       `{"test_users":[]}`,
+}
+
+// contractCase is the request of a documented operation.
+type contractCase struct {
+	query string
+	body  string
+	// status of a logged in request, success status of the operation when zero
+	status int
+}
+
+// contractCases are requests that need more than an empty object.
+var contractCases map[string]contractCase = map[string]contractCase{
+	"POST /login":        {body: `{"action":"login","login":"ada","password":"secret"}`},
+	"POST /logout":       {status: http.StatusNotImplemented},
+	"POST /signup":       {body: `{"username":"ada_l","email":"ada@example.com","password":"secret123"}`},
+	"POST /email/verify": {body: `{"token":"token-1"}`},
+	"POST /graphql":      {body: `{"query":"{ test_users { user_id } }"}`},
+	"GET /oidc/start":    {query: "?provider=example"},
+	"GET /oidc/callback": {query: "?state=state-1&code=code-1"},
+}
+
+// contractMux registers the routes with stub internal services.
+func contractMux(t *testing.T) *http.ServeMux {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := contractData[r.URL.Path]
+		if !ok {
+			data = "{}"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		// null data stands for a rejected request
+		if data == "null" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"status":"Failed","data":null,"error":{"code":"unauthorized","message":"Unauthorized"}}`)
+			return
+		}
+		io.WriteString(w, `{"status":"OK","data":`+data+`,"error":null}`)
+	}))
+	t.Cleanup(stub.Close)
+	services = registry.New(map[string]string{
+		"services":     "auth_service, data_service",
+		"auth_service": stub.URL,
+		"data_service": stub.URL,
+	}, time.Minute, time.Second)
+	upstreams = upstream.New(stub.Client(), services, upstream.Options{})
+	store = sessions.NewCookieStore([]byte("contract-test-secret-0123456789ab"))
+	store.Options = &sessions.Options{Path: "/", HttpOnly: true}
+	conf.WebURL = "https://localhost:8080"
+	apiDoc = openapi.New("Gateway", "test")
+	mux := http.NewServeMux()
+	apiDoc.Register(mux, routes()...)
+	return mux
+}
+
+// loginCookie returns the cookie of a logged in session.
+func loginCookie(t *testing.T) *http.Cookie {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	session, err := store.Get(req, "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values["auth"] = "true"
+	session.Values["user_id"] = "user-1"
+	session.Values["type"] = "standart"
+	session.Values["oidc_state"] = "state-1"
+	err = session.Save(req, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+// checkResponse checks the status and the body against the document.
+func checkResponse(t *testing.T, op openapi.Operation, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code == op.Success() {
+		if rec.Code >= 300 && rec.Code < 400 && rec.Header().Get("Location") == "" {
+			t.Fatalf("%s %s: redirect without location", op.Method, op.Path)
+		}
+		err := op.ValidateSuccess(rec.Body.Bytes())
+		if err != nil {
+			t.Fatalf("%s %s: success body %s: %v", op.Method, op.Path, rec.Body.String(), err)
+		}
+		return
+	}
+	documented := false
+	for _, code := range op.Errors {
+		documented = documented || code == rec.Code
+	}
+	if !documented {
+		t.Fatalf("%s %s: status %d is not documented: %s", op.Method, op.Path, rec.Code, rec.Body.String())
+	}
+	err := openapi.Failure().Validate(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("%s %s: error body %s: %v", op.Method, op.Path, rec.Body.String(), err)
+	}
+}
+
+func TestContractRoutes(t *testing.T) {
+	mux := contractMux(t)
+	seen := make(map[string]bool)
+	for _, route := range routes() {
+		for _, op := range route.Operations {
+			key := op.Method + " " + op.Path
+			if seen[key] {
+				t.Fatalf("%s is documented twice", key)
+			}
+			seen[key] = true
+			req := httptest.NewRequest(op.Method, op.Path, nil)
+			if _, pattern := mux.Handler(req); pattern != route.Pattern {
+				t.Fatalf("%s is served by %q, documented in %q", key, pattern, route.Pattern)
+			}
+			// every route answers undocumented methods with 405
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, op.Path, strings.NewReader("{}")))
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("PUT %s: got status %d, want 405", op.Path, rec.Code)
+			}
+			checkResponse(t, op, rec)
+		}
+	}
+}
+
+func TestContractResponses(t *testing.T) {
+	mux := contractMux(t)
+	for _, op := range apiDoc.Operations() {
+		key := op.Method + " " + op.Path
+		c := contractCases[key]
+		if c.body == "" {
+			c.body = "{}"
+		}
+		if c.status == 0 {
+			c.status = op.Success()
+		}
+		t.Run(key, func(t *testing.T) {
+			// logged in requests succeed
+			req := httptest.NewRequest(op.Method, op.Path+c.query, strings.NewReader(c.body))
+			req.AddCookie(loginCookie(t))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != c.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, c.status, rec.Body.String())
+			}
+			checkResponse(t, op, rec)
+
+			// requests without a session get documented answers
+			req = httptest.NewRequest(op.Method, op.Path+c.query, strings.NewReader(c.body))
+			rec = httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			checkResponse(t, op, rec)
+		})
+	}
+}
+
+func TestContractDocument(t *testing.T) {
+	mux := contractMux(t)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	for _, op := range apiDoc.Operations() {
+		path := `"` + op.Path + `"`
+		if !strings.Contains(rec.Body.String(), path) {
+			t.Fatalf("%s is not in the document", op.Path)
+		}
+		for _, code := range op.Errors {
+			if !strings.Contains(rec.Body.String(), `"`+strconv.Itoa(code)+`"`) {
+				t.Fatalf("%s %s: status %d is not in the document", op.Method, op.Path, code)
+			}
+		}
+	}
+}
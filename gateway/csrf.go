@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"ecomm/internal/apierr"
+	"encoding/hex"
+	"jin"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// token header of unsafe requests
+	csrfHeader string = "X-CSRF-Token"
+	// session key of the synchronizer token
+	csrfKey string = "csrf"
+
+	// log strings
+	csrfRejected string = ">> CSRF Check Failed:"
+)
+
+var (
+	// json format schemes
+	csrfScheme *jin.Scheme = jin.MakeScheme("csrf_token")
+)
+
+// csrfTokenHandle returns the synchronizer token of the session,
+// pages call it once and send the token with every unsafe request.
+// GET /csrf
+func csrfTokenHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	loginSession, err := store.Get(r, "login")
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	token, ok := loginSession.Values[csrfKey].(string)
+	if !ok || token == "" {
+		buff := make([]byte, 32)
+		_, err = rand.Read(buff)
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+		token = hex.EncodeToString(buff)
+		loginSession.Values[csrfKey] = token
+		err = loginSession.Save(r, w)
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	apierr.Done(w, csrfScheme.MakeJson(token))
+}
+
+// csrfHandler protects cookie authenticated unsafe requests.
+// origin (or referer) must be an allowed origin and
+// 'X-CSRF-Token' header must match the session token.
+// requests authenticated with an api key by apiKeyHandler are not exposed to csrf,
+// browsers never add the header by themselves. a bearer header alone skips nothing.
+func csrfHandler(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		allowed[strings.TrimRight(origin, "/")] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if keySession(r) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		origin := requestOrigin(r)
+		if !allowed[origin] {
+			log.Println(csrfRejected, "origin", origin, r.URL.Path)
+			failHandle(w, csrfFailed, http.StatusForbidden)
+			return
+		}
+		loginSession, err := store.Get(r, "login")
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+		expected, _ := loginSession.Values[csrfKey].(string)
+		received := r.Header.Get(csrfHeader)
+		if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(received)) != 1 {
+			log.Println(csrfRejected, "token", r.URL.Path)
+			failHandle(w, csrfFailed, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestOrigin returns scheme://host of the page that sent the request.
+// Origin header is preferred, Referer is used when it is missing.
+func requestOrigin(r *http.Request) string {
+	origin := r.Header.Get("Origin")
+	if origin != "" && origin != "null" {
+		return strings.TrimRight(origin, "/")
+	}
+	referer, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || referer.Scheme == "" || referer.Host == "" {
+		return ""
+	}
+	return referer.Scheme + "://" + referer.Host
+}
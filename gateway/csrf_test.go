@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	mux := contractMux(t)
+	origin := "https://localhost:8080"
+	handler := apiKeyHandler(csrfHandler([]string{origin}, mux))
+	contractData["/apikey/verify"] = `{"user_id":"user-1","type":"standart","email":"ada@example.com","scopes":["data:read"],"key_prefix":"abcd"}`
+	defer delete(contractData, "/apikey/verify")
+
+	// logged in session with a csrf token
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "login")
+	session.Values["auth"] = "true"
+	session.Values["user_id"] = "user-1"
+	session.Values[csrfKey] = "token-1"
+	if err := session.Save(req, rec); err != nil {
+		t.Fatal(err)
+	}
+	cookie := rec.Result().Cookies()[0]
+
+	tests := []struct {
+		name   string
+		path   string
+		bearer string
+		origin string
+		token  string
+		verify string
+		status int
+	}{
+		{"cookie with token", "/profile", "", origin, "token-1", "", http.StatusOK},
+		{"cookie without token", "/profile", "", origin, "", "", http.StatusForbidden},
+		{"cookie from other origin", "/profile", "", "https://evil.example.com", "token-1", "", http.StatusForbidden},
+		{"bearer marked cookie route", "/profile", "ecomm_abcd_secret", "", "", "", http.StatusForbidden},
+		{"invalid key", "/graphql", "ecomm_abcd_wrong", "", "", "null", http.StatusUnauthorized},
+		{"valid key", "/graphql", "ecomm_abcd_secret", "", "", "", http.StatusOK},
+	}
+	principal := contractData["/apikey/verify"]
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contractData["/apikey/verify"] = principal
+			if tt.verify != "" {
+				contractData["/apikey/verify"] = tt.verify
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"query":"{ test_users { user_id } }"}`))
+			req.AddCookie(cookie)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", bearerPrefix+tt.bearer)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.token != "" {
+				req.Header.Set(csrfHeader, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
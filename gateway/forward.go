@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"ecomm/internal/apierr"
+	"ecomm/internal/upstream"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// userHandle forwards account management requests of the logged in user to auth service.
+// user_id is always taken from the session, never from the request body.
+// only cookie sessions are accepted, api keys can not manage accounts.
+func userHandle(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			failHandle(w, statError, http.StatusMethodNotAllowed)
+			return
+		}
+		loginSession, err := store.Get(r, "login")
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+		userID, ok := loginSession.Values["user_id"].(string)
+		if loginSession.Values["auth"] != "true" || !ok {
+			failHandle(w, notAuthorized, http.StatusUnauthorized)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+		defer r.Body.Close()
+		fields := make(map[string]interface{})
+		if len(body) > 0 {
+			err = json.Unmarshal(body, &fields)
+			if err != nil {
+				failHandle(w, err, http.StatusBadRequest)
+				return
+			}
+		}
+		fields["user_id"] = userID
+		body, err = json.Marshal(fields)
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+		forward(r.Context(), w, "auth_service", path, body)
+	}
+}
+
+// emailVerifyHandle completes an email change with the token of the mailed link.
+// the link may be opened without a login, so no session is required.
+// POST /email/verify
+func emailVerifyHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	var fields struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal(raw, &fields)
+	if err != nil || fields.Token == "" {
+		failHandle(w, apierr.MissingField.Detail("token", "required"), http.StatusBadRequest)
+		return
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	forward(r.Context(), w, "auth_service", "/email/verify", body)
+}
+
+// catalogHandle returns the products on sale, no login is needed.
+// GET /catalog
+func catalogHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	resp, err := upstreams.Get(upstream.Idempotent(r.Context()), "data_service", "/catalog")
+	relay(w, resp, err)
+}
+
+// forward posts the body to an internal service and writes its envelope.
+func forward(ctx context.Context, w http.ResponseWriter, service, path string, body []byte) {
+	resp, err := upstreams.Post(ctx, service, path, body)
+	relay(w, resp, err)
+}
+
+// relay writes the envelope of an internal service as it is, with its status code.
+func relay(w http.ResponseWriter, resp []byte, err error) {
+	status := http.StatusOK
+	var rejected *upstream.RejectedError
+	if errors.As(err, &rejected) {
+		status, resp, err = rejected.Status, rejected.Body, nil
+	}
+	if err != nil {
+		failHandle(w, apierr.BadGateway.Wrap(err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
@@ -2,15 +2,28 @@ package main
 
 import (
 	"breakx"
-	"bytes"
-	"errorx"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"ecomm/internal/apierr"
+	"ecomm/internal/config"
+	"ecomm/internal/cors"
+	"ecomm/internal/health"
+	"ecomm/internal/logging"
+	"ecomm/internal/metrics"
+	"ecomm/internal/mtls"
+	"ecomm/internal/ratelimit"
+	"ecomm/internal/registry"
+	"ecomm/internal/trace"
+	"ecomm/internal/upstream"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"jin"
 	"log"
 	"net/http"
+	"path/filepath"
 	"penman"
-	"seecool"
+	"time"
 
 	"github.com/gorilla/sessions"
 )
@@ -18,15 +31,26 @@ import (
 const (
 	// environment directories,
 	// 'curr' keyword is a wild card for 'currentDirectory'
-	// valid wildcard can be user with 'ecoshub/penman' and 'ecoshub/seecool' GetEnv() func.
-	envMainDir string = "curr/../.env_main"
-	secretDir  string = "../.secret"
+	envMainDir     string = "curr/../.env_main"
+	envGatewayDir  string = "curr/.env_gateway"
+	envCorsDir     string = "curr/.env_cors"
+	envLimitDir    string = "curr/.env_ratelimit"
+	envRegistryDir string = "curr/../.env_registry"
+	secretDir      string = "../.secret"
 
 	// log strings
-	srvStart   string = ">> Gateway Service Started."
-	srvEnd     string = ">> Gateway Service Shutdown Unexpectedly. Error:"
-	reqArrived string = ">> Request Arrived At"
-	reqBody    string = ">> Request Body:"
+	srvConfig    string = ">> Gateway Service Configuration:"
+	srvConfigErr string = ">> Gateway Service Configuration Failed. Error:"
+	srvStart     string = ">> Gateway Service Started."
+	srvEnd       string = ">> Gateway Service Shutdown Unexpectedly. Error:"
+	reqArrived   string = ">> Request Arrived At"
+	reqBody      string = ">> Request Body:"
+	loginResult  string = ">> Login Handled"
+	reqFailed    string = ">> Request Failed:"
+
+	// auth service response status strings
+	statusOK  string = apierr.StatusOK
+	statusMFA string = apierr.StatusMFA
 )
 
 var (
@@ -34,72 +58,232 @@ var (
 	// main session store
 	store *sessions.CookieStore
 
-	// session crypto string
-	secret string
+	// service configuration
+	conf gatewayConfig
+
+	// client for internal services, authenticated with mutual tls
+	internalClient *http.Client
+
+	// endpoints of internal services
+	services *registry.Registry
+
+	// internal service calls with retries and circuit breakers
+	upstreams *upstream.Client
+
+	// liveness, readiness and graceful shutdown
+	checks *health.Health
+
+	// public certificate of the main server
+	certificate *certReloader
 
-	// auth service port
-	auth_service_port string
+	// cross origin policies of the service
+	corsPolicy *cors.CORS
 
-	// my service name from env file
-	myServiceName string = "gate_service_port"
+	// request rate limits
+	limiter *ratelimit.Limiter
 
-	// gateway service main port
-	mainPort string
+	// json format schemes
+	loginScheme *jin.Scheme = jin.MakeScheme("auth")
 
-	// main environment environment map
-	envMainMap map[string]string
+	// user fields copied to the session, the cookie is signed but not encrypted
+	sessionFields []string = []string{"user_id", "type", "email"}
 
 	// errors
-	portNotExist   *errorx.Error = errorx.New("Fatal Error", "Main service port does not exist in the main environment file.", 0)
-	secretNotExist *errorx.Error = errorx.New("Fatal Error", "secret not exist in the main environment file.", 1)
+	statError     *apierr.Error = apierr.MethodNotAllowed
+	notAuthorized *apierr.Error = apierr.Unauthorized
+	loginFailed   *apierr.Error = apierr.New("invalid_credentials", http.StatusUnauthorized, "Wrong identifier or password")
+	oidcFailed    *apierr.Error = apierr.New("oidc_failed", http.StatusUnauthorized, "External login failed")
+	csrfFailed    *apierr.Error = apierr.New("csrf_failed", http.StatusForbidden, "CSRF check failed")
+	notDone       *apierr.Error = apierr.New("not_implemented", http.StatusNotImplemented, "Not implemented yet")
+	insecureNone  error         = errors.New("cookie_samesite 'none' requires cookie_secure 'true'")
+	badSameSite   error         = errors.New("cookie_samesite accepts only strict, lax and none")
 )
 
-func init() {
-	var err error
-	// read main env. file
-	envMainMap, err = seecool.GetEnv(envMainDir)
+// setup reads the configuration and prepares shared clients,
+// it runs from main so tests of the package need no environment.
+func setup() {
+	// read main, gateway and secret files
+	err := config.Load(&conf, configSources...)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	logging.Setup("gateway", conf.LogLevel, conf.LogRedact)
+	log.Println(srvConfig + "\n" + config.Redact(&conf))
+	// internal calls are authenticated with mutual tls
+	internalClient, err = mtls.Client(resolvePath(conf.CertDir), "gateway")
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	// static endpoints, services may register more with heartbeats
+	envRegistry, err := config.Map(config.Source{Path: envRegistryDir})
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	services = registry.New(envRegistry, conf.RegistryTTL, conf.RegistryCooldown)
+	upstreams = upstream.New(internalClient, services, upstream.Options{
+		Timeout:   conf.UpstreamTimeout,
+		Retries:   conf.UpstreamRetries,
+		Threshold: conf.UpstreamThreshold,
+		OpenFor:   conf.UpstreamOpenFor,
+	})
+	// public certificate, reloaded when the files change
+	certificate, err = newCertReloader(resolvePath(conf.TLSCert), resolvePath(conf.TLSKey), conf.TLSReload)
 	if err != nil {
-		panic(err)
+		log.Fatalln(srvConfigErr, err)
 	}
-	mainPort = envMainMap[myServiceName]
-	if mainPort == "" {
-		panic(portNotExist)
+	// spans of the service
+	err = trace.Setup("gateway", conf.TraceExporter, conf.TraceEndpoint)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	// cross origin policies
+	envCors, err := config.Map(config.Source{Path: envCorsDir})
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	corsPolicy, err = cors.New(envCors)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	// rate limits, buckets are kept in memory
+	envLimit, err := config.Map(config.Source{Path: envLimitDir})
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	limiter, err = ratelimit.New(envLimit, ratelimit.NewMemoryStore(time.Minute), requestIdentity)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	sameSite, err := sameSiteMode(conf.CookieSameSite)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
 	}
-	secret = penman.SRead(secretDir)
-	if secret == "" {
-		panic(secretNotExist)
+	if sameSite == http.SameSiteNoneMode && !conf.CookieSecure {
+		log.Fatalln(srvConfigErr, insecureNone)
 	}
-	store = sessions.NewCookieStore([]byte(secret))
+	store = sessions.NewCookieStore([]byte(conf.Secret))
 	store.Options = &sessions.Options{
 		Path:     "/",
 		MaxAge:   30 * 24 * 60 * 60, // 1 month
 		HttpOnly: true,
+		Secure:   conf.CookieSecure,
+		SameSite: sameSite,
 	}
 }
 
 func main() {
-	log.Println(srvStart, "port:", mainPort)
-	http.HandleFunc("/login", loginHandle)
-	http.HandleFunc("/logout", logoutHandle)
-	err := http.ListenAndServe(":"+mainPort, nil)
-	log.Println(srvEnd, err)
+	setup()
+	checks = health.New()
+	checks.OnStop(trace.Shutdown)
+	checks.Add("auth_service", upstreamCheck("auth_service"))
+	checks.Add("data_service", upstreamCheck("data_service"))
+	checks.Register(http.DefaultServeMux)
+	if conf.MetricsPort != "" {
+		go metrics.Listen(conf.MetricsPort)
+	}
+	log.Println(srvStart, "port:", conf.Port)
+	// routes are registered and documented together
+	apiDoc.Register(http.DefaultServeMux, routes()...)
+	// optional internal listener for service heartbeats
+	if conf.RegistryPort != "" {
+		go registryListen(conf.RegistryPort)
+	}
+	// optional plain http listener, only redirects to https
+	if conf.RedirectPort != "" {
+		go redirectListen(conf.RedirectPort, conf.Port)
+	}
+	// middlewares, outermost runs first
+	var handler http.Handler = http.DefaultServeMux
+	handler = csrfHandler(conf.CSRFOrigins, handler)
+	// api keys are counted after they are verified
+	handler = limiter.With(keyIdentity).Handler(handler)
+	handler = apiKeyHandler(handler)
+	handler = limiter.Handler(handler)
+	handler = corsPolicy.Handler(handler)
+	handler = hstsHandler(conf.HSTSMaxAge, handler)
+	handler = metrics.Instrument(http.DefaultServeMux, handler)
+	handler = logging.Handler(sessionUser, handler)
+	// clients can not choose trace ids, every request starts a new trace
+	handler = trace.Handler(false, handler)
+	server := &http.Server{
+		Addr:    ":" + conf.Port,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certificate.GetCertificate,
+		},
+	}
+	err := checks.Serve(server, func() error { return server.ListenAndServeTLS("", "") }, conf.ShutdownTimeout)
+	if err != nil {
+		log.Println(srvEnd, err)
+	}
 }
 
 func loginHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
 	// band json error is wrong?
 	loginSession, auth, err := cookieHandle(w, r)
 	if err != nil {
 		failHandle(w, err, http.StatusInternalServerError)
 		return
 	}
-	fmt.Println(auth)
-	fmt.Println(loginSession.Values)
+	logging.From(r.Context()).Debug(loginResult, "auth", auth, "state", loginSession.Values["auth"])
+	switch {
+	case auth:
+		apierr.Done(w, loginScheme.MakeJson("true"))
+	case loginSession.Values["auth"] == "mfa":
+		apierr.Write(w, http.StatusOK, statusMFA, loginScheme.MakeJson("mfa"))
+	default:
+		failHandle(w, loginFailed, http.StatusUnauthorized)
+	}
 }
 
 func logoutHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
 	// later
+	failHandle(w, notDone, http.StatusNotImplemented)
 }
 
+// requestAction returns the 'action' field of a request body,
+// empty when the body or the field is missing.
+func requestAction(body []byte) (string, error) {
+	if len(body) == 0 {
+		return "", nil
+	}
+	req := struct {
+		Action string `json:"action"`
+	}{}
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		return "", err
+	}
+	return req.Action, nil
+}
+
+// mfaLoginBody creates the auth service request of a pending login.
+// codes are copied as json values, 'mfa_token' comes from the session.
+func mfaLoginBody(token string, body []byte) ([]byte, error) {
+	req := struct {
+		Token    string          `json:"mfa_token"`
+		Code     json.RawMessage `json:"code,omitempty"`
+		Recovery json.RawMessage `json:"recovery_code,omitempty"`
+	}{}
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.Token = token
+	return json.Marshal(req)
+}
+
+// cookieHandle logs in with the 'login' cookie session,
+// api keys are not accepted, see keyScopes.
 func cookieHandle(w http.ResponseWriter, r *http.Request) (*sessions.Session, bool, error) {
 	// get cookie named 'login'
 	loginSession, err := store.Get(r, "login")
@@ -112,14 +296,10 @@ func cookieHandle(w http.ResponseWriter, r *http.Request) (*sessions.Session, bo
 		breakx.Point()
 		return loginSession, false, err
 	}
-	action, err := jin.GetString(json, "action")
+	action, err := requestAction(json)
 	if err != nil {
-		lene := len(err.Error())
-		errCode := err.Error()[lene-3 : lene-1]
-		if errCode != "08" {
-			breakx.Point()
-			return loginSession, false, err
-		}
+		breakx.Point()
+		return loginSession, false, err
 	}
 	// shortcut auth
 	if loginSession.Values["auth"] == "true" && action != "login" {
@@ -129,31 +309,48 @@ func cookieHandle(w http.ResponseWriter, r *http.Request) (*sessions.Session, bo
 
 	// mthod check for login action.
 	if string(r.Method) == "POST" {
+		switch action {
 		// wants to login?
-		if action == "login" {
-			resp, auth, err := authenticationControl(json)
+		case "login":
+			resp, status, err := authenticationControl(r.Context(), "/", json)
 			if err != nil {
 				breakx.Point()
 				return loginSession, false, err
 			}
-			if auth {
-				respMap, err := jin.GetMap(resp, "response")
-				if err != nil {
-					breakx.Point()
-					return loginSession, false, err
-				}
-				for k, v := range respMap {
-					loginSession.Values[k] = v
-				}
-				loginSession.Values["auth"] = "true"
-				err = loginSession.Save(r, w)
+			switch status {
+			case statusOK:
+				return grantSession(w, r, loginSession, resp)
+			case statusMFA:
+				// password is correct but second factor is missing.
+				token, err := jin.GetString(resp, "data", "mfa_token")
 				if err != nil {
 					breakx.Point()
 					return loginSession, false, err
 				}
+				return pendingSession(w, r, loginSession, token)
+			}
+		// second step of a two factor login
+		case "mfa":
+			token, ok := loginSession.Values["mfa_token"].(string)
+			if loginSession.Values["auth"] != "mfa" || !ok {
+				break
+			}
+			body, err := mfaLoginBody(token, json)
+			if err != nil {
 				breakx.Point()
-				return loginSession, true, nil
+				return loginSession, false, err
 			}
+			resp, status, err := authenticationControl(r.Context(), "/mfa/login", body)
+			if err != nil {
+				breakx.Point()
+				return loginSession, false, err
+			}
+			if status == statusOK {
+				delete(loginSession.Values, "mfa_token")
+				return grantSession(w, r, loginSession, resp)
+			}
+			// wrong code, pending login stays until it expires in auth service.
+			return loginSession, false, nil
 		}
 	}
 	loginSession.Values["auth"] = "false"
@@ -164,21 +361,81 @@ func cookieHandle(w http.ResponseWriter, r *http.Request) (*sessions.Session, bo
 	return loginSession, false, nil
 }
 
-func authenticationControl(json []byte) ([]byte, bool, error) {
-	resp, err := http.Post("http://localhost:"+envMainMap["auth_service_port"], "application/json", bytes.NewBuffer(json))
+// grantSession copies session fields of the user record to the session and marks it authenticated.
+func grantSession(w http.ResponseWriter, r *http.Request, loginSession *sessions.Session, resp []byte) (*sessions.Session, bool, error) {
+	respMap, err := jin.GetMap(resp, "data")
 	if err != nil {
-		return nil, false, err
+		breakx.Point()
+		return loginSession, false, err
 	}
-	defer resp.Body.Close()
-	json, err = ioutil.ReadAll(resp.Body)
+	for _, k := range sessionFields {
+		if v, ok := respMap[k]; ok {
+			loginSession.Values[k] = v
+		}
+	}
+	loginSession.Values["auth"] = "true"
+	err = loginSession.Save(r, w)
 	if err != nil {
-		return nil, false, err
+		breakx.Point()
+		return loginSession, false, err
+	}
+	return loginSession, true, nil
+}
+
+// sessionUser returns the user of the cookie session for access logs.
+func sessionUser(r *http.Request) string {
+	loginSession, err := store.Get(r, "login")
+	if err != nil {
+		return ""
+	}
+	userID, _ := loginSession.Values["user_id"].(string)
+	return userID
+}
+
+// pendingSession marks the session as waiting for a second factor.
+// 'auth' is not "true" until the 'mfa' action succeeds.
+func pendingSession(w http.ResponseWriter, r *http.Request, loginSession *sessions.Session, token string) (*sessions.Session, bool, error) {
+	loginSession.Values["auth"] = "mfa"
+	loginSession.Values["mfa_token"] = token
+	err := loginSession.Save(r, w)
+	if err != nil {
+		breakx.Point()
+		return loginSession, false, err
+	}
+	return loginSession, false, nil
+}
+
+// authenticationControl posts the json to auth service path
+// and returns the response with its status field.
+// rejected requests are not errors, auth service explains them in the body.
+func authenticationControl(ctx context.Context, path string, json []byte) ([]byte, string, error) {
+	json, err := upstreams.Post(ctx, "auth_service", path, json)
+	var rejected *upstream.RejectedError
+	if errors.As(err, &rejected) {
+		json, err = rejected.Body, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	status, err := jin.GetString(json, "status")
+	if err != nil {
+		return nil, "", err
+	}
+	return json, status, nil
+}
+
+// resolvePath resolves paths relative to the service directory.
+func resolvePath(path string) string {
+	path = config.Path(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(penman.GetCurrentDir(), path)
 	}
-	return json, true, nil
+	return path
 }
 
+// failHandle writes the error envelope,
+// status is used only when err is not an *apierr.Error.
 func failHandle(w http.ResponseWriter, err error, status int) {
-	log.Println(err)
-	w.WriteHeader(status)
-	w.Write([]byte(err.Error()))
+	e := apierr.Fail(w, err, status)
+	log.Println(reqFailed, e.Status, e, e.Fields())
 }
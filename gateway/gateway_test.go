@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGrantSession(t *testing.T) {
+	contractMux(t)
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	rec := httptest.NewRecorder()
+	session, err := store.Get(req, "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := []byte(`{"status":"OK","data":{"user_id":"user-1","type":"admin","email":"ada@example.com","password":"secret","auth":"x"},"error":null}`)
+	_, auth, err := grantSession(rec, req, session, resp)
+	if err != nil || !auth {
+		t.Fatalf("got %v %v, want a granted session", auth, err)
+	}
+
+	// the saved cookie has only session fields
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	saved, err := store.Get(req, "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[interface{}]interface{}{"user_id": "user-1", "type": "admin", "email": "ada@example.com", "auth": "true"}
+	if len(saved.Values) != len(want) {
+		t.Fatalf("got session %v, want %v", saved.Values, want)
+	}
+	for k, v := range want {
+		if saved.Values[k] != v {
+			t.Fatalf("got session %v, want %v", saved.Values, want)
+		}
+	}
+}
+
+func TestMFALoginBody(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+		ok   bool
+	}{
+		{`{"action":"mfa","code":"123456"}`, `{"mfa_token":"token-1","code":"123456"}`, true},
+		{`{"action":"mfa","code":123456}`, `{"mfa_token":"token-1","code":123456}`, true},
+		{`{"action":"mfa","recovery_code":"abcde-fghjk"}`, `{"mfa_token":"token-1","recovery_code":"abcde-fghjk"}`, true},
+		// user input can not add or replace fields
+		{`{"action":"mfa","mfa_token":"other","code":"1\",\"mfa_token\":\"other"}`, `{"mfa_token":"token-1","code":"1\",\"mfa_token\":\"other"}`, true},
+		{`{"action":"mfa","code":`, ``, false},
+	}
+	for _, tt := range tests {
+		got, err := mfaLoginBody("token-1", []byte(tt.body))
+		if (err == nil) != tt.ok || string(got) != tt.want {
+			t.Fatalf("mfaLoginBody(%s) = %s %v, want %s", tt.body, got, err, tt.want)
+		}
+	}
+}
+
+func TestSameSiteMode(t *testing.T) {
+	tests := []struct {
+		value string
+		want  http.SameSite
+		ok    bool
+	}{
+		{"strict", http.SameSiteStrictMode, true},
+		{"Lax", http.SameSiteLaxMode, true},
+		{" none ", http.SameSiteNoneMode, true},
+		{"", http.SameSiteDefaultMode, false},
+		{"relaxed", http.SameSiteDefaultMode, false},
+	}
+	for _, tt := range tests {
+		got, err := sameSiteMode(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Fatalf("sameSiteMode(%q) = %v %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestGraphQLBodyLimit(t *testing.T) {
+	mux := contractMux(t)
+	body := `{"query":"` + strings.Repeat(" ", int(gqlMaxBody)) + `{ a }"}`
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.AddCookie(loginCookie(t))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got status %d, want 413", rec.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"ecomm/internal/apierr"
+	"ecomm/internal/upstream"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	// data service reads the user type from this header for field rules,
+	// scopes are sent only for api keys, mutations need 'data:write'.
+	headerUserType  string = "X-User-Type"
+	headerUserID    string = "X-User-ID"
+	headerKeyScopes string = "X-Key-Scopes"
+
+	// api key scope of graphql requests
+	scopeRead string = "data:read"
+
+	// request body limit in bytes, data service has the same limit
+	gqlMaxBody int64 = 1 << 20
+)
+
+// graphqlHandle forwards graphql requests of logged in users to data service.
+// cookie sessions and api keys are accepted, api keys need 'data:read'
+// and are checked by apiKeyHandler.
+func graphqlHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	session := keySession(r)
+	if session == nil {
+		var err error
+		session, err = store.Get(r, "login")
+		if err != nil {
+			failHandle(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	userID, _ := session.Values["user_id"].(string)
+	userType, _ := session.Values["type"].(string)
+	if session.Values["auth"] != "true" || userID == "" {
+		failHandle(w, notAuthorized, http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, gqlMaxBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		failHandle(w, apierr.TooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	ctx := upstream.Header(r.Context(), headerUserID, userID)
+	ctx = upstream.Header(ctx, headerUserType, userType)
+	if _, ok := session.Values["key_prefix"]; ok {
+		scopes, _ := session.Values["scopes"].(string)
+		ctx = upstream.Header(ctx, headerKeyScopes, scopes)
+	}
+	// data service envelope is sent as it is, with its status code
+	status := http.StatusOK
+	resp, err := upstreams.Post(ctx, "data_service", "/graphql", body)
+	var rejected *upstream.RejectedError
+	if errors.As(err, &rejected) {
+		status, resp, err = rejected.Status, rejected.Body, nil
+	}
+	if err != nil {
+		failHandle(w, apierr.BadGateway.Wrap(err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
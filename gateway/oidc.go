@@ -0,0 +1,104 @@
+package main
+
+import (
+	"jin"
+	"net/http"
+)
+
+var (
+	// json format schemes
+	oidcStartScheme    *jin.Scheme = jin.MakeScheme("provider")
+	oidcCallbackScheme *jin.Scheme = jin.MakeScheme("state", "code")
+)
+
+// oidcStartHandle redirects the browser to the identity provider.
+// state is kept in the session, callback is accepted only from the same browser.
+// GET /oidc/start?provider=example
+func oidcStartHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	loginSession, err := store.Get(r, "login")
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	provider := r.URL.Query().Get("provider")
+	resp, status, err := authenticationControl(r.Context(), "/oidc/start", oidcStartScheme.MakeJson(provider))
+	if err != nil {
+		failHandle(w, err, http.StatusBadGateway)
+		return
+	}
+	if status != statusOK {
+		failHandle(w, oidcFailed, http.StatusBadRequest)
+		return
+	}
+	authURL, err := jin.GetString(resp, "data", "url")
+	if err != nil {
+		failHandle(w, err, http.StatusBadGateway)
+		return
+	}
+	state, err := jin.GetString(resp, "data", "state")
+	if err != nil {
+		failHandle(w, err, http.StatusBadGateway)
+		return
+	}
+	loginSession.Values["oidc_state"] = state
+	err = loginSession.Save(r, w)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallbackHandle completes the login and redirects the browser to the web site.
+// GET /oidc/callback?state=...&code=...
+func oidcCallbackHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	loginSession, err := store.Get(r, "login")
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	state := query.Get("state")
+	expected, ok := loginSession.Values["oidc_state"].(string)
+	if !ok || state == "" || state != expected {
+		failHandle(w, oidcFailed, http.StatusBadRequest)
+		return
+	}
+	delete(loginSession.Values, "oidc_state")
+	// provider reported an error like 'access_denied'
+	if query.Get("error") != "" {
+		failHandle(w, oidcFailed, http.StatusUnauthorized)
+		return
+	}
+	resp, status, err := authenticationControl(r.Context(), "/oidc/callback", oidcCallbackScheme.MakeJson(state, query.Get("code")))
+	if err != nil {
+		failHandle(w, err, http.StatusBadGateway)
+		return
+	}
+	switch status {
+	case statusOK:
+		_, _, err = grantSession(w, r, loginSession, resp)
+	case statusMFA:
+		var token string
+		token, err = jin.GetString(resp, "data", "mfa_token")
+		if err == nil {
+			_, _, err = pendingSession(w, r, loginSession, token)
+		}
+	default:
+		failHandle(w, oidcFailed, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, conf.WebURL, http.StatusFound)
+}
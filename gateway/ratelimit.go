@@ -0,0 +1,44 @@
+package main
+
+import (
+	"ecomm/internal/ratelimit"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// requestIdentity returns the rate limit identities of a request before authentication.
+// api key requests are counted only by ip here, the key is not verified yet,
+// so its prefix could belong to someone else. see keyIdentity.
+func requestIdentity(r *http.Request) map[string]string {
+	identities := make(map[string]string)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	identities[ratelimit.ByIP] = host
+	if strings.HasPrefix(r.Header.Get("Authorization"), bearerPrefix) {
+		return identities
+	}
+	loginSession, err := store.Get(r, "login")
+	if err != nil {
+		return identities
+	}
+	if userID, ok := loginSession.Values["user_id"].(string); ok && loginSession.Values["auth"] == "true" {
+		identities[ratelimit.ByUser] = userID
+	}
+	return identities
+}
+
+// keyIdentity returns the key prefix of a request verified by apiKeyHandler.
+func keyIdentity(r *http.Request) map[string]string {
+	identities := make(map[string]string)
+	session := keySession(r)
+	if session == nil {
+		return identities
+	}
+	if prefix, ok := session.Values["key_prefix"].(string); ok {
+		identities[ratelimit.ByKey] = prefix
+	}
+	return identities
+}
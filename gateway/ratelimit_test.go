@@ -0,0 +1,83 @@
+package main
+
+import (
+	"ecomm/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitKeys(t *testing.T) {
+	mux := contractMux(t)
+	env := map[string]string{
+		"routes":        "default",
+		"default_path":  "/",
+		"default_rate":  "0.01",
+		"default_burst": "1",
+		"default_by":    "key",
+	}
+	var err error
+	limiter, err = ratelimit.New(env, ratelimit.NewMemoryStore(time.Hour), requestIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := limiter.Handler(apiKeyHandler(limiter.With(keyIdentity).Handler(mux)))
+	principal := `{"user_id":"user-1","type":"standart","email":"ada@example.com","scopes":["data:read"],"key_prefix":"abcd"}`
+	// requests in order, all of them send the prefix 'abcd'
+	tests := []struct {
+		name   string
+		verify string
+		status int
+	}{
+		// rejected keys do not drain the bucket of the real key
+		{"fake key", "null", http.StatusUnauthorized},
+		{"fake key again", "null", http.StatusUnauthorized},
+		{"verified key", principal, http.StatusOK},
+		{"verified key over limit", principal, http.StatusTooManyRequests},
+	}
+	defer delete(contractData, "/apikey/verify")
+	for _, tt := range tests {
+		contractData["/apikey/verify"] = tt.verify
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ test_users { user_id } }"}`))
+		req.Header.Set("Authorization", bearerPrefix+"ecomm_abcd_secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: got status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body.String())
+		}
+	}
+}
+
+func TestRequestIdentity(t *testing.T) {
+	contractMux(t)
+	tests := []struct {
+		name   string
+		header string
+		cookie bool
+		want   map[string]string
+	}{
+		{"anonymous", "", false, map[string]string{ratelimit.ByIP: "192.0.2.1"}},
+		{"cookie session", "", true, map[string]string{ratelimit.ByIP: "192.0.2.1", ratelimit.ByUser: "user-1"}},
+		{"unverified key", bearerPrefix + "ecomm_abcd_secret", true, map[string]string{ratelimit.ByIP: "192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		if tt.cookie {
+			req.AddCookie(loginCookie(t))
+		}
+		got := requestIdentity(req)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Fatalf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"ecomm/internal/health"
+	"ecomm/internal/mtls"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// log strings
+	registryStart string = ">> Service Registry Started. port:"
+	registryEnd   string = ">> Service Registry Shutdown Unexpectedly. Error:"
+)
+
+// registryListen serves service heartbeats on an internal listener.
+// only the peers in 'registry_peers' can register.
+func registryListen(port string) {
+	tlsConfig, err := mtls.ServerConfig(resolvePath(conf.CertDir), "gateway", conf.RegistryPeers)
+	if err != nil {
+		log.Println(registryEnd, err)
+		return
+	}
+	log.Println(registryStart, port)
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           services.Handler(),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	err = server.ListenAndServeTLS("", "")
+	log.Println(registryEnd, err)
+}
+
+// upstreamCheck reports whether the service answers its liveness endpoint.
+func upstreamCheck(name string) health.Check {
+	return func(ctx context.Context) error {
+		_, err := upstreams.Get(ctx, name, "/healthz")
+		return err
+	}
+}
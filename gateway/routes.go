@@ -0,0 +1,189 @@
+package main
+
+import (
+	"ecomm/internal/apierr"
+	"ecomm/internal/openapi"
+	"ecomm/internal/upstream"
+	"net/http"
+)
+
+var (
+	// openapi document of the public api, served at '/openapi.json'
+	apiDoc *openapi.Document = openapi.New("Gateway", "1.0.0")
+
+	// internal services that serve their own documents
+	docServices map[string]bool = map[string]bool{
+		"auth_service": true,
+		"data_service": true,
+	}
+
+	// api key scopes of routes, routes without a scope reject api keys
+	keyScopes map[string]string = map[string]string{
+		"/graphql": scopeRead,
+	}
+)
+
+// routes returns public routes of the gateway.
+func routes() []openapi.Route {
+	session := []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusBadGateway}
+	op := func(method, path, summary string, body, data *openapi.Schema, errors ...int) openapi.Operation {
+		return openapi.Operation{
+			Method:  method,
+			Path:    path,
+			Summary: summary,
+			Tags:    []string{"gateway"},
+			Body:    body,
+			Data:    data,
+			Errors:  append(errors, http.StatusMethodNotAllowed, http.StatusInternalServerError),
+		}
+	}
+	route := func(handler http.HandlerFunc, ops ...openapi.Operation) openapi.Route {
+		return openapi.Route{Pattern: ops[0].Path, Handler: handler, Operations: ops}
+	}
+	code := openapi.String("totp code, or 'recovery_code'")
+	recovery := openapi.String("one time recovery code, or 'code'")
+	forwarded := func(path, summary string, body *openapi.Schema) openapi.Route {
+		return route(userHandle(path), op(http.MethodPost, path,
+			summary+" Body and response are the ones of auth service without 'user_id'.", body, openapi.Map(nil), session...))
+	}
+	return []openapi.Route{
+		route(csrfTokenHandle, op(http.MethodGet, "/csrf", "Returns the csrf token of the session for 'X-CSRF-Token' header.",
+			nil, openapi.Object(map[string]*openapi.Schema{"*csrf_token": openapi.String("")}), http.StatusForbidden)),
+		route(loginHandle, op(http.MethodPost, "/login", "Logs in with a password, then with a second factor when mfa is enabled.",
+			openapi.Object(map[string]*openapi.Schema{
+				"*action":       openapi.Enum("'login' with credentials, 'mfa' with a second factor", "login", "mfa"),
+				"login":         openapi.String("email or username"),
+				"password":      openapi.String(""),
+				"code":          code,
+				"recovery_code": recovery,
+			}), openapi.Object(map[string]*openapi.Schema{
+				"*auth": openapi.Enum("'mfa' with status 'MFA Required'", "true", "mfa"),
+			}), http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests)),
+		route(signupHandle, op(http.MethodPost, "/signup", "Creates a user with a password, the user logs in afterwards.",
+			openapi.Object(map[string]*openapi.Schema{
+				"*username":  openapi.String("5 to 32 letters, digits, '_', '.' or '-'"),
+				"*email":     openapi.String(""),
+				"*password":  openapi.String("8 to 64 characters"),
+				"first_name": openapi.String(""),
+				"last_name":  openapi.String(""),
+			}), openapi.Object(map[string]*openapi.Schema{
+				"*user_id": openapi.String(""),
+				"*type":    openapi.String(""),
+				"*email":   openapi.String(""),
+			}), http.StatusBadRequest, http.StatusForbidden, http.StatusConflict, http.StatusTooManyRequests, http.StatusBadGateway)),
+		route(logoutHandle, op(http.MethodPost, "/logout", "Reserved, answers 501 until logout is implemented.", nil, nil, http.StatusForbidden, http.StatusNotImplemented)),
+		forwarded("/mfa/enroll", "Starts mfa enrollment.", nil),
+		forwarded("/mfa/confirm", "Enables mfa.", openapi.Object(map[string]*openapi.Schema{"*code": code})),
+		forwarded("/mfa/disable", "Disables mfa.", openapi.Object(map[string]*openapi.Schema{"code": code, "recovery_code": recovery})),
+		route(oidcStartHandle, openapi.Operation{
+			Method:  http.MethodGet,
+			Path:    "/oidc/start",
+			Summary: "Redirects the browser to the identity provider.",
+			Tags:    []string{"gateway"},
+			Params:  []openapi.Param{{Name: "provider", In: "query", Required: true, Schema: openapi.String("")}},
+			Status:  http.StatusFound,
+			Errors:  []int{http.StatusBadRequest, http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusInternalServerError, http.StatusBadGateway},
+		}),
+		route(oidcCallbackHandle, openapi.Operation{
+			Method:  http.MethodGet,
+			Path:    "/oidc/callback",
+			Summary: "Completes the external login and redirects the browser to the web site.",
+			Tags:    []string{"gateway"},
+			Params: []openapi.Param{
+				{Name: "state", In: "query", Required: true, Schema: openapi.String("")},
+				{Name: "code", In: "query", Schema: openapi.String("")},
+				{Name: "error", In: "query", Schema: openapi.String("error of the provider")},
+			},
+			Status: http.StatusFound,
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusInternalServerError, http.StatusBadGateway},
+		}),
+		route(catalogHandle, op(http.MethodGet, "/catalog", "Lists the products on sale, no login is needed.", nil,
+			openapi.Array(openapi.Object(map[string]*openapi.Schema{
+				"*product_id":  openapi.String(""),
+				"*name":        openapi.String(""),
+				"*description": openapi.String(""),
+				"*price_cents": openapi.Integer("price in cents"),
+			})), http.StatusTooManyRequests, http.StatusBadGateway)),
+		forwarded("/profile", "Returns the profile.", nil),
+		forwarded("/profile/update", "Updates self-service profile fields.", openapi.Object(map[string]*openapi.Schema{
+			"first_name": openapi.String(""),
+			"last_name":  openapi.String(""),
+			"gender":     openapi.Enum("", "female", "male", "other"),
+			"country":    openapi.String(""),
+			"city":       openapi.String(""),
+			"birth_date": openapi.String("date like 1990-12-31"),
+		})),
+		forwarded("/password/change", "Replaces the password.", openapi.Object(map[string]*openapi.Schema{
+			"*current_password": openapi.String(""),
+			"*new_password":     openapi.String(""),
+		})),
+		forwarded("/email/change", "Sends a verification link to the new address.", openapi.Object(map[string]*openapi.Schema{
+			"*email":    openapi.String(""),
+			"*password": openapi.String("current password"),
+		})),
+		route(emailVerifyHandle, op(http.MethodPost, "/email/verify", "Completes an email change, no login is needed.",
+			openapi.Object(map[string]*openapi.Schema{"*token": openapi.String("token of the verification link")}),
+			openapi.Object(map[string]*openapi.Schema{"*email": openapi.String("")}),
+			http.StatusBadRequest, http.StatusForbidden, http.StatusConflict, http.StatusTooManyRequests, http.StatusBadGateway)),
+		forwarded("/address/list", "Lists addresses.", nil),
+		forwarded("/address/create", "Adds an address.", openapi.Map(nil)),
+		forwarded("/address/update", "Changes columns of an address.", openapi.Map(nil)),
+		forwarded("/address/delete", "Removes an address.", openapi.Object(map[string]*openapi.Schema{"*address_id": openapi.String("")})),
+		forwarded("/address/snapshot", "Returns a copy of an address for orders.", openapi.Object(map[string]*openapi.Schema{"*address_id": openapi.String("")})),
+		forwarded("/order/create", "Places an order with copies of the addresses and prices.", openapi.Object(map[string]*openapi.Schema{
+			"*shipping_address_id": openapi.String(""),
+			"*billing_address_id":  openapi.String(""),
+			"*items": openapi.Array(openapi.Object(map[string]*openapi.Schema{
+				"*product_id": openapi.String(""),
+				"*quantity":   openapi.Integer(""),
+			})),
+		})),
+		forwarded("/apikey/create", "Creates an api key.", openapi.Object(map[string]*openapi.Schema{
+			"*name":        openapi.String(""),
+			"*scopes":      openapi.Array(openapi.String("")),
+			"expires_days": openapi.Integer(""),
+		})),
+		forwarded("/apikey/list", "Lists api keys.", nil),
+		forwarded("/apikey/revoke", "Revokes an api key.", openapi.Object(map[string]*openapi.Schema{"*prefix": openapi.String("")})),
+		route(graphqlHandle, op(http.MethodPost, "/graphql", "Runs graphql on data service, see '/openapi.json?service=data_service'.",
+			openapi.Object(map[string]*openapi.Schema{
+				"*query":        openapi.String(""),
+				"operationName": openapi.String(""),
+				"variables":     openapi.Map(nil),
+			}), openapi.Map(nil), append(session, http.StatusBadRequest, http.StatusRequestEntityTooLarge)...)),
+		route(openAPIHandle, openapi.Operation{
+			Method:  http.MethodGet,
+			Path:    "/openapi.json",
+			Summary: "Returns this document, or the document of an internal service.",
+			Tags:    []string{"gateway"},
+			Params:  []openapi.Param{{Name: "service", In: "query", Schema: openapi.Enum("", "auth_service", "data_service")}},
+			Raw:     openapi.Map(nil),
+			Errors:  []int{http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusBadGateway},
+		}),
+	}
+}
+
+// openAPIHandle serves the gateway document,
+// '?service=' serves documents of internal services.
+func openAPIHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	service := r.URL.Query().Get("service")
+	if service == "" {
+		apiDoc.ServeHTTP(w, r)
+		return
+	}
+	if !docServices[service] {
+		failHandle(w, apierr.NotFound.Detail("service", "unknown service"), http.StatusNotFound)
+		return
+	}
+	doc, err := upstreams.Get(upstream.Idempotent(r.Context()), service, "/openapi.json")
+	if err != nil {
+		failHandle(w, apierr.BadGateway.Wrap(err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"ecomm/internal/apierr"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// signupFields are the fields a signup can set,
+// auth service validates them and stores only the password hash.
+type signupFields struct {
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+}
+
+// signupHandle creates a user with auth service, the user logs in afterwards.
+// POST /signup
+func signupHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		failHandle(w, statError, http.StatusMethodNotAllowed)
+		return
+	}
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	var fields signupFields
+	err = json.Unmarshal(raw, &fields)
+	if err != nil {
+		failHandle(w, apierr.InvalidJSON.Detail("body", "must be a json object"), http.StatusBadRequest)
+		return
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		failHandle(w, err, http.StatusInternalServerError)
+		return
+	}
+	forward(r.Context(), w, "auth_service", "/signup", body)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// log strings
+	certReloaded  string = ">> TLS Certificate Reloaded:"
+	certReloadErr string = ">> TLS Certificate Reload Failed, Old Certificate Kept. Error:"
+	redirectStart string = ">> HTTP Redirect Listener Started. port:"
+	redirectEnd   string = ">> HTTP Redirect Listener Shutdown Unexpectedly. Error:"
+)
+
+// certReloader serves the public certificate and replaces it
+// when the certificate or the key file changes on disk.
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// newCertReloader loads the certificate and starts watching the files.
+func newCertReloader(certPath, keyPath string, interval time.Duration) (*certReloader, error) {
+	reloader := &certReloader{certPath: certPath, keyPath: keyPath}
+	err := reloader.load()
+	if err != nil {
+		return nil, err
+	}
+	go reloader.watch(interval)
+	return reloader, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// watch polls modification times of the files.
+// a broken pair (like a half copied key) is ignored until it is fixed.
+func (c *certReloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		certMod, keyMod, err := c.modTimes()
+		if err != nil {
+			log.Println(certReloadErr, err)
+			continue
+		}
+		c.mu.RLock()
+		changed := !certMod.Equal(c.certMod) || !keyMod.Equal(c.keyMod)
+		c.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		err = c.load()
+		if err != nil {
+			log.Println(certReloadErr, err)
+			continue
+		}
+		log.Println(certReloaded, c.certPath)
+	}
+}
+
+func (c *certReloader) load() error {
+	certMod, keyMod, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.certMod = certMod
+	c.keyMod = keyMod
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(c.certPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(c.keyPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// hstsHandler tells browsers to use only https for this host.
+func hstsHandler(maxAge int, next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(maxAge) + "; includeSubDomains"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectListen serves plain http and redirects every request to https.
+func redirectListen(port, httpsPort string) {
+	log.Println(redirectStart, port)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	err := server.ListenAndServe()
+	log.Println(redirectEnd, err)
+}
+
+// sameSiteMode converts the environment value to a cookie mode.
+// unknown values are configuration errors.
+func sameSiteMode(value string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	}
+	return http.SameSiteDefaultMode, badSameSite
+}
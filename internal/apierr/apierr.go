@@ -0,0 +1,145 @@
+// Package apierr is the error model and the response envelope of every service.
+//
+//	{
+//	    "status": "OK" | "MFA Required" | "Failed",
+//	    "data": ...,
+//	    "error": {"code": "missing_field", "message": "...", "details": {"email": "required"}},
+//	    "request_id": "..."
+//	}
+//
+// codes are stable and meant for programs, messages are meant for people.
+// causes of errors are logged but never sent to clients.
+package apierr
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Error is a client facing error.
+type Error struct {
+	Code    string
+	Message string
+	Status  int
+	Details map[string]string
+	cause   error
+}
+
+// common errors, services declare their own with New.
+var (
+	BadRequest       *Error = New("bad_request", http.StatusBadRequest, "Request is not valid")
+	InvalidJSON      *Error = New("invalid_json", http.StatusBadRequest, "Request body is not valid json")
+	MissingField     *Error = New("missing_field", http.StatusBadRequest, "Necessary field is empty")
+	InvalidField     *Error = New("invalid_field", http.StatusBadRequest, "Field value is not valid")
+	Unauthorized     *Error = New("unauthorized", http.StatusUnauthorized, "Login required")
+	Forbidden        *Error = New("forbidden", http.StatusForbidden, "Not allowed")
+	NotFound         *Error = New("not_found", http.StatusNotFound, "Record does not exist")
+	MethodNotAllowed *Error = New("method_not_allowed", http.StatusMethodNotAllowed, "Method not allowed")
+	Conflict         *Error = New("conflict", http.StatusConflict, "Record conflicts with an existing one")
+	TooLarge         *Error = New("too_large", http.StatusRequestEntityTooLarge, "Request body is too large")
+	TooManyRequests  *Error = New("too_many_requests", http.StatusTooManyRequests, "Too many requests")
+	Internal         *Error = New("internal", http.StatusInternalServerError, "Internal error")
+	BadGateway       *Error = New("bad_gateway", http.StatusBadGateway, "Upstream service failed")
+	Unavailable      *Error = New("unavailable", http.StatusServiceUnavailable, "Service unavailable")
+)
+
+// New creates an error, declared once in a var block.
+func New(code string, status int, message string) *Error {
+	return &Error{Code: code, Message: message, Status: status}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Code + ": " + e.Message + ": " + e.cause.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Unwrap returns the cause.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is matches errors by code, wrapped copies match their declaration.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy with a cause, declared errors are never changed.
+func (e *Error) Wrap(cause error) *Error {
+	c := e.copy()
+	c.cause = cause
+	return c
+}
+
+// Detail returns a copy with a field level detail.
+func (e *Error) Detail(field, problem string) *Error {
+	c := e.copy()
+	c.Details[field] = problem
+	return c
+}
+
+func (e *Error) copy() *Error {
+	c := *e
+	c.Details = make(map[string]string, len(e.Details)+1)
+	for k, v := range e.Details {
+		c.Details[k] = v
+	}
+	return &c
+}
+
+// From converts any error to an *Error.
+// untyped errors get the common error of the status, so internal
+// messages like sql errors never reach clients.
+func From(err error, status int) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ForStatus(status).Wrap(err)
+}
+
+// ForStatus returns the common error of a status.
+func ForStatus(status int) *Error {
+	switch status {
+	case http.StatusBadRequest:
+		return BadRequest
+	case http.StatusUnauthorized:
+		return Unauthorized
+	case http.StatusForbidden:
+		return Forbidden
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusMethodNotAllowed:
+		return MethodNotAllowed
+	case http.StatusConflict:
+		return Conflict
+	case http.StatusTooManyRequests:
+		return TooManyRequests
+	case http.StatusBadGateway:
+		return BadGateway
+	case http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return Unavailable
+	}
+	if status >= 400 && status < 500 {
+		return BadRequest
+	}
+	return Internal
+}
+
+// Fields lists details as 'field: problem' pairs in order, for logs.
+func (e *Error) Fields() string {
+	keys := make([]string, 0, len(e.Details))
+	for k := range e.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + ": " + e.Details[k]
+	}
+	return strings.Join(pairs, ", ")
+}
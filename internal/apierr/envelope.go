@@ -0,0 +1,78 @@
+package apierr
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	// envelope status strings
+	StatusOK     string = "OK"
+	StatusMFA    string = "MFA Required"
+	StatusFailed string = "Failed"
+
+	// same header with trace.HeaderRequestID, written by the trace handler
+	headerRequestID string = "X-Request-ID"
+)
+
+// Envelope is the body of every response.
+type Envelope struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	Error     *Body           `json:"error"`
+	RequestID string          `json:"request_id,omitempty"`
+}
+
+// Body is the client facing part of an error.
+type Body struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Done writes data with status 'OK', data must be json, nil means null.
+func Done(w http.ResponseWriter, data []byte) {
+	Write(w, http.StatusOK, StatusOK, data)
+}
+
+// Write writes data with a custom status string like 'MFA Required'.
+func Write(w http.ResponseWriter, code int, status string, data []byte) {
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+	send(w, code, Envelope{Status: status, Data: data, RequestID: w.Header().Get(headerRequestID)})
+}
+
+// Fail writes an error, status is used only for untyped errors.
+// the written error is returned for logging.
+func Fail(w http.ResponseWriter, err error, status int) *Error {
+	e := From(err, status)
+	send(w, e.Status, Envelope{
+		Status:    StatusFailed,
+		Data:      []byte("null"),
+		Error:     &Body{Code: e.Code, Message: e.Message, Details: e.Details},
+		RequestID: w.Header().Get(headerRequestID),
+	})
+	return e
+}
+
+// Decode reads an envelope, for services that call each other.
+func Decode(body []byte) (*Envelope, error) {
+	env := &Envelope{}
+	err := json.Unmarshal(body, env)
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func send(w http.ResponseWriter, code int, env Envelope) {
+	body, err := json.Marshal(env)
+	if err != nil {
+		code = http.StatusInternalServerError
+		body = []byte(`{"status":"Failed","data":null,"error":{"code":"internal","message":"Internal error"}}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
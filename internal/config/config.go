@@ -0,0 +1,276 @@
+// Package config loads typed service configuration.
+//
+// values are read from environment files in 'key = value' format,
+// later files override earlier ones and process environment overrides
+// all files. environment variable of a key is 'ECOMM_' + upper case key,
+// 'auth_service_port' is overridden with 'ECOMM_AUTH_SERVICE_PORT'.
+//
+// struct fields are filled by tags:
+//
+//	Port    string        `env:"auth_service_port" required:"true"`
+//	Reload  time.Duration `env:"tls_reload" default:"30s"`
+//	Secret  string        `env:"secret" required:"true" secret:"true"`
+//
+// supported field types are string, bool, int, float64,
+// time.Duration and []string (comma separated).
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"penman"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// process environment prefix
+	envPrefix string = "ECOMM_"
+	// redacted secret values
+	redacted string = "******"
+	// 'curr' keyword is a wild card for 'currentDirectory'
+	currDir string = "curr/"
+)
+
+var (
+	// errors
+	ErrNotPointer error = errors.New("config: target must be a pointer to a struct")
+)
+
+// Source is an environment file.
+type Source struct {
+	// file path, may start with 'curr/'
+	Path string
+	// prefix added to every key of the file, keeps files apart
+	// like 'db_' for database files.
+	Prefix string
+	// raw files are not parsed, whole content is the value of Raw key.
+	Raw string
+	// missing optional files are skipped
+	Optional bool
+}
+
+// Error lists every missing or invalid key.
+type Error struct {
+	Missing []string
+	Invalid []string
+	Files   []string
+}
+
+func (e *Error) Error() string {
+	parts := make([]string, 0, 3)
+	if len(e.Files) > 0 {
+		parts = append(parts, "missing files: "+strings.Join(e.Files, ", "))
+	}
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing keys: "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		parts = append(parts, "invalid values: "+strings.Join(e.Invalid, ", "))
+	}
+	return "config: " + strings.Join(parts, "; ")
+}
+
+// Map reads sources into a single map, process environment included.
+func Map(sources ...Source) (map[string]string, error) {
+	values := make(map[string]string)
+	fail := &Error{}
+	for _, source := range sources {
+		path := Path(source.Path)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			if !source.Optional {
+				fail.Files = append(fail.Files, path)
+			}
+			continue
+		}
+		if source.Raw != "" {
+			values[source.Prefix+source.Raw] = strings.TrimSpace(string(content))
+			continue
+		}
+		scanner := bufio.NewScanner(strings.NewReader(string(content)))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			i := strings.Index(line, "=")
+			if i < 0 {
+				fail.Invalid = append(fail.Invalid, path+": "+line)
+				continue
+			}
+			key := strings.TrimSpace(line[:i])
+			values[source.Prefix+key] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	// process environment wins
+	for _, pair := range os.Environ() {
+		if !strings.HasPrefix(pair, envPrefix) {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		name := strings.ToLower(pair[len(envPrefix):i])
+		values[name] = pair[i+1:]
+	}
+	if len(fail.Files) > 0 || len(fail.Invalid) > 0 {
+		return values, fail
+	}
+	return values, nil
+}
+
+// Load reads sources and fills the struct pointed by v.
+// all problems are collected and returned in a single *Error.
+func Load(v interface{}, sources ...Source) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Struct {
+		return ErrNotPointer
+	}
+	values, err := Map(sources...)
+	fail, _ := err.(*Error)
+	if err != nil && fail == nil {
+		return err
+	}
+	if fail == nil {
+		fail = &Error{}
+	}
+	// environment keys are case insensitive, files may use camel case.
+	lower := make(map[string]string, len(values))
+	for k, val := range values {
+		lower[strings.ToLower(k)] = val
+	}
+	fill(target.Elem(), values, lower, fail)
+	if len(fail.Files) > 0 || len(fail.Missing) > 0 || len(fail.Invalid) > 0 {
+		return fail
+	}
+	return nil
+}
+
+// Redact returns 'key = value' lines of the struct for logging,
+// values of secret fields are hidden.
+func Redact(v interface{}) string {
+	target := reflect.Indirect(reflect.ValueOf(v))
+	if target.Kind() != reflect.Struct {
+		return ""
+	}
+	lines := redact(target)
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// Path resolves 'curr/' prefix to the current directory.
+func Path(path string) string {
+	if strings.HasPrefix(path, currDir) {
+		return filepath.Join(penman.GetCurrentDir(), path[len(currDir):])
+	}
+	return path
+}
+
+// List splits a comma separated value.
+func List(value string) []string {
+	arr := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			arr = append(arr, v)
+		}
+	}
+	return arr
+}
+
+// fill sets tagged fields, embedded structs are filled recursively.
+func fill(target reflect.Value, values, lower map[string]string, fail *Error) {
+	kind := target.Type()
+	for i := 0; i < kind.NumField(); i++ {
+		field := kind.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fill(target.Field(i), values, lower, fail)
+			continue
+		}
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		value, exists := values[key]
+		if !exists {
+			value, exists = lower[strings.ToLower(key)]
+		}
+		if !exists || value == "" {
+			value = field.Tag.Get("default")
+		}
+		if value == "" {
+			if field.Tag.Get("required") == "true" {
+				fail.Missing = append(fail.Missing, key)
+			}
+			continue
+		}
+		err := set(target.Field(i), value)
+		if err != nil {
+			fail.Invalid = append(fail.Invalid, key)
+		}
+	}
+}
+
+func redact(target reflect.Value) []string {
+	kind := target.Type()
+	lines := make([]string, 0, kind.NumField())
+	for i := 0; i < kind.NumField(); i++ {
+		field := kind.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			lines = append(lines, redact(target.Field(i))...)
+			continue
+		}
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		value := fmt.Sprint(target.Field(i).Interface())
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = redacted
+		}
+		lines = append(lines, key+" = "+value)
+	}
+	return lines
+}
+
+func set(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case []string:
+		field.Set(reflect.ValueOf(List(value)))
+	default:
+		return fmt.Errorf("config: unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Database
+	Port    string        `env:"test_port" required:"true"`
+	Reload  time.Duration `env:"test_reload" default:"30s"`
+	Origins []string      `env:"test_origins"`
+	Debug   bool          `env:"test_debug"`
+	Secret  string        `env:"test_secret" secret:"true"`
+}
+
+// writeFile creates an environment file in a temporary directory.
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		want    testConfig
+		missing []string
+		invalid []string
+	}{
+		{
+			name: "file values and defaults",
+			file: "test_port = 8080\ntest_origins = a, b\n# comment\n\ndb_user = ecomm\ndb_dbname = ecomm\n",
+			want: testConfig{
+				Database: Database{Driver: "postgres", Host: "localhost", User: "ecomm", Name: "ecomm", SSLMode: "require"},
+				Port:     "8080",
+				Reload:   30 * time.Second,
+				Origins:  []string{"a", "b"},
+			},
+		},
+		{
+			name: "environment overrides file",
+			file: "test_port = 8080\ndb_user = ecomm\ndb_dbname = ecomm\n",
+			env:  map[string]string{"ECOMM_TEST_PORT": "9090", "ECOMM_TEST_RELOAD": "1m", "ECOMM_DB_SSLMODE": "disable"},
+			want: testConfig{
+				Database: Database{Driver: "postgres", Host: "localhost", User: "ecomm", Name: "ecomm", SSLMode: "disable"},
+				Port:     "9090",
+				Reload:   time.Minute,
+			},
+		},
+		{
+			name:    "missing keys reported",
+			file:    "db_user = ecomm\n",
+			missing: []string{"db_dbname", "test_port"},
+		},
+		{
+			name:    "invalid values reported",
+			file:    "test_port = 8080\ndb_user = ecomm\ndb_dbname = ecomm\ntest_reload = soon\ntest_debug = maybe\n",
+			invalid: []string{"test_debug", "test_reload"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			path := writeFile(t, ".env", tt.file)
+			got := testConfig{}
+			err := Load(&got, Source{Path: path})
+			if tt.missing == nil && tt.invalid == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			fail, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("got error %v, want *Error", err)
+			}
+			if !sameKeys(fail.Missing, tt.missing) || !sameKeys(fail.Invalid, tt.invalid) {
+				t.Fatalf("got missing %v invalid %v, want %v %v", fail.Missing, fail.Invalid, tt.missing, tt.invalid)
+			}
+		})
+	}
+}
+
+func TestMap(t *testing.T) {
+	dir := t.TempDir()
+	env := filepath.Join(dir, ".env_database")
+	raw := filepath.Join(dir, "secret")
+	bad := filepath.Join(dir, ".env_bad")
+	for path, content := range map[string]string{env: "host = db\nport = 5432\n", raw: "  s3cret\n", bad: "no value\n"} {
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		sources []Source
+		want    map[string]string
+		ok      bool
+	}{
+		{"prefix", []Source{{Path: env, Prefix: "db_"}}, map[string]string{"db_host": "db", "db_port": "5432"}, true},
+		{"raw", []Source{{Path: raw, Raw: "secret"}}, map[string]string{"secret": "s3cret"}, true},
+		{"optional file", []Source{{Path: filepath.Join(dir, "none"), Optional: true}}, map[string]string{}, true},
+		{"missing file", []Source{{Path: filepath.Join(dir, "none")}}, map[string]string{}, false},
+		{"line without value", []Source{{Path: bad}}, map[string]string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Map(tt.sources...)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got error %v, want ok %v", err, tt.ok)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Fatalf("got %s = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		conf testConfig
+		want string
+	}{
+		{
+			name: "secrets hidden",
+			conf: testConfig{Database: Database{User: "ecomm", Password: "pass"}, Port: "8080", Secret: "key"},
+			want: "db_dbname = \ndb_host = \ndb_password = ******\ndb_port = \ndb_sslmode = \ndb_user = ecomm\ndriver = \n" +
+				"test_debug = false\ntest_origins = []\ntest_port = 8080\ntest_reload = 0s\ntest_secret = ******",
+		},
+		{
+			name: "empty secrets shown",
+			conf: testConfig{Port: "8080"},
+			want: "db_dbname = \ndb_host = \ndb_password = \ndb_port = \ndb_sslmode = \ndb_user = \ndriver = \n" +
+				"test_debug = false\ntest_origins = []\ntest_port = 8080\ntest_reload = 0s\ntest_secret = ",
+		},
+	}
+	for _, tt := range tests {
+		got := Redact(&tt.conf)
+		if got != tt.want {
+			t.Fatalf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// sameKeys compares key lists ignoring order.
+func sameKeys(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := make(map[string]bool, len(got))
+	for _, k := range got {
+		seen[k] = true
+	}
+	for _, k := range want {
+		if !seen[k] {
+			return false
+		}
+	}
+	return true
+}
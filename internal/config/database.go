@@ -0,0 +1,39 @@
+package config
+
+import "strings"
+
+// Database is the postgres part of a service configuration,
+// read from '.env_database' files with 'db_' prefix.
+type Database struct {
+	Driver   string `env:"driver" default:"postgres"`
+	Host     string `env:"db_host" default:"localhost"`
+	Port     string `env:"db_port"`
+	User     string `env:"db_user" required:"true"`
+	Password string `env:"db_password" secret:"true"`
+	Name     string `env:"db_dbname" required:"true"`
+	SSLMode  string `env:"db_sslmode" default:"require"`
+}
+
+// DSN returns the lib/pq connection string.
+func (d Database) DSN() string {
+	pairs := []string{
+		"host=" + quote(d.Host),
+		"user=" + quote(d.User),
+		"dbname=" + quote(d.Name),
+		"sslmode=" + quote(d.SSLMode),
+	}
+	if d.Port != "" {
+		pairs = append(pairs, "port="+quote(d.Port))
+	}
+	if d.Password != "" {
+		pairs = append(pairs, "password="+quote(d.Password))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// quote escapes a lib/pq connection string value.
+func quote(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return "'" + value + "'"
+}
@@ -0,0 +1,8 @@
+package config
+
+// Logging is the logger configuration shared by services.
+// redact lists key parts whose values are never logged.
+type Logging struct {
+	LogLevel  string   `env:"log_level" default:"info"`
+	LogRedact []string `env:"log_redact" default:"password,pass,token,secret,code,key,card,cvv,cvc,authorization,cookie"`
+}
@@ -0,0 +1,11 @@
+package config
+
+// Mail is the outgoing mail configuration shared by services.
+// sender is one of log and smtp, log only writes recipients and subjects to the service log.
+type Mail struct {
+	MailSender   string `env:"mail_sender" default:"log"`
+	MailFrom     string `env:"mail_from" default:"no-reply@localhost"`
+	SMTPAddr     string `env:"smtp_addr"`
+	SMTPUser     string `env:"smtp_user"`
+	SMTPPassword string `env:"smtp_password" secret:"true"`
+}
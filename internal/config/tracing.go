@@ -0,0 +1,8 @@
+package config
+
+// Tracing is the span exporter configuration shared by services.
+// exporter is one of none, stdout and otlp.
+type Tracing struct {
+	TraceExporter string `env:"trace_exporter" default:"none"`
+	TraceEndpoint string `env:"trace_endpoint" default:"http://localhost:4318/v1/traces"`
+}
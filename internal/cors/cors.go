@@ -0,0 +1,166 @@
+// Package cors applies cross origin resource sharing policies.
+// policies are read from an environment map, every policy covers
+// a path prefix and the longest matching prefix wins.
+//
+//	routes              = default, login
+//	default_path        = /
+//	default_origins     = http://localhost:8080
+//	default_methods     = GET, POST
+//	default_headers     = Content-Type, X-CSRF-Token
+//	default_credentials = true
+//	default_max_age     = 600
+//	login_path          = /login
+//	login_origins       = http://localhost:8080
+//	login_methods       = POST
+//
+// without any policy no cross origin request is allowed.
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// errors
+	ErrNoPath       error = errors.New("cors: policy path is missing")
+	ErrWildcardCred error = errors.New("cors: wildcard origin can not allow credentials")
+	ErrMaxAge       error = errors.New("cors: max_age must be a non negative integer")
+)
+
+// Policy is the cors policy of a path prefix.
+type Policy struct {
+	Path        string
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      int
+}
+
+// CORS holds the policies of a service.
+type CORS struct {
+	policies []*Policy
+}
+
+// New reads policies from an environment map.
+func New(env map[string]string) (*CORS, error) {
+	c := &CORS{}
+	for _, name := range list(env["routes"]) {
+		policy := &Policy{
+			Path:        env[name+"_path"],
+			Origins:     list(env[name+"_origins"]),
+			Methods:     list(strings.ToUpper(env[name+"_methods"])),
+			Headers:     list(env[name+"_headers"]),
+			Credentials: env[name+"_credentials"] == "true",
+		}
+		if policy.Path == "" {
+			return nil, ErrNoPath
+		}
+		if policy.Credentials && contains(policy.Origins, "*") {
+			return nil, ErrWildcardCred
+		}
+		if age := env[name+"_max_age"]; age != "" {
+			n, err := strconv.Atoi(age)
+			if err != nil || n < 0 {
+				return nil, ErrMaxAge
+			}
+			policy.MaxAge = n
+		}
+		c.policies = append(c.policies, policy)
+	}
+	// longest prefix first
+	sort.SliceStable(c.policies, func(i, j int) bool {
+		return len(c.policies[i].Path) > len(c.policies[j].Path)
+	})
+	return c, nil
+}
+
+// Handler applies the policies before the next handler.
+// preflight requests are answered here and never reach the next handler.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		policy := c.match(r.URL.Path)
+		if policy == nil || !policy.allowOrigin(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			// browser blocks the response, same origin requests never come here.
+			next.ServeHTTP(w, r)
+			return
+		}
+		if contains(policy.Origins, "*") {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if policy.Credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+		if !contains(policy.Methods, method) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		header.Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+		if len(policy.Headers) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+		}
+		if policy.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// match returns the policy with the longest matching path prefix.
+func (c *CORS) match(path string) *Policy {
+	for _, policy := range c.policies {
+		if strings.HasPrefix(path, policy.Path) {
+			return policy
+		}
+	}
+	return nil
+}
+
+func (p *Policy) allowOrigin(origin string) bool {
+	return contains(p.Origins, "*") || contains(p.Origins, strings.TrimRight(origin, "/"))
+}
+
+func list(value string) []string {
+	arr := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			arr = append(arr, v)
+		}
+	}
+	return arr
+}
+
+func contains(arr []string, value string) bool {
+	for _, v := range arr {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
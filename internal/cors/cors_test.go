@@ -0,0 +1,133 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var testEnv map[string]string = map[string]string{
+	"routes":              "default, login, public",
+	"default_path":        "/",
+	"default_origins":     "http://localhost:8080",
+	"default_methods":     "get, post",
+	"default_headers":     "Content-Type, X-CSRF-Token",
+	"default_credentials": "true",
+	"default_max_age":     "600",
+	"login_path":          "/login",
+	"login_origins":       "http://localhost:8080",
+	"login_methods":       "POST",
+	"public_path":         "/public",
+	"public_origins":      "*",
+	"public_methods":      "GET",
+}
+
+func TestOrigin(t *testing.T) {
+	c, err := New(testEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		path        string
+		origin      string
+		allow       string
+		credentials string
+	}{
+		{"allowed origin", "/graphql", "http://localhost:8080", "http://localhost:8080", "true"},
+		{"trailing slash", "/graphql", "http://localhost:8080/", "http://localhost:8080/", "true"},
+		{"denied origin", "/graphql", "http://evil.example.com", "", ""},
+		{"longest prefix", "/login", "http://localhost:8080", "http://localhost:8080", ""},
+		{"wildcard", "/public/a", "http://any.example.com", "*", ""},
+		{"no origin", "/graphql", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			// simple requests always reach the handler, the browser blocks denied ones
+			if !called {
+				t.Fatal("next handler is not called")
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Fatalf("got allow origin %q, want %q", got, tt.allow)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+				t.Fatalf("got allow credentials %q, want %q", got, tt.credentials)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	c, err := New(testEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		origin  string
+		method  string
+		status  int
+		methods string
+		headers string
+		maxAge  string
+	}{
+		{"allowed", "/graphql", "http://localhost:8080", "POST", http.StatusNoContent, "GET, POST", "Content-Type, X-CSRF-Token", "600"},
+		{"lower case method", "/graphql", "http://localhost:8080", "post", http.StatusNoContent, "GET, POST", "Content-Type, X-CSRF-Token", "600"},
+		{"denied method", "/login", "http://localhost:8080", "GET", http.StatusForbidden, "", "", ""},
+		{"denied origin", "/graphql", "http://evil.example.com", "POST", http.StatusForbidden, "", "", ""},
+		{"no headers", "/login", "http://localhost:8080", "POST", http.StatusNoContent, "POST", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Fatal("preflight reached the next handler")
+			}))
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.status)
+			}
+			want := map[string]string{
+				"Access-Control-Allow-Methods": tt.methods,
+				"Access-Control-Allow-Headers": tt.headers,
+				"Access-Control-Max-Age":       tt.maxAge,
+			}
+			for k, v := range want {
+				if got := rec.Header().Get(k); got != v {
+					t.Fatalf("got %s %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  error
+	}{
+		{"no policy", map[string]string{}, nil},
+		{"no path", map[string]string{"routes": "a", "a_origins": "*"}, ErrNoPath},
+		{"wildcard credentials", map[string]string{"routes": "a", "a_path": "/", "a_origins": "*", "a_credentials": "true"}, ErrWildcardCred},
+		{"negative max age", map[string]string{"routes": "a", "a_path": "/", "a_max_age": "-1"}, ErrMaxAge},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.env)
+		if err != tt.err {
+			t.Fatalf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
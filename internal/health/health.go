@@ -0,0 +1,180 @@
+// Package health serves liveness and readiness endpoints
+// and shuts servers down gracefully.
+//
+//	/healthz  process is alive, always 200 until shutdown
+//	/readyz   every check passed, 503 otherwise or while draining
+//
+// on SIGINT or SIGTERM the service stops being ready, waits for
+// active requests to finish and runs its stop functions, like closing db pools.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// deadline of all readiness checks
+	checkTimeout time.Duration = 2 * time.Second
+
+	// log strings
+	srvStopping string = ">> Shutdown Signal Received, Draining. signal:"
+	srvStopped  string = ">> Service Stopped Gracefully."
+	stopErr     string = ">> Shutdown Step Failed. Error:"
+)
+
+// Check returns an error when a dependency is not usable.
+type Check func(ctx context.Context) error
+
+// Health keeps readiness checks and stop functions of a service.
+type Health struct {
+	mu       sync.Mutex
+	names    []string
+	checks   map[string]Check
+	stops    []func() error
+	draining int32
+}
+
+// report is the json body of the endpoints.
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// New creates an empty health.
+func New() *Health {
+	return &Health{checks: make(map[string]Check)}
+}
+
+// Add adds a readiness check.
+func (h *Health) Add(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.checks[name]; !exists {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// OnStop adds a function that runs after the server is drained.
+// functions run in reverse order of adding.
+func (h *Health) OnStop(stop func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stops = append(h.stops, stop)
+}
+
+// Register adds the endpoints to the mux.
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", h.LiveHandle)
+	mux.HandleFunc("/readyz", h.ReadyHandle)
+}
+
+// LiveHandle reports that the process is serving.
+func (h *Health) LiveHandle(w http.ResponseWriter, r *http.Request) {
+	write(w, http.StatusOK, report{Status: "OK"})
+}
+
+// ReadyHandle runs every check concurrently.
+func (h *Health) ReadyHandle(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.draining) == 1 {
+		write(w, http.StatusServiceUnavailable, report{Status: "Draining"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+	h.mu.Lock()
+	names := append([]string(nil), h.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.Unlock()
+	results := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+	rep := report{Status: "OK", Checks: make(map[string]string, len(names))}
+	status := http.StatusOK
+	for i, name := range names {
+		if results[i] != nil {
+			rep.Checks[name] = results[i].Error()
+			rep.Status = "Failed"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		rep.Checks[name] = "OK"
+	}
+	write(w, status, rep)
+}
+
+// Serve runs listen until SIGINT or SIGTERM,
+// then drains the server in timeout and runs the stop functions.
+// listen is the blocking listen function of the server.
+func (h *Health) Serve(server *http.Server, listen func() error, timeout time.Duration) error {
+	fail := make(chan error, 1)
+	go func() {
+		fail <- listen()
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case err := <-fail:
+		h.stop()
+		return err
+	case sig := <-signals:
+		log.Println(srvStopping, sig)
+	}
+	atomic.StoreInt32(&h.draining, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	h.stop()
+	if err != nil {
+		return err
+	}
+	log.Println(srvStopped)
+	return nil
+}
+
+func (h *Health) stop() {
+	h.mu.Lock()
+	stops := append([]func() error(nil), h.stops...)
+	h.mu.Unlock()
+	for i := len(stops) - 1; i >= 0; i-- {
+		err := stops[i]()
+		if err != nil {
+			log.Println(stopErr, err)
+		}
+	}
+}
+
+// Ping checks a database pool.
+func Ping(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+func write(w http.ResponseWriter, status int, rep report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rep)
+}
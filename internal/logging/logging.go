@@ -0,0 +1,192 @@
+// Package logging writes structured json logs with log/slog.
+//
+// Setup replaces the default logger, so old 'log.Println' lines
+// are written as json too. values of sensitive keys are redacted
+// in log attributes and in logged json bodies:
+//
+//	log_level  = info
+//	log_redact = password, token, secret, card_number, cvv
+//
+// keys are matched case insensitive, 'access_token' matches 'token'.
+package logging
+
+import (
+	"context"
+	"ecomm/internal/trace"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redacted values
+	redacted string = "******"
+
+	// access log message
+	accessLog string = ">> Request Handled"
+)
+
+var (
+	// sensitive key parts, lower case
+	redactMu   sync.RWMutex
+	redactKeys []string
+)
+
+// Setup creates the json logger of the service and makes it the default.
+func Setup(service, level string, keys []string) *slog.Logger {
+	SetRedact(keys)
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		lvl = slog.LevelInfo
+	}
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: lvl,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if Sensitive(a.Key) {
+				return slog.String(a.Key, redacted)
+			}
+			return a
+		},
+	})
+	logger := slog.New(handler).With("service", service)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// SetRedact replaces the sensitive key list.
+func SetRedact(keys []string) {
+	arr := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key != "" {
+			arr = append(arr, key)
+		}
+	}
+	redactMu.Lock()
+	redactKeys = arr
+	redactMu.Unlock()
+}
+
+// Sensitive reports whether values of key must be hidden.
+func Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	for _, part := range redactKeys {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Body returns a json body with sensitive values redacted,
+// bodies that are not json are not logged at all.
+func Body(body []byte) string {
+	var v interface{}
+	err := json.Unmarshal(body, &v)
+	if err != nil {
+		return redacted
+	}
+	out, err := json.Marshal(redact(v))
+	if err != nil {
+		return redacted
+	}
+	return string(out)
+}
+
+func redact(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, inner := range value {
+			if Sensitive(key) {
+				value[key] = redacted
+				continue
+			}
+			value[key] = redact(inner)
+		}
+	case []interface{}:
+		for i, inner := range value {
+			value[i] = redact(inner)
+		}
+	}
+	return v
+}
+
+// fields are request values that handlers learn later, like the user.
+type fields struct {
+	mu     sync.Mutex
+	userID string
+}
+
+type fieldsKey struct{}
+
+// SetUser records the user of the request for the access log.
+func SetUser(ctx context.Context, userID string) {
+	f, _ := ctx.Value(fieldsKey{}).(*fields)
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	f.userID = userID
+	f.mu.Unlock()
+}
+
+// From returns the default logger with the request fields of ctx.
+func From(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := trace.ID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if f, _ := ctx.Value(fieldsKey{}).(*fields); f != nil {
+		f.mu.Lock()
+		userID := f.userID
+		f.mu.Unlock()
+		if userID != "" {
+			logger = logger.With("user_id", userID)
+		}
+	}
+	return logger
+}
+
+// recorder keeps the status written by the handler.
+type recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Handler writes an access log line for every request.
+// user finds the user of the request before the handler runs, it may be nil.
+func Handler(user func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		f := &fields{}
+		if user != nil {
+			f.userID = user(r)
+		}
+		ctx := context.WithValue(r.Context(), fieldsKey{}, f)
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		From(ctx).Log(ctx, level, accessLog,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote", r.RemoteAddr,
+		)
+	})
+}
@@ -0,0 +1,96 @@
+// Package mail sends plain text mails.
+//
+// 'log' sender writes recipients and subjects to the service log for development,
+// bodies are never logged, they carry tokens like verification links,
+// 'smtp' sender delivers mails with plain auth when a user is set.
+//
+//	sender, err := mail.New(conf.Mail)
+//	err = sender.Send("user@example.com", "Subject", "Body")
+package mail
+
+import (
+	"ecomm/internal/config"
+	"errors"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+const (
+	// log strings
+	mailLogged string = ">> Mail Not Sent, Log Sender:"
+)
+
+var (
+	// errors
+	ErrSender   error = errors.New("mail: unknown sender, use 'log' or 'smtp'")
+	ErrSMTPAddr error = errors.New("mail: smtp sender requires smtp_addr")
+	ErrHeader   error = errors.New("mail: address or subject contains a line break")
+)
+
+// Sender sends a plain text mail.
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// New returns the configured sender.
+func New(c config.Mail) (Sender, error) {
+	switch c.MailSender {
+	case "", "log":
+		return logSender{}, nil
+	case "smtp":
+		if c.SMTPAddr == "" {
+			return nil, ErrSMTPAddr
+		}
+		s := &smtpSender{addr: c.SMTPAddr, from: c.MailFrom}
+		if c.SMTPUser != "" {
+			host, _, err := net.SplitHostPort(c.SMTPAddr)
+			if err != nil {
+				return nil, err
+			}
+			s.auth = smtp.PlainAuth("", c.SMTPUser, c.SMTPPassword, host)
+		}
+		return s, nil
+	}
+	return nil, ErrSender
+}
+
+type logSender struct{}
+
+func (logSender) Send(to, subject, body string) error {
+	if err := checkHeaders(to, subject); err != nil {
+		return err
+	}
+	log.Println(mailLogged, "to:", to, "subject:", subject, "body:", len(body), "bytes")
+	return nil
+}
+
+type smtpSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (s *smtpSender) Send(to, subject, body string) error {
+	if err := checkHeaders(s.from, to, subject); err != nil {
+		return err
+	}
+	msg := "From: " + s.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(msg))
+}
+
+// checkHeaders rejects header injection through addresses and subjects.
+func checkHeaders(values ...string) error {
+	for _, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return ErrHeader
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// http metrics of every service
+	requestCount    *Counter   = NewCounter("http_requests_total", "Handled http requests.", "route", "method", "status")
+	requestDuration *Histogram = NewHistogram("http_request_duration_seconds", "Latency of http requests.", nil, "route", "method")
+)
+
+// recorder keeps the status written by the handler.
+type recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Instrument counts requests and their latency.
+// route label is the matching pattern of the mux, not the raw path,
+// so unknown paths can not grow the series.
+func Instrument(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		requestCount.Inc(route, r.Method, strconv.Itoa(rec.status))
+		requestDuration.Since(start, route, r.Method)
+	})
+}
@@ -0,0 +1,302 @@
+// Package metrics collects counters, histograms and gauges
+// and serves them in prometheus text format.
+//
+// metrics are registered to a package level registry when created,
+// every service serves them on its own metrics port:
+//
+//	go metrics.Listen(conf.MetricsPort)
+package metrics
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// log strings
+	metricsStart string = ">> Metrics Listener Started. port:"
+	metricsEnd   string = ">> Metrics Listener Shutdown Unexpectedly. Error:"
+)
+
+var (
+	// default latency buckets in seconds
+	DefaultBuckets []float64 = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	// registered metrics in creation order
+	mu         sync.Mutex
+	collectors []collector
+)
+
+// collector writes a metric family.
+type collector interface {
+	write(b *strings.Builder)
+}
+
+// series is a labelled value of a family.
+type series struct {
+	values []string
+	value  float64
+	// histogram only
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// family is the common part of counters and histograms.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+// get returns the series of label values, f.mu must be held.
+func (f *family) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{values: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by labels, f.mu must be held.
+func (f *family) sorted() []*series {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	arr := make([]*series, len(keys))
+	for i, key := range keys {
+		arr[i] = f.series[key]
+	}
+	return arr
+}
+
+func (f *family) header(b *strings.Builder) {
+	b.WriteString("# HELP " + f.name + " " + f.help + "\n")
+	b.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+}
+
+// Counter only goes up.
+type Counter struct {
+	family
+}
+
+// NewCounter creates and registers a counter.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, "counter", labels)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the series of label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the series of label values.
+func (c *Counter) Add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(values).value += v
+}
+
+func (c *Counter) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(b)
+	for _, s := range c.sorted() {
+		b.WriteString(c.name + labels(c.labels, s.values, "", "") + " " + format(s.value) + "\n")
+	}
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	family
+	buckets []float64
+}
+
+// NewHistogram creates and registers a histogram,
+// nil buckets means DefaultBuckets.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{family: newFamily(name, help, "histogram", labels), buckets: buckets}
+	register(h)
+	return h
+}
+
+// Observe adds v to the series of label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Since observes the seconds passed since start.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *Histogram) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(b)
+	for _, s := range h.sorted() {
+		for i, bound := range h.buckets {
+			b.WriteString(h.name + "_bucket" + labels(h.labels, s.values, "le", format(bound)) + " " + strconv.FormatUint(s.counts[i], 10) + "\n")
+		}
+		b.WriteString(h.name + "_bucket" + labels(h.labels, s.values, "le", "+Inf") + " " + strconv.FormatUint(s.count, 10) + "\n")
+		b.WriteString(h.name + "_sum" + labels(h.labels, s.values, "", "") + " " + format(s.sum) + "\n")
+		b.WriteString(h.name + "_count" + labels(h.labels, s.values, "", "") + " " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+}
+
+// Func reads its value when metrics are served.
+type Func struct {
+	name string
+	help string
+	kind string
+	fn   func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge.
+func NewGaugeFunc(name, help string, fn func() float64) *Func {
+	f := &Func{name: name, help: help, kind: "gauge", fn: fn}
+	register(f)
+	return f
+}
+
+// NewCounterFunc creates and registers a counter kept by someone else.
+func NewCounterFunc(name, help string, fn func() float64) *Func {
+	f := &Func{name: name, help: help, kind: "counter", fn: fn}
+	register(f)
+	return f
+}
+
+func (f *Func) write(b *strings.Builder) {
+	b.WriteString("# HELP " + f.name + " " + f.help + "\n")
+	b.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+	b.WriteString(f.name + " " + format(f.fn()) + "\n")
+}
+
+// DBStats registers pool statistics of a database.
+func DBStats(db *sql.DB) {
+	NewGaugeFunc("db_open_connections", "Open connections of the database pool.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	NewGaugeFunc("db_in_use_connections", "Connections in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	NewGaugeFunc("db_idle_connections", "Idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	NewGaugeFunc("db_max_open_connections", "Maximum open connections of the pool.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	NewCounterFunc("db_wait_count_total", "Total connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	NewCounterFunc("db_wait_duration_seconds_total", "Total time waited for connections.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+}
+
+// Handler serves every registered metric.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arr := append([]collector(nil), collectors...)
+		mu.Unlock()
+		var b strings.Builder
+		for _, c := range arr {
+			c.write(&b)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(b.String()))
+	})
+}
+
+// Listen serves metrics on a separate port,
+// so metrics are never exposed on public listeners.
+func Listen(port string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	log.Println(metricsStart, port)
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	err := server.ListenAndServe()
+	log.Println(metricsEnd, err)
+}
+
+func register(c collector) {
+	mu.Lock()
+	defer mu.Unlock()
+	collectors = append(collectors, c)
+}
+
+// labels formats label pairs, extra pair is added when its name is not empty.
+func labels(names, values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name+`="`+escape(value)+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+escape(extraValue)+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+func format(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
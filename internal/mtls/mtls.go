@@ -0,0 +1,110 @@
+// Package mtls loads the certificates created by tools/gencerts
+// and builds tls configurations for internal service calls.
+// every service has a certificate signed by the local ca,
+// its common name is the service name.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// file names inside the certificate directory
+	caFile  string        = "ca.pem"
+	certExt string        = ".pem"
+	keyExt  string        = "-key.pem"
+	timeout time.Duration = 10 * time.Second
+)
+
+var (
+	// errors
+	ErrNoCA      error = errors.New("mtls: ca certificate can not be parsed")
+	ErrNoPeer    error = errors.New("mtls: client certificate is missing")
+	ErrForbidden error = errors.New("mtls: peer is not allowed")
+)
+
+// CertPool reads the local ca certificate.
+func CertPool(dir string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(filepath.Join(dir, caFile))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrNoCA
+	}
+	return pool, nil
+}
+
+// Certificate reads the certificate and the key of the service.
+func Certificate(dir, name string) (tls.Certificate, error) {
+	return tls.LoadX509KeyPair(filepath.Join(dir, name+certExt), filepath.Join(dir, name+keyExt))
+}
+
+// ServerConfig creates a server configuration that requires a client certificate
+// signed by the local ca, and accepts only the peers in the allowed list.
+func ServerConfig(dir, name string, allowed []string) (*tls.Config, error) {
+	pool, err := CertPool(dir)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := Certificate(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	peers := make(map[string]bool)
+	for _, peer := range allowed {
+		peers[peer] = true
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		// chain is already verified here, only the identity is checked.
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return ErrNoPeer
+			}
+			if !peers[state.PeerCertificates[0].Subject.CommonName] {
+				return ErrForbidden
+			}
+			return nil
+		},
+	}, nil
+}
+
+// ClientConfig creates a client configuration that presents the service certificate
+// and trusts only the local ca.
+func ClientConfig(dir, name string) (*tls.Config, error) {
+	pool, err := CertPool(dir)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := Certificate(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}, nil
+}
+
+// Client creates an http client for internal calls.
+func Client(dir, name string) (*http.Client, error) {
+	config, err := ClientConfig(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
@@ -0,0 +1,473 @@
+// Package openapi builds openapi 3 documents from route tables.
+//
+// services register their routes with Register, so every served
+// route is documented and every documented route is served:
+//
+//	doc := openapi.New("Auth Service", "1.0.0")
+//	doc.Register(http.DefaultServeMux, routes...)
+//	http.Handle("/openapi.json", doc)
+//
+// responses are documented in the envelope of package apierr,
+// tests check response bodies with Envelope, Failure and Validate.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	version string = "3.0.3"
+)
+
+// Route is a mux pattern with its handler and documented operations.
+// handlers of plain patterns like '/login' check methods themselves.
+type Route struct {
+	Pattern    string
+	Handler    http.HandlerFunc
+	Operations []Operation
+}
+
+// Operation documents a method of a path.
+type Operation struct {
+	Method  string
+	Path    string
+	Summary string
+	Tags    []string
+	Params  []Param
+	// request body, nil when there is none
+	Body *Schema
+	// 'data' of the success envelope, nil means null
+	Data *Schema
+	// success status, 200 when zero, 204 and redirects have no body
+	Status int
+	// success body when it is not an envelope, like documents
+	Raw *Schema
+	// documented error statuses
+	Errors []int
+}
+
+// Param is a path, query or header parameter.
+type Param struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// Schema is the json schema subset of openapi 3.0.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+}
+
+// String returns a string schema.
+func String(description string) *Schema {
+	return &Schema{Type: "string", Description: description}
+}
+
+// Integer returns an integer schema.
+func Integer(description string) *Schema {
+	return &Schema{Type: "integer", Description: description}
+}
+
+// Bool returns a boolean schema.
+func Bool(description string) *Schema {
+	return &Schema{Type: "boolean", Description: description}
+}
+
+// Enum returns a string schema with allowed values.
+func Enum(description string, values ...string) *Schema {
+	return &Schema{Type: "string", Description: description, Enum: values}
+}
+
+// Array returns an array schema.
+func Array(items *Schema) *Schema {
+	return &Schema{Type: "array", Items: items}
+}
+
+// Map returns an object schema with any keys.
+func Map(values *Schema) *Schema {
+	if values == nil {
+		return &Schema{Type: "object", AdditionalProperties: true}
+	}
+	return &Schema{Type: "object", AdditionalProperties: values}
+}
+
+// Object returns a closed object schema, required keys are marked with '*'.
+//
+//	openapi.Object(map[string]*openapi.Schema{"*email": openapi.String("")})
+func Object(properties map[string]*Schema) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema), AdditionalProperties: false}
+	for name, p := range properties {
+		if strings.HasPrefix(name, "*") {
+			name = name[1:]
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = p
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// Success returns the success status of the operation.
+func (op Operation) Success() int {
+	if op.Status == 0 {
+		return http.StatusOK
+	}
+	return op.Status
+}
+
+// Envelope returns the schema of the success body,
+// nil when the success response has no body.
+func Envelope(op Operation) *Schema {
+	status := op.Success()
+	if status == http.StatusNoContent || (status >= 300 && status < 400) {
+		return nil
+	}
+	if op.Raw != nil {
+		return op.Raw
+	}
+	data := op.Data
+	if data == nil {
+		data = &Schema{Nullable: true, Description: "always null"}
+	}
+	return Object(map[string]*Schema{
+		"*status":    Enum("", "OK", "MFA Required"),
+		"*data":      data,
+		"*error":     {Nullable: true, Description: "always null"},
+		"request_id": String(""),
+	})
+}
+
+// ValidateSuccess checks a success body against the envelope of the operation,
+// data is checked with ValidateExact.
+func (op Operation) ValidateSuccess(body []byte) error {
+	schema := Envelope(op)
+	if schema == nil {
+		return nil
+	}
+	err := schema.Validate(body)
+	if err != nil || op.Raw != nil || op.Data == nil {
+		return err
+	}
+	envelope := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	err = json.Unmarshal(body, &envelope)
+	if err != nil {
+		return err
+	}
+	return op.Data.ValidateExact(envelope.Data)
+}
+
+// Failure returns the schema of error bodies.
+func Failure() *Schema {
+	return &Schema{Ref: "#/components/schemas/Failure"}
+}
+
+// components are the shared schemas of every document.
+func components() map[string]*Schema {
+	return map[string]*Schema{
+		"Error": Object(map[string]*Schema{
+			"*code":    String("stable machine readable code"),
+			"*message": String("human readable message"),
+			"details":  Map(String("")),
+		}),
+		"Failure": Object(map[string]*Schema{
+			"*status":    Enum("", "Failed"),
+			"*data":      {Nullable: true, Description: "always null"},
+			"*error":     {Ref: "#/components/schemas/Error"},
+			"request_id": String(""),
+		}),
+	}
+}
+
+// Validate checks a json body against the schema.
+// schemas without a type accept any value.
+func (s *Schema) Validate(body []byte) error {
+	return s.check(body, false)
+}
+
+// ValidateExact is Validate that also fails when a documented key
+// of a closed object is missing, contract tests use it so documents
+// can not list fields that responses never have.
+func (s *Schema) ValidateExact(body []byte) error {
+	return s.check(body, true)
+}
+
+func (s *Schema) check(body []byte, exact bool) error {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err := dec.Decode(&v)
+	if err != nil {
+		return err
+	}
+	return s.validate("$", v, exact)
+}
+
+func (s *Schema) validate(path string, v interface{}, exact bool) error {
+	if s.Ref != "" {
+		ref := components()[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if ref == nil {
+			return fmt.Errorf("%s: unknown schema %s", path, s.Ref)
+		}
+		return ref.validate(path, v, exact)
+	}
+	if v == nil {
+		if s.Nullable || (s.Type == "" && len(s.OneOf) == 0) {
+			return nil
+		}
+		return fmt.Errorf("%s: must not be null", path)
+	}
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, one := range s.OneOf {
+			if one.validate(path, v, exact) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: matches %d of oneOf schemas, want 1", path, matches)
+		}
+		return nil
+	}
+	switch s.Type {
+	case "string":
+		text, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", path)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, text) {
+			return fmt.Errorf("%s: %q is not one of %v", path, text, s.Enum)
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: must be an integer", path)
+		}
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("%s: must be an integer", path)
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return fmt.Errorf("%s: must be a number", path)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: must be a boolean", path)
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an array", path)
+		}
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range arr {
+			err := s.Items.validate(path+"["+strconv.Itoa(i)+"]", item, exact)
+			if err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an object", path)
+		}
+		for _, key := range s.Required {
+			if _, exists := obj[key]; !exists {
+				return fmt.Errorf("%s.%s: required", path, key)
+			}
+		}
+		if exact && s.AdditionalProperties == false {
+			documented := make([]string, 0, len(s.Properties))
+			for key := range s.Properties {
+				documented = append(documented, key)
+			}
+			sort.Strings(documented)
+			for _, key := range documented {
+				if _, exists := obj[key]; !exists {
+					return fmt.Errorf("%s.%s: documented but missing", path, key)
+				}
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			p, exists := s.Properties[key]
+			if !exists {
+				switch extra := s.AdditionalProperties.(type) {
+				case *Schema:
+					p = extra
+				case bool:
+					if !extra {
+						return fmt.Errorf("%s.%s: unknown key", path, key)
+					}
+				}
+			}
+			if p == nil {
+				continue
+			}
+			err := p.validate(path+"."+key, obj[key], exact)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func contains(arr []string, value string) bool {
+	for _, v := range arr {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Document is an openapi document, it serves itself as json.
+type Document struct {
+	title   string
+	version string
+
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// New creates an empty document.
+func New(title, apiVersion string) *Document {
+	return &Document{title: title, version: apiVersion}
+}
+
+// Add documents operations that are served elsewhere,
+// like routes of another service.
+func (d *Document) Add(ops ...Operation) {
+	d.mu.Lock()
+	d.ops = append(d.ops, ops...)
+	d.mu.Unlock()
+}
+
+// Operations returns documented operations in adding order.
+func (d *Document) Operations() []Operation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Operation(nil), d.ops...)
+}
+
+// Register registers routes to mux and documents them.
+func (d *Document) Register(mux *http.ServeMux, routes ...Route) {
+	for _, route := range routes {
+		mux.HandleFunc(route.Pattern, route.Handler)
+		d.Add(route.Operations...)
+	}
+}
+
+// ServeHTTP serves the document.
+func (d *Document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := d.JSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// JSON returns the document.
+func (d *Document) JSON() ([]byte, error) {
+	ops := d.Operations()
+	paths := make(map[string]map[string]interface{})
+	for _, op := range ops {
+		path := paths[op.Path]
+		if path == nil {
+			path = make(map[string]interface{})
+			paths[op.Path] = path
+		}
+		path[strings.ToLower(op.Method)] = operation(op)
+	}
+	doc := map[string]interface{}{
+		"openapi": version,
+		"info": map[string]string{
+			"title":   d.title,
+			"version": d.version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": components(),
+		},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func operation(op Operation) map[string]interface{} {
+	out := map[string]interface{}{
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+	}
+	if len(op.Tags) > 0 {
+		out["tags"] = op.Tags
+	}
+	if len(op.Params) > 0 {
+		out["parameters"] = op.Params
+	}
+	if op.Body != nil {
+		out["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  content(op.Body),
+		}
+	}
+	status := op.Success()
+	responses := make(map[string]interface{})
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if body := Envelope(op); body != nil {
+		success["content"] = content(body)
+	}
+	responses[strconv.Itoa(status)] = success
+	for _, code := range op.Errors {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content":     content(Failure()),
+		}
+	}
+	out["responses"] = responses
+	return out
+}
+
+func content(schema *Schema) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// operationID is like 'post_apikey_create' or 'get_v1_table_id'.
+func operationID(op Operation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '.'
+	}) {
+		id += "_" + part
+	}
+	return id
+}
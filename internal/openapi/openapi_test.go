@@ -0,0 +1,174 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	user := Object(map[string]*Schema{
+		"*user_id": String(""),
+		"age":      Integer(""),
+		"admin":    Bool(""),
+		"type":     Enum("", "standart", "admin"),
+		"tags":     Array(String("")),
+		"extra":    Map(nil),
+	})
+	tests := []struct {
+		name   string
+		schema *Schema
+		body   string
+		ok     bool
+	}{
+		{"valid object", user, `{"user_id":"1","age":30,"admin":false,"type":"admin","tags":["a"],"extra":{"x":1}}`, true},
+		{"missing required", user, `{"age":30}`, false},
+		{"unknown key", user, `{"user_id":"1","password":"x"}`, false},
+		{"wrong string", user, `{"user_id":1}`, false},
+		{"wrong integer", user, `{"user_id":"1","age":1.5}`, false},
+		{"wrong boolean", user, `{"user_id":"1","admin":"false"}`, false},
+		{"wrong enum", user, `{"user_id":"1","type":"root"}`, false},
+		{"wrong item", user, `{"user_id":"1","tags":[1]}`, false},
+		{"null not nullable", user, `null`, false},
+		{"null nullable", &Schema{Type: "object", Nullable: true}, `null`, true},
+		{"any value", &Schema{}, `[1,"a",null]`, true},
+		{"typed map", Map(String("")), `{"a":"b","c":1}`, false},
+		{"one of", &Schema{OneOf: []*Schema{String(""), Integer("")}}, `"a"`, true},
+		{"none of", &Schema{OneOf: []*Schema{String(""), Integer("")}}, `true`, false},
+		{"invalid json", user, `{`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.schema.Validate([]byte(tt.body))
+			if (err == nil) != tt.ok {
+				t.Fatalf("Validate(%s) = %v, want ok %v", tt.body, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	data := Object(map[string]*Schema{"*email": String("")})
+	tests := []struct {
+		name string
+		op   Operation
+		body string
+		ok   bool
+	}{
+		{"data", Operation{Data: data}, `{"status":"OK","data":{"email":"a"},"error":null}`, true},
+		{"mfa status", Operation{Data: data}, `{"status":"MFA Required","data":{"email":"a"},"error":null,"request_id":"r"}`, true},
+		{"wrong data", Operation{Data: data}, `{"status":"OK","data":{},"error":null}`, false},
+		{"failed status", Operation{Data: data}, `{"status":"Failed","data":{"email":"a"},"error":null}`, false},
+		{"null data", Operation{}, `{"status":"OK","data":null,"error":null}`, true},
+		{"raw", Operation{Raw: Map(nil)}, `{"openapi":"3.0.3"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Envelope(tt.op).Validate([]byte(tt.body))
+			if (err == nil) != tt.ok {
+				t.Fatalf("Validate(%s) = %v, want ok %v", tt.body, err, tt.ok)
+			}
+		})
+	}
+	for _, status := range []int{http.StatusNoContent, http.StatusFound} {
+		if Envelope(Operation{Status: status}) != nil {
+			t.Fatalf("status %d must have no body", status)
+		}
+	}
+}
+
+func TestFailure(t *testing.T) {
+	tests := []struct {
+		body string
+		ok   bool
+	}{
+		{`{"status":"Failed","data":null,"error":{"code":"not_found","message":"Not found","details":{"id":"missing"}}}`, true},
+		{`{"status":"Failed","data":null,"error":{"code":"not_found"}}`, false},
+		{`{"status":"Failed","data":null,"error":null}`, false},
+		{`{"status":"OK","data":null,"error":{"code":"a","message":"b"}}`, false},
+	}
+	for _, tt := range tests {
+		err := Failure().Validate([]byte(tt.body))
+		if (err == nil) != tt.ok {
+			t.Fatalf("Validate(%s) = %v, want ok %v", tt.body, err, tt.ok)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	doc := New("Test", "1.0.0")
+	mux := http.NewServeMux()
+	handler := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }
+	doc.Register(mux, Route{Pattern: "/items", Handler: handler, Operations: []Operation{
+		{Method: http.MethodGet, Path: "/items", Data: Array(String(""))},
+		{Method: http.MethodPost, Path: "/items", Status: http.StatusCreated, Errors: []int{http.StatusBadRequest}},
+	}})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Fatalf("route is not registered, status %d", rec.Code)
+	}
+	if len(doc.Operations()) != 2 {
+		t.Fatalf("got %d operations, want 2", len(doc.Operations()))
+	}
+	body, err := doc.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := struct {
+		Paths map[string]map[string]struct {
+			OperationID string                     `json:"operationId"`
+			Responses   map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+	}{}
+	err = json.Unmarshal(body, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := out.Paths["/items"]["post"]
+	if post.OperationID != "post_items" {
+		t.Fatalf("got operation id %q", post.OperationID)
+	}
+	for _, code := range []string{"201", "400"} {
+		if _, ok := post.Responses[code]; !ok {
+			t.Fatalf("response %s is not documented", code)
+		}
+	}
+	if _, ok := out.Paths["/items"]["get"].Responses["200"]; !ok {
+		t.Fatal("default success status is not documented")
+	}
+}
+
+func TestValidateExact(t *testing.T) {
+	user := Object(map[string]*Schema{
+		"*user_id": String(""),
+		"email":    String(""),
+		"tags":     Array(Object(map[string]*Schema{"name": String("")})),
+		"extra":    Map(nil),
+	})
+	tests := []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"every key", `{"user_id":"1","email":"a","tags":[{"name":"x"}],"extra":{}}`, true},
+		{"documented key missing", `{"user_id":"1","tags":[],"extra":{}}`, false},
+		{"nested key missing", `{"user_id":"1","email":"a","tags":[{}],"extra":{}}`, false},
+		{"undocumented key", `{"user_id":"1","email":"a","tags":[],"extra":{},"password":"x"}`, false},
+		{"open map keys", `{"user_id":"1","email":"a","tags":[],"extra":{"any":1}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := user.ValidateExact([]byte(tt.body))
+			if (err == nil) != tt.ok {
+				t.Fatalf("ValidateExact(%s) = %v, want ok %v", tt.body, err, tt.ok)
+			}
+		})
+	}
+	// Validate still accepts missing optional keys
+	if err := user.Validate([]byte(`{"user_id":"1"}`)); err != nil {
+		t.Fatal(err)
+	}
+}
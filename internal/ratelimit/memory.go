@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket, tokens are refilled lazily on take.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore keeps buckets in process memory.
+// full buckets are dropped periodically, a full bucket equals a missing one.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	limits  map[string]Limit
+}
+
+// NewMemoryStore creates a store and starts its cleaner.
+func NewMemoryStore(cleanEvery time.Duration) *MemoryStore {
+	m := &MemoryStore{
+		buckets: make(map[string]*bucket),
+		limits:  make(map[string]Limit),
+	}
+	go m.clean(cleanEvery)
+	return m
+}
+
+// Take implements Store.
+func (m *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, exists := m.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+		m.limits[key] = limit
+	}
+	refill(b, limit, now)
+	result := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((float64(limit.Burst) - b.tokens) / limit.Rate * float64(time.Second))
+	return result, nil
+}
+
+func (m *MemoryStore) clean(every time.Duration) {
+	for now := range time.Tick(every) {
+		m.mu.Lock()
+		for key, b := range m.buckets {
+			limit := m.limits[key]
+			refill(b, limit, now)
+			if b.tokens >= float64(limit.Burst) {
+				delete(m.buckets, key)
+				delete(m.limits, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+func refill(b *bucket, limit Limit, now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens += elapsed * limit.Rate
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+}
@@ -0,0 +1,209 @@
+// Package ratelimit limits requests with token buckets.
+// policies are read from an environment map, every policy covers
+// a path prefix and the longest matching prefix wins.
+//
+//	routes        = default, login
+//	default_path  = /
+//	default_rate  = 10
+//	default_burst = 20
+//	default_by    = ip, user, key
+//	login_path    = /login
+//	login_rate    = 0.2
+//	login_burst   = 5
+//	login_by      = ip
+//
+// rate is tokens per second, burst is the bucket size.
+// 'by' lists the identities a request is counted for,
+// a request is rejected when any of its buckets is empty.
+package ratelimit
+
+import (
+	"ecomm/internal/apierr"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// identity kinds
+const (
+	ByIP   string = "ip"
+	ByUser string = "user"
+	ByKey  string = "key"
+)
+
+var (
+	// errors
+	ErrNoPath   error = errors.New("ratelimit: policy path is missing")
+	ErrBadRate  error = errors.New("ratelimit: rate and burst must be positive numbers")
+	ErrBadIdent error = errors.New("ratelimit: 'by' accepts only ip, user and key")
+)
+
+// Limit is a bucket configuration.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the state of a bucket after a take.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	// time until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps the buckets. MemoryStore is enough for a single gateway,
+// a shared store (like redis) lets many gateways share the same limits.
+type Store interface {
+	// Take removes one token from the bucket of key.
+	// unknown keys start with a full bucket.
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Identity returns the identities of a request by kind,
+// empty identities are not counted.
+type Identity func(r *http.Request) map[string]string
+
+// Policy is the limit of a path prefix.
+type Policy struct {
+	Name  string
+	Path  string
+	Limit Limit
+	By    []string
+}
+
+// Limiter applies policies with a store.
+type Limiter struct {
+	policies []*Policy
+	store    Store
+	identity Identity
+}
+
+// New reads policies from an environment map.
+func New(env map[string]string, store Store, identity Identity) (*Limiter, error) {
+	l := &Limiter{store: store, identity: identity}
+	for _, name := range list(env["routes"]) {
+		rate, err := strconv.ParseFloat(env[name+"_rate"], 64)
+		if err != nil || rate <= 0 {
+			return nil, ErrBadRate
+		}
+		burst, err := strconv.Atoi(env[name+"_burst"])
+		if err != nil || burst <= 0 {
+			return nil, ErrBadRate
+		}
+		policy := &Policy{
+			Name:  name,
+			Path:  env[name+"_path"],
+			Limit: Limit{Rate: rate, Burst: burst},
+			By:    list(env[name+"_by"]),
+		}
+		if policy.Path == "" {
+			return nil, ErrNoPath
+		}
+		if len(policy.By) == 0 {
+			policy.By = []string{ByIP}
+		}
+		for _, by := range policy.By {
+			if by != ByIP && by != ByUser && by != ByKey {
+				return nil, ErrBadIdent
+			}
+		}
+		l.policies = append(l.policies, policy)
+	}
+	// longest prefix first
+	sort.SliceStable(l.policies, func(i, j int) bool {
+		return len(l.policies[i].Path) > len(l.policies[j].Path)
+	})
+	return l, nil
+}
+
+// With returns a limiter with the same policies and store
+// that counts the identities of another function, like identities
+// that are known only after an authentication step.
+func (l *Limiter) With(identity Identity) *Limiter {
+	return &Limiter{policies: l.policies, store: l.store, identity: identity}
+}
+
+// Handler rejects requests over the limit with 429.
+// X-RateLimit-* headers are set when the request is counted at least once.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := l.match(r.URL.Path)
+		if policy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		identities := l.identity(r)
+		now := time.Now()
+		worst := Result{Allowed: true, Remaining: policy.Limit.Burst}
+		counted := false
+		for _, by := range policy.By {
+			id := identities[by]
+			if id == "" {
+				continue
+			}
+			counted = true
+			result, err := l.store.Take(policy.Name+":"+by+":"+id, policy.Limit, now)
+			if err != nil {
+				// a broken shared store must not take the gateway down
+				continue
+			}
+			if !result.Allowed {
+				worst.Allowed = false
+			}
+			if result.Remaining < worst.Remaining {
+				worst.Remaining = result.Remaining
+			}
+			if result.RetryAfter > worst.RetryAfter {
+				worst.RetryAfter = result.RetryAfter
+			}
+			if result.Reset > worst.Reset {
+				worst.Reset = result.Reset
+			}
+		}
+		if !counted {
+			next.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(policy.Limit.Burst))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(worst.Remaining))
+		header.Set("X-RateLimit-Reset", strconv.Itoa(seconds(worst.Reset)))
+		if !worst.Allowed {
+			header.Set("Retry-After", strconv.Itoa(seconds(worst.RetryAfter)))
+			apierr.Fail(w, apierr.TooManyRequests, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) match(path string) *Policy {
+	for _, policy := range l.policies {
+		if strings.HasPrefix(path, policy.Path) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// seconds rounds a duration up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func list(value string) []string {
+	arr := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			arr = append(arr, v)
+		}
+	}
+	return arr
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreRefill(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 2}
+	start := time.Unix(1000, 0)
+	// takes of one key in order, 'at' is the offset from start
+	tests := []struct {
+		name       string
+		at         time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{"full bucket", 0, true, 1, 0},
+		{"last token", 0, true, 0, 0},
+		{"empty bucket", 0, false, 0, time.Second},
+		{"half refilled", 500 * time.Millisecond, false, 0, 500 * time.Millisecond},
+		{"one token refilled", time.Second, true, 0, 0},
+		{"refill stops at burst", time.Minute, true, 1, 0},
+	}
+	store := NewMemoryStore(time.Hour)
+	for _, tt := range tests {
+		result, err := store.Take("key", limit, start.Add(tt.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed != tt.allowed || result.Remaining != tt.remaining || result.RetryAfter != tt.retryAfter {
+			t.Fatalf("%s: got %+v, want allowed %v remaining %d retry after %v",
+				tt.name, result, tt.allowed, tt.remaining, tt.retryAfter)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	env := map[string]string{
+		"routes":        "default, login",
+		"default_path":  "/",
+		"default_rate":  "10",
+		"default_burst": "20",
+		"login_path":    "/login",
+		"login_rate":    "0.2",
+		"login_burst":   "1",
+		"login_by":      "ip, user",
+	}
+	identity := func(r *http.Request) map[string]string {
+		return map[string]string{ByIP: r.RemoteAddr, ByUser: r.Header.Get("X-User")}
+	}
+	limiter, err := New(env, NewMemoryStore(time.Hour), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// requests in order
+	tests := []struct {
+		name       string
+		path       string
+		ip         string
+		user       string
+		status     int
+		remaining  string
+		retryAfter string
+	}{
+		{"first login", "/login", "10.0.0.1", "", http.StatusOK, "0", ""},
+		{"second login", "/login", "10.0.0.1", "", http.StatusTooManyRequests, "0", "5"},
+		{"other ip", "/login", "10.0.0.2", "", http.StatusOK, "0", ""},
+		{"same user other ip", "/login", "10.0.0.3", "user-1", http.StatusOK, "0", ""},
+		{"same user again", "/login", "10.0.0.4", "user-1", http.StatusTooManyRequests, "0", "5"},
+		{"default policy", "/graphql", "10.0.0.1", "", http.StatusOK, "19", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+		req.RemoteAddr = tt.ip
+		req.Header.Set("X-User", tt.user)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: got status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != tt.remaining {
+			t.Fatalf("%s: got remaining %q, want %q", tt.name, got, tt.remaining)
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Fatalf("%s: got retry after %q, want %q", tt.name, got, tt.retryAfter)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  error
+	}{
+		{"valid", map[string]string{"routes": "a", "a_path": "/", "a_rate": "1", "a_burst": "1"}, nil},
+		{"no path", map[string]string{"routes": "a", "a_rate": "1", "a_burst": "1"}, ErrNoPath},
+		{"zero rate", map[string]string{"routes": "a", "a_path": "/", "a_rate": "0", "a_burst": "1"}, ErrBadRate},
+		{"bad burst", map[string]string{"routes": "a", "a_path": "/", "a_rate": "1", "a_burst": "x"}, ErrBadRate},
+		{"bad identity", map[string]string{"routes": "a", "a_path": "/", "a_rate": "1", "a_burst": "1", "a_by": "host"}, ErrBadIdent},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.env, NewMemoryStore(time.Hour), nil)
+		if err != tt.err {
+			t.Fatalf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestWith(t *testing.T) {
+	env := map[string]string{
+		"routes":        "default",
+		"default_path":  "/",
+		"default_rate":  "0.2",
+		"default_burst": "1",
+		"default_by":    "ip, key",
+	}
+	byIP := func(r *http.Request) map[string]string {
+		return map[string]string{ByIP: r.RemoteAddr}
+	}
+	byKey := func(r *http.Request) map[string]string {
+		return map[string]string{ByKey: r.Header.Get("X-Key")}
+	}
+	limiter, err := New(env, NewMemoryStore(time.Hour), byIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the key limiter shares buckets, only counts another identity
+	handler := limiter.With(byKey).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name    string
+		key     string
+		status  int
+		headers bool
+	}{
+		{"not counted", "", http.StatusOK, false},
+		{"not counted again", "", http.StatusOK, false},
+		{"first key request", "abcd", http.StatusOK, true},
+		{"second key request", "abcd", http.StatusTooManyRequests, true},
+		{"other key", "efgh", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Key", tt.key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: got status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit") != ""; got != tt.headers {
+			t.Fatalf("%s: got rate limit headers %v, want %v", tt.name, got, tt.headers)
+		}
+	}
+}
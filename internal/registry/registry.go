@@ -0,0 +1,198 @@
+// Package registry resolves service names to endpoints.
+// static endpoints are read from an environment map,
+// every service may have more than one endpoint.
+//
+//	services     = auth_service, data_service
+//	auth_service = https://localhost:5434
+//	data_service = https://localhost:5433, https://10.0.0.2:5433
+//
+// services may also register themselves with heartbeats,
+// a registered endpoint expires when its heartbeats stop.
+// endpoints are selected with round-robin, endpoints that failed
+// recently are skipped until their cooldown ends.
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errors
+	ErrNoService  error = errors.New("registry: service has no endpoint")
+	ErrBadRequest error = errors.New("registry: name and url are required")
+)
+
+// Endpoint is an address of a service.
+type Endpoint struct {
+	URL string
+	// static endpoints never expire
+	Static bool
+	// last heartbeat of a registered endpoint
+	Seen time.Time
+	// endpoint is skipped until this time
+	Down time.Time
+}
+
+// Registry keeps endpoints of services.
+type Registry struct {
+	mu        sync.Mutex
+	endpoints map[string][]*Endpoint
+	next      map[string]int
+	// registered endpoints expire after ttl without heartbeat
+	ttl time.Duration
+	// failed endpoints are skipped for cooldown
+	cooldown time.Duration
+}
+
+// registration is the heartbeat body.
+type registration struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// New reads static endpoints from an environment map.
+func New(env map[string]string, ttl, cooldown time.Duration) *Registry {
+	r := &Registry{
+		endpoints: make(map[string][]*Endpoint),
+		next:      make(map[string]int),
+		ttl:       ttl,
+		cooldown:  cooldown,
+	}
+	for _, name := range list(env["services"]) {
+		for _, url := range list(env[name]) {
+			r.endpoints[name] = append(r.endpoints[name], &Endpoint{URL: strings.TrimSuffix(url, "/"), Static: true})
+		}
+	}
+	return r
+}
+
+// Register adds an endpoint or renews its heartbeat.
+func (r *Registry) Register(name, url string) {
+	url = strings.TrimSuffix(url, "/")
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.endpoints[name] {
+		if e.URL == url {
+			e.Seen = now
+			// a heartbeat means it is alive again
+			e.Down = time.Time{}
+			return
+		}
+	}
+	r.endpoints[name] = append(r.endpoints[name], &Endpoint{URL: url, Seen: now})
+}
+
+// Resolve returns the next healthy endpoint of the service.
+// when every endpoint is down the next one is returned anyway,
+// a request that may fail is better than no request.
+func (r *Registry) Resolve(name string) (string, error) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	alive := r.alive(name, now)
+	if len(alive) == 0 {
+		return "", ErrNoService
+	}
+	start := r.next[name]
+	r.next[name] = start + 1
+	for i := 0; i < len(alive); i++ {
+		e := alive[(start+i)%len(alive)]
+		if now.After(e.Down) {
+			return e.URL, nil
+		}
+	}
+	return alive[start%len(alive)].URL, nil
+}
+
+// Fail marks an endpoint as down for the cooldown duration.
+func (r *Registry) Fail(name, url string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.endpoints[name] {
+		if e.URL == url {
+			e.Down = time.Now().Add(r.cooldown)
+			return
+		}
+	}
+}
+
+// Endpoints lists the urls of the service that are not expired.
+func (r *Registry) Endpoints(name string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	urls := make([]string, 0)
+	for _, e := range r.alive(name, time.Now()) {
+		urls = append(urls, e.URL)
+	}
+	return urls
+}
+
+// Handler serves heartbeats and lookups.
+//
+//	POST {"name": "auth_service", "url": "https://10.0.0.3:5434"}
+//	GET  ?name=auth_service
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch req.Method {
+		case http.MethodPost:
+			var body registration
+			err := json.NewDecoder(req.Body).Decode(&body)
+			if err != nil || body.Name == "" || body.URL == "" {
+				http.Error(w, ErrBadRequest.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Register(body.Name, body.URL)
+			w.Write([]byte("null"))
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(r.Endpoints(req.URL.Query().Get("name")))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// Heartbeat registers the endpoint to a remote registry periodically.
+// it never returns, it should be started in a goroutine.
+func Heartbeat(client *http.Client, registryURL, name, url string, every time.Duration) {
+	body, _ := json.Marshal(registration{Name: name, URL: url})
+	for {
+		resp, err := client.Post(registryURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Println(">> Registry Heartbeat Failed. Error:", err)
+		} else {
+			resp.Body.Close()
+		}
+		time.Sleep(every)
+	}
+}
+
+// alive returns endpoints that are static or not expired.
+func (r *Registry) alive(name string, now time.Time) []*Endpoint {
+	alive := make([]*Endpoint, 0, len(r.endpoints[name]))
+	for _, e := range r.endpoints[name] {
+		if e.Static || now.Sub(e.Seen) < r.ttl {
+			alive = append(alive, e)
+		}
+	}
+	return alive
+}
+
+func list(value string) []string {
+	arr := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			arr = append(arr, v)
+		}
+	}
+	return arr
+}
@@ -0,0 +1,219 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// exporter names
+	ExporterNone   string = "none"
+	ExporterStdout string = "stdout"
+	ExporterOTLP   string = "otlp"
+
+	// batches are sent when full or at every flush interval
+	batchSize     int           = 128
+	queueSize     int           = 4096
+	flushInterval time.Duration = 5 * time.Second
+
+	// log strings
+	spanLog   string = ">> Span:"
+	exportErr string = ">> Span Export Failed. Error:"
+)
+
+var (
+	// errors
+	ErrUnknownExporter error = errors.New("trace: exporter must be none, stdout or otlp")
+
+	// spans waiting for export, nil when tracing is disabled
+	queue    chan *Span
+	done     chan struct{}
+	stopOnce sync.Once
+)
+
+// Exporter sends finished spans somewhere.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Setup starts exporting spans of the service.
+// endpoint is used only by the otlp exporter.
+func Setup(service, exporter, endpoint string) error {
+	var e Exporter
+	switch exporter {
+	case "", ExporterNone:
+		return nil
+	case ExporterStdout:
+		e = stdoutExporter{service: service}
+	case ExporterOTLP:
+		e = &otlpExporter{service: service, endpoint: endpoint, client: &http.Client{Timeout: 10 * time.Second}}
+	default:
+		return ErrUnknownExporter
+	}
+	queue = make(chan *Span, queueSize)
+	done = make(chan struct{})
+	go run(e)
+	return nil
+}
+
+// Shutdown exports the waiting spans and stops the exporter.
+func Shutdown() error {
+	if queue == nil {
+		return nil
+	}
+	stopOnce.Do(func() {
+		close(queue)
+		<-done
+	})
+	return nil
+}
+
+// export queues a span, spans are dropped when the queue is full
+// so tracing never blocks a request.
+func export(span *Span) {
+	if queue == nil {
+		return
+	}
+	defer func() {
+		// queue is closed on shutdown
+		recover()
+	}()
+	select {
+	case queue <- span:
+	default:
+	}
+}
+
+func run(e Exporter) {
+	defer close(done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := e.Export(batch)
+		if err != nil {
+			log.Println(exportErr, err)
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+	for {
+		select {
+		case span, ok := <-queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// stdoutExporter logs every span as a json line, for local use.
+type stdoutExporter struct {
+	service string
+}
+
+func (e stdoutExporter) Export(spans []*Span) error {
+	for _, span := range spans {
+		line, err := json.Marshal(otlpSpan(span))
+		if err != nil {
+			return err
+		}
+		log.Println(spanLog, e.service, string(line))
+	}
+	return nil
+}
+
+// otlpExporter posts spans to an otlp/http collector in json encoding.
+type otlpExporter struct {
+	service  string
+	endpoint string
+	client   *http.Client
+}
+
+func (e *otlpExporter) Export(spans []*Span) error {
+	arr := make([]map[string]interface{}, len(spans))
+	for i, span := range spans {
+		arr[i] = otlpSpan(span)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": e.service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "ecomm"},
+						"spans": arr,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New("trace: collector answered " + resp.Status)
+	}
+	return nil
+}
+
+func otlpSpan(span *Span) map[string]interface{} {
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	m := map[string]interface{}{
+		"traceId":           hex.EncodeToString(span.TraceID[:]),
+		"spanId":            hex.EncodeToString(span.SpanID[:]),
+		"name":              span.Name,
+		"kind":              span.Kind,
+		"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+		"attributes":        otlpAttributes(span.attributes),
+	}
+	if !isZero(span.ParentID[:]) {
+		m["parentSpanId"] = hex.EncodeToString(span.ParentID[:])
+	}
+	if span.err != "" {
+		m["status"] = map[string]interface{}{"code": 2, "message": span.err}
+	}
+	return m
+}
+
+func otlpAttributes(attributes map[string]string) []interface{} {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	arr := make([]interface{}, len(keys))
+	for i, key := range keys {
+		arr[i] = map[string]interface{}{
+			"key":   key,
+			"value": map[string]string{"stringValue": attributes[key]},
+		}
+	}
+	return arr
+}
@@ -0,0 +1,53 @@
+package trace
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// recorder keeps the status written by the handler.
+type recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Handler wraps every request in a server span.
+// trace of the caller is continued when the request has a traceparent and
+// the callers are trusted, public listeners always start a new trace.
+// trace id is written to 'X-Request-ID' before the handler runs.
+func Handler(trusted bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if trusted {
+			ctx = Extract(ctx, r.Header)
+		}
+		ctx, span := Start(ctx, r.Method+" "+r.URL.Path, KindServer)
+		span.Set("http.method", r.Method)
+		span.Set("http.target", r.URL.Path)
+		w.Header().Set(HeaderRequestID, span.TraceIDString())
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.Set("http.status_code", strconv.Itoa(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.Fail(errStatus(rec.status))
+		}
+		span.Finish()
+	})
+}
+
+// RequestID returns the request id written by Handler,
+// for response helpers that only have the writer.
+func RequestID(w http.ResponseWriter) string {
+	return w.Header().Get(HeaderRequestID)
+}
+
+type errStatus int
+
+func (e errStatus) Error() string {
+	return "status " + strconv.Itoa(int(e))
+}
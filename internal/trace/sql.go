@@ -0,0 +1,42 @@
+package trace
+
+import (
+	"context"
+	"database/sql"
+	"seecool"
+	"strings"
+)
+
+// QueryJson runs seecool.QueryJson in a client span.
+// only the operation is recorded, statements carry user values.
+func QueryJson(ctx context.Context, db *sql.DB, query *seecool.Query) ([]byte, error) {
+	_, span := Start(ctx, "sql query", KindClient)
+	span.Set("db.operation", operation(query.String()))
+	result, err := seecool.QueryJson(db, query)
+	span.Fail(err)
+	span.Finish()
+	return result, err
+}
+
+// Execer is satisfied by both *sql.DB and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Exec runs a statement in a client span.
+func Exec(ctx context.Context, db Execer, query string) (sql.Result, error) {
+	ctx, span := Start(ctx, "sql exec", KindClient)
+	span.Set("db.operation", operation(query))
+	result, err := db.ExecContext(ctx, query)
+	span.Fail(err)
+	span.Finish()
+	return result, err
+}
+
+func operation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,180 @@
+// Package trace records spans and propagates them between services
+// with the w3c 'traceparent' header.
+//
+//	traceparent: 00-<32 hex trace id>-<16 hex parent span id>-<2 hex flags>
+//
+// trace id is also the request id of the services,
+// it is sent back in 'X-Request-ID' header and in json responses.
+// spans are exported in batches by the exporter given to Setup.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// propagation and response headers
+	HeaderParent    string = "traceparent"
+	HeaderRequestID string = "X-Request-ID"
+
+	// span kinds, same values with otlp
+	KindInternal int = 1
+	KindServer   int = 2
+	KindClient   int = 3
+
+	// sampled flag of traceparent
+	flagSampled byte   = 0x01
+	version     string = "00"
+)
+
+// Span is a timed operation of a trace.
+type Span struct {
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte
+	Name     string
+	Kind     int
+	Start    time.Time
+	End      time.Time
+	Sampled  bool
+
+	mu         sync.Mutex
+	attributes map[string]string
+	err        string
+	ended      bool
+}
+
+type spanKey struct{}
+
+// Start starts a span, child of the span in ctx when it exists.
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	span := &Span{Name: name, Kind: kind, Start: time.Now(), Sampled: true}
+	if parent := FromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.Sampled = parent.Sampled
+	} else {
+		rand.Read(span.TraceID[:])
+	}
+	rand.Read(span.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the current span or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ID returns the trace id of ctx, empty when there is no span.
+func ID(ctx context.Context) string {
+	span := FromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	return span.TraceIDString()
+}
+
+// Set adds an attribute to the span.
+func (s *Span) Set(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+}
+
+// Fail marks the span as failed, nil errors are ignored.
+func (s *Span) Fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// Finish ends the span and sends it to the exporter.
+// spans are finished only once.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.Sampled {
+		export(s)
+	}
+}
+
+// TraceIDString returns the trace id in hex.
+func (s *Span) TraceIDString() string {
+	return hex.EncodeToString(s.TraceID[:])
+}
+
+// Parent returns the traceparent header value of the span.
+func (s *Span) Parent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return version + "-" + hex.EncodeToString(s.TraceID[:]) + "-" + hex.EncodeToString(s.SpanID[:]) + "-" + flags
+}
+
+// Inject writes the traceparent of ctx to the request headers.
+func Inject(ctx context.Context, header http.Header) {
+	if span := FromContext(ctx); span != nil {
+		header.Set(HeaderParent, span.Parent())
+	}
+}
+
+// Extract reads a traceparent header into a remote parent span,
+// invalid headers are ignored and a new trace is started.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	parts := strings.Split(strings.TrimSpace(header.Get(HeaderParent)), "-")
+	if len(parts) != 4 || parts[0] != version || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	parent := &Span{}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || isZero(traceID) {
+		return ctx
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || isZero(spanID) {
+		return ctx
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return ctx
+	}
+	copy(parent.TraceID[:], traceID)
+	copy(parent.SpanID[:], spanID)
+	parent.Sampled = flags[0]&flagSampled != 0
+	return context.WithValue(ctx, spanKey{}, parent)
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,305 @@
+// Package upstream calls internal services through the registry.
+//
+// every attempt has its own deadline inside the deadline of the caller context.
+// idempotent calls are retried with jittered exponential backoff,
+// other calls are retried only when the connection could not be made,
+// so the request never reached the service.
+// every service has a circuit breaker, after 'Threshold' consecutive
+// failures calls fail fast with ErrCircuitOpen until 'OpenFor' passes,
+// then a single trial call decides to close or open it again.
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"ecomm/internal/metrics"
+	"ecomm/internal/registry"
+	"ecomm/internal/trace"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// errors
+	ErrCircuitOpen error = errors.New("upstream: circuit is open")
+
+	// latency of single attempts by service and result (ok, rejected, down)
+	callDuration *metrics.Histogram = metrics.NewHistogram("upstream_request_duration_seconds", "Latency of internal service calls.", nil, "service", "result")
+)
+
+// DownError means the service could not answer,
+// connection failed, timed out or returned 5xx.
+type DownError struct {
+	Service string
+	Status  int
+	Err     error
+}
+
+func (e *DownError) Error() string {
+	if e.Err != nil {
+		return "upstream: " + e.Service + " is down: " + e.Err.Error()
+	}
+	return "upstream: " + e.Service + " is down: status " + strconv.Itoa(e.Status)
+}
+
+func (e *DownError) Unwrap() error { return e.Err }
+
+// RejectedError means the service answered with 4xx.
+// body is kept, services explain the rejection in it.
+type RejectedError struct {
+	Service string
+	Status  int
+	Body    []byte
+}
+
+func (e *RejectedError) Error() string {
+	return "upstream: " + e.Service + " rejected the request: status " + strconv.Itoa(e.Status)
+}
+
+// Options of a client, zero values are replaced with defaults.
+type Options struct {
+	// deadline of a single attempt
+	Timeout time.Duration
+	// retry count of retryable calls
+	Retries int
+	// first backoff, doubled on every retry
+	Backoff time.Duration
+	// consecutive failures that open the circuit
+	Threshold int
+	// open circuit duration
+	OpenFor time.Duration
+}
+
+// Client calls services with retries and circuit breakers.
+type Client struct {
+	http     *http.Client
+	services *registry.Registry
+	options  Options
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+type breaker struct {
+	failures int
+	openTill time.Time
+	// a trial call is in flight while the circuit is half open
+	trial bool
+}
+
+type idempotentKey struct{}
+
+type headerKey struct{}
+
+// New creates a client, transport of the http client is used as is.
+func New(httpClient *http.Client, services *registry.Registry, options Options) *Client {
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	if options.Retries < 0 {
+		options.Retries = 0
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = 100 * time.Millisecond
+	}
+	if options.Threshold <= 0 {
+		options.Threshold = 5
+	}
+	if options.OpenFor <= 0 {
+		options.OpenFor = 30 * time.Second
+	}
+	return &Client{
+		http:     httpClient,
+		services: services,
+		options:  options,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// Idempotent marks the calls with the context as safe to retry,
+// for POST calls that only read.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// Header adds a request header to the calls with the context,
+// like the user of a forwarded request.
+func Header(ctx context.Context, key, value string) context.Context {
+	header, _ := ctx.Value(headerKey{}).(http.Header)
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(key, value)
+	return context.WithValue(ctx, headerKey{}, header)
+}
+
+// Get calls a service path with GET.
+func (c *Client) Get(ctx context.Context, service, path string) ([]byte, error) {
+	return c.Do(ctx, http.MethodGet, service, path, nil)
+}
+
+// Post posts a json body to a service path.
+func (c *Client) Post(ctx context.Context, service, path string, body []byte) ([]byte, error) {
+	return c.Do(ctx, http.MethodPost, service, path, body)
+}
+
+// Do calls a service path and returns the body of a 2xx response.
+// errors are *DownError, *RejectedError, ErrCircuitOpen or context errors.
+func (c *Client) Do(ctx context.Context, method, service, path string, body []byte) ([]byte, error) {
+	idempotent := method == http.MethodGet || method == http.MethodHead ||
+		method == http.MethodPut || method == http.MethodDelete ||
+		ctx.Value(idempotentKey{}) != nil
+	var err error
+	for attempt := 0; attempt <= c.options.Retries; attempt++ {
+		if attempt > 0 {
+			err = sleep(ctx, c.backoff(attempt))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !c.allow(service) {
+			return nil, ErrCircuitOpen
+		}
+		var resp []byte
+		var sent bool
+		resp, sent, err = c.attempt(ctx, method, service, path, body)
+		c.record(service, err)
+		if err == nil {
+			return resp, nil
+		}
+		var down *DownError
+		if !errors.As(err, &down) || ctx.Err() != nil {
+			return nil, err
+		}
+		if sent && !idempotent {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// attempt makes a single call, sent is false when the request
+// could not reach the service.
+func (c *Client) attempt(ctx context.Context, method, service, path string, body []byte) ([]byte, bool, error) {
+	url, err := c.services.Resolve(service)
+	if err != nil {
+		return nil, false, &DownError{Service: service, Err: err}
+	}
+	ctx, span := trace.Start(ctx, method+" "+service+path, trace.KindClient)
+	defer span.Finish()
+	span.Set("peer.service", service)
+	span.Set("http.url", url+path)
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	if header, ok := ctx.Value(headerKey{}).(http.Header); ok {
+		for key, values := range header {
+			req.Header[key] = values
+		}
+	}
+	trace.Inject(ctx, req.Header)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		callDuration.Since(start, service, "down")
+		span.Fail(err)
+		c.services.Fail(service, url)
+		return nil, !dialError(err), &DownError{Service: service, Err: err}
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		callDuration.Since(start, service, "down")
+		return nil, true, &DownError{Service: service, Err: err}
+	}
+	switch {
+	case resp.StatusCode >= 500:
+		callDuration.Since(start, service, "down")
+		span.Fail(errors.New(resp.Status))
+		return nil, true, &DownError{Service: service, Status: resp.StatusCode}
+	case resp.StatusCode >= 400:
+		callDuration.Since(start, service, "rejected")
+		return nil, true, &RejectedError{Service: service, Status: resp.StatusCode, Body: respBody}
+	}
+	callDuration.Since(start, service, "ok")
+	return respBody, true, nil
+}
+
+// allow reports whether the circuit of the service lets a call through.
+func (c *Client) allow(service string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breaker(service)
+	if b.failures < c.options.Threshold {
+		return true
+	}
+	if time.Now().Before(b.openTill) || b.trial {
+		return false
+	}
+	// half open, let one call decide
+	b.trial = true
+	return true
+}
+
+// record updates the circuit with the result of a call.
+// rejections mean the service is up, they do not count.
+func (c *Client) record(service string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breaker(service)
+	b.trial = false
+	var down *DownError
+	if err == nil || !errors.As(err, &down) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= c.options.Threshold {
+		b.openTill = time.Now().Add(c.options.OpenFor)
+	}
+}
+
+func (c *Client) breaker(service string) *breaker {
+	b, exists := c.breakers[service]
+	if !exists {
+		b = &breaker{}
+		c.breakers[service] = b
+	}
+	return b
+}
+
+// backoff returns a random duration in [d/2, d), d doubles every attempt.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.options.Backoff << uint(attempt-1)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// dialError reports whether the connection was never made.
+func dialError(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"ecomm/internal/config"
+	"time"
+)
+
+// sessConfig is the configuration of session service.
+type sessConfig struct {
+	config.Tracing
+	config.Logging
+
+	Port    string `env:"sess_service_port" required:"true"`
+	CertDir string `env:"cert_dir" required:"true"`
+	// logins are sent to the gateway, its certificate is signed by the local ca
+	GatewayURL     string        `env:"gateway_url" required:"true"`
+	GatewayTimeout time.Duration `env:"upstream_timeout" default:"5s"`
+
+	// internal listener of '/metrics', disabled when empty
+	MetricsPort string `env:"sess_metrics_port"`
+
+	// active requests are drained in this duration on shutdown
+	ShutdownTimeout time.Duration `env:"shutdown_timeout" default:"15s"`
+}
+
+var (
+	// environment files in override order
+	configSources []config.Source = []config.Source{
+		{Path: envMainDir},
+	}
+)
@@ -1,105 +1,150 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
-	"io/ioutil"
-	"jin"
+	"context"
+	"crypto/tls"
+	"ecomm/internal/apierr"
+	"ecomm/internal/config"
+	"ecomm/internal/cors"
+	"ecomm/internal/health"
+	"ecomm/internal/logging"
+	"ecomm/internal/metrics"
+	"ecomm/internal/mtls"
+	"ecomm/internal/trace"
+	"log"
 	"net/http"
-	"seecool"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"penman"
+)
 
-	"github.com/gorilla/sessions"
+const (
+	// log strings
+	srvConfig    string = ">> Session Service Configuration:"
+	srvConfigErr string = ">> Session Service Configuration Failed. Error:"
+	srvStart     string = ">> Session Service Started."
+	srvEnd       string = ">> Session Service Shutdown Unexpectedly. Error:"
+	proxyFailed  string = ">> Gateway Call Failed. Error:"
 )
 
 var (
-	mainPort string
 	// environment directories,
 	// 'curr' keyword is a wild card for 'currentDirectory'
 	// valid wild card with 'github.com/ecoshub/penman' package
-	envSessionDir string = "curr/.env_session"
-	envMainDir    string = "curr/../.env_main"
+	envMainDir string = "curr/../.env_main"
+	envCorsDir string = "curr/.env_cors"
+
+	// my service name
+	myServiceName string = "session_service"
 
-	// environment map
-	envSessionMap map[string]string
-	// main environment environment map
-	envMainMap map[string]string
-	sessStore  *sessions.CookieStore
+	// service configuration
+	conf sessConfig
+	// liveness, readiness and graceful shutdown
+	checks *health.Health
+	// cross origin policies of the service
+	corsPolicy *cors.CORS
+	// gateway client, trusts only the local ca
+	gatewayClient *http.Client
+	// login requests are passed to the gateway as they are
+	loginProxy *httputil.ReverseProxy
 )
 
+// session service is not an internal service, it has no mtls identity.
+// logins go through the gateway '/login' like browser logins,
+// so csrf checks and rate limits of the gateway apply to them.
 func init() {
-	var err error
-	envMainMap, err = seecool.GetEnv(envSessionDir)
+	err := config.Load(&conf, configSources...)
 	if err != nil {
-		panic(err)
+		log.Fatalln(srvConfigErr, err)
 	}
-	mainPort = envMainMap["sess_service_port"]
-	envSessionMap, err = seecool.GetEnv(envSessionDir)
+	logging.Setup(myServiceName, conf.LogLevel, conf.LogRedact)
+	log.Println(srvConfig + "\n" + config.Redact(&conf))
+	// spans of the service
+	err = trace.Setup(myServiceName, conf.TraceExporter, conf.TraceEndpoint)
 	if err != nil {
-		panic(err)
+		log.Fatalln(srvConfigErr, err)
 	}
-	sessStore = sessions.NewCookieStore([]byte(envSessionMap["secret"]))
-	sessStore.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   86400 * 7,
-		HttpOnly: true,
+	certDir := config.Path(conf.CertDir)
+	if !filepath.IsAbs(certDir) {
+		certDir = filepath.Join(penman.GetCurrentDir(), certDir)
 	}
-}
-
-func main() {
-	fmt.Println("Session service started. port:", mainPort)
-	http.HandleFunc("/", MyHandler)
-	err := http.ListenAndServe(":"+mainPort, nil)
-	panic(err)
-}
-
-func MyHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Origin, cache-control")
-	w.Header().Set("Content-Type", "application/json")
-
-	jsonBody, err := ioutil.ReadAll(r.Body)
+	pool, err := mtls.CertPool(certDir)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		log.Fatalln(srvConfigErr, err)
 	}
-	defer r.Body.Close()
-
-	authResp, err := authenticationControl(jsonBody)
+	gateway, err := url.Parse(conf.GatewayURL)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		log.Fatalln(srvConfigErr, err)
 	}
-
-	authMap, err := jin.GetMap(authResp)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	gatewayClient = &http.Client{Transport: transport, Timeout: conf.GatewayTimeout}
+	loginProxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(gateway)
+			pr.Out.URL.Path = "/login"
+			pr.SetXForwarded()
+			trace.Inject(pr.In.Context(), pr.Out.Header)
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Println(proxyFailed, err)
+			apierr.Fail(w, apierr.BadGateway, http.StatusBadGateway)
+		},
+	}
+	// cross origin policies
+	envCors, err := config.Map(config.Source{Path: envCorsDir})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		log.Fatalln(srvConfigErr, err)
+	}
+	corsPolicy, err = cors.New(envCors)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
 	}
-	fmt.Println(authMap)
-
-	// session, _ := sessStore.Get(r, "session-name")
-	// // session.Values["json"] = "bar"
-	// // session.Values[42] = 43
-	// session.Values = nil
-	// err := session.Save(r, w)
-	// if err != nil {
-	// 	http.Error(w, err.Error(), http.StatusInternalServerError)
-	// 	return
-	// }
-	// fmt.Println(session.Values)
 }
 
-func authenticationControl(json []byte) ([]byte, error) {
-	resp, err := http.Post(envSessionMap["authURL"], "application/json", bytes.NewBuffer(json))
-	if err != nil {
-		return nil, err
+func main() {
+	checks = health.New()
+	checks.OnStop(trace.Shutdown)
+	checks.Add("gateway", func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, conf.GatewayURL+"/healthz", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := gatewayClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return apierr.Unavailable
+		}
+		return nil
+	})
+	checks.Register(http.DefaultServeMux)
+	if conf.MetricsPort != "" {
+		go metrics.Listen(conf.MetricsPort)
 	}
-	defer resp.Body.Close()
-	json, err = ioutil.ReadAll(resp.Body)
+	log.Println(srvStart, "port:", conf.Port)
+	http.HandleFunc("/", MyHandler)
+	server := &http.Server{
+		Addr:    ":" + conf.Port,
+		Handler: trace.Handler(false, logging.Handler(nil, metrics.Instrument(http.DefaultServeMux, corsPolicy.Handler(http.DefaultServeMux)))),
+	}
+	err := checks.Serve(server, server.ListenAndServe, conf.ShutdownTimeout)
 	if err != nil {
-		return nil, err
+		log.Println(srvEnd, err)
 	}
-	return json, nil
 }
 
-// http.Error(w, err.Error(), http.StatusInternalServerError)
+// MyHandler passes login requests to the gateway,
+// cookies and the csrf token of the caller are sent with the request
+// and the gateway answer (session cookie included) is returned as it is.
+func MyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierr.Fail(w, apierr.MethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	loginProxy.ServeHTTP(w, r)
+}
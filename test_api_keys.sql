@@ -0,0 +1,11 @@
+CREATE TABLE test_api_keys (
+	prefix VARCHAR(16) NOT NULL UNIQUE PRIMARY KEY,
+	key_hash VARCHAR(64) NOT NULL,
+	user_id UUID NOT NULL REFERENCES test_users (user_id) ON DELETE CASCADE,
+	name VARCHAR(64) NOT NULL,
+	scopes VARCHAR(255) NOT NULL,
+	created timestamp without time zone NOT NULL DEFAULT now(),
+	expires BIGINT NOT NULL DEFAULT 0,
+	last_used BIGINT NOT NULL DEFAULT 0,
+	revoked BOOLEAN NOT NULL DEFAULT false
+);
@@ -0,0 +1,10 @@
+CREATE TABLE test_orders (
+	order_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v4() PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES test_users (user_id) ON DELETE CASCADE,
+	status VARCHAR(16) NOT NULL DEFAULT 'placed',
+	items JSONB NOT NULL,
+	total_cents BIGINT NOT NULL CHECK (total_cents >= 0),
+	shipping JSONB NOT NULL,
+	billing JSONB NOT NULL,
+	created BIGINT NOT NULL
+);
@@ -0,0 +1,15 @@
+CREATE TABLE test_products (
+	product_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v4() PRIMARY KEY,
+	name VARCHAR(64) NOT NULL,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	price_cents INTEGER NOT NULL CHECK (price_cents >= 0),
+	active BOOLEAN NOT NULL DEFAULT true
+);
+
+INSERT INTO test_products (name, description, price_cents) VALUES
+	('Canvas Backpack', '20 liters, water resistant.', 4990),
+	('Steel Bottle', '750 ml, keeps drinks cold for a day.', 1950),
+	('Wool Beanie', 'Merino wool, one size.', 1400),
+	('Running Socks', 'Pack of three pairs.', 1125),
+	('Notebook', 'A5, dotted, 192 pages.', 875),
+	('Desk Lamp', 'Dimmable led lamp with usb charging.', 3400);
@@ -0,0 +1,18 @@
+CREATE TABLE test_user_addresses (
+	address_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v4() PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES test_users (user_id) ON DELETE CASCADE,
+	kind VARCHAR(8) NOT NULL CHECK (kind IN ('shipping', 'billing')),
+	full_name VARCHAR(64) NOT NULL,
+	line1 VARCHAR(128) NOT NULL,
+	line2 VARCHAR(128) NOT NULL DEFAULT '',
+	city VARCHAR(64) NOT NULL,
+	region VARCHAR(64) NOT NULL DEFAULT '',
+	postal_code VARCHAR(16) NOT NULL DEFAULT '',
+	country CHAR(2) NOT NULL,
+	phone VARCHAR(20) NOT NULL DEFAULT '',
+	is_default BOOLEAN NOT NULL DEFAULT false,
+	created timestamp without time zone NOT NULL DEFAULT now()
+);
+
+-- a single default address of every kind
+CREATE UNIQUE INDEX test_user_addresses_default ON test_user_addresses (user_id, kind) WHERE is_default;
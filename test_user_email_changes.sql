@@ -0,0 +1,6 @@
+CREATE TABLE test_user_email_changes (
+	user_id UUID NOT NULL UNIQUE PRIMARY KEY REFERENCES test_users (user_id) ON DELETE CASCADE,
+	email VARCHAR(64) NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	expires BIGINT NOT NULL
+);
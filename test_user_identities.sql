@@ -0,0 +1,16 @@
+CREATE TABLE test_user_identities (
+	provider VARCHAR(32) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	user_id UUID NOT NULL REFERENCES test_users (user_id) ON DELETE CASCADE,
+	email VARCHAR(64),
+	created timestamp without time zone NOT NULL DEFAULT now(),
+	PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE test_oidc_states (
+	state_hash VARCHAR(64) NOT NULL UNIQUE PRIMARY KEY,
+	provider VARCHAR(32) NOT NULL,
+	verifier VARCHAR(128) NOT NULL,
+	nonce VARCHAR(64) NOT NULL,
+	expires BIGINT NOT NULL
+);
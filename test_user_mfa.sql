@@ -0,0 +1,20 @@
+CREATE TABLE test_user_mfa (
+	user_id UUID NOT NULL UNIQUE PRIMARY KEY REFERENCES test_users (user_id) ON DELETE CASCADE,
+	secret VARCHAR(64) NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT false,
+	last_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE test_user_recovery (
+	code_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v4() PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES test_users (user_id) ON DELETE CASCADE,
+	code_hash VARCHAR(64) NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE test_user_mfa_pending (
+	token_hash VARCHAR(64) NOT NULL UNIQUE PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES test_users (user_id) ON DELETE CASCADE,
+	expires BIGINT NOT NULL,
+	tries INTEGER NOT NULL DEFAULT 0
+);
@@ -1,7 +1,7 @@
 CREATE TABLE test_users (
 	user_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v4() PRIMARY KEY,
 	username VARCHAR(32) CHECK (char_length(username) > 4) NOT NULL UNIQUE,
-	password VARCHAR(64) NOT NULL,
+	password VARCHAR(128) NOT NULL,
 	email VARCHAR(64) CHECK (char_length(email) > 5) NOT NULL UNIQUE,
 	type VARCHAR(16) NOT NULL DEFAULT 'standart',
 	first_name VARCHAR(32),
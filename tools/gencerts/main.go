@@ -0,0 +1,176 @@
+// gencerts creates a local certificate authority and a certificate for every service.
+// certificates are used for mutual tls between internal services,
+// 'public' is the https certificate of the gateway (tls_cert of .env_gateway).
+//
+//	go run tools/gencerts/main.go -out certs
+//
+// an existing ca in the output directory is reused,
+// so new services can be added without replacing the others.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	outDir   = flag.String("out", "certs", "output directory")
+	services = flag.String("services", "public,gateway,auth_service,data_service", "comma separated service names")
+	hosts    = flag.String("hosts", "localhost,127.0.0.1", "comma separated host names and addresses of the services")
+	days     = flag.Int("days", 365, "certificate lifetime in days")
+)
+
+func main() {
+	flag.Parse()
+	err := os.MkdirAll(*outDir, 0700)
+	if err != nil {
+		log.Fatal(err)
+	}
+	caCert, caKey, err := loadCA()
+	if os.IsNotExist(err) {
+		caCert, caKey, err = createCA()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range strings.Split(*services, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		err = createLeaf(name, caCert, caKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println(">> Certificate Created:", name)
+	}
+}
+
+func loadCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := ioutil.ReadFile(filepath.Join(*outDir, "ca.pem"))
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(filepath.Join(*outDir, "ca-key.pem"))
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, os.ErrInvalid
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, os.ErrInvalid
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Println(">> Existing CA Reused")
+	return cert, key, nil
+}
+
+func createCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "ecomm local ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(0, 0, *days*5),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = writeFiles("ca", der, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Println(">> CA Created")
+	return cert, key, nil
+}
+
+// createLeaf creates a certificate usable both as server and client,
+// common name is the service name and used as peer identity.
+func createLeaf(name string, caCert *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 0, *days),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range strings.Split(*hosts, ",") {
+		host = strings.TrimSpace(host)
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writeFiles(name, der, key)
+}
+
+func writeFiles(name string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	err = ioutil.WriteFile(filepath.Join(*outDir, name+".pem"), certPEM, 0644)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(*outDir, name+"-key.pem"), keyPEM, 0600)
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
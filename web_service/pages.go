@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"penman"
+	"sync"
+)
+
+const (
+	// log strings
+	pageFailed string = ">> Page Render Failed:"
+)
+
+var (
+	// templates and static files are built into the binary,
+	// dev reload reads them from the service directory instead
+	//go:embed templates static
+	assets embed.FS
+)
+
+// page is the data of every template,
+// pages call the gateway from the browser so they only need its url.
+type page struct {
+	Name       string
+	Title      string
+	GatewayURL string
+	Message    string
+}
+
+// pageCache parses a page with the layout once and keeps it,
+// in dev reload mode every request parses files again.
+type pageCache struct {
+	files  fs.FS
+	reload bool
+
+	mu    sync.Mutex
+	pages map[string]*template.Template
+}
+
+// newPageCache returns a cache over embedded files,
+// or over the files on disk when reload is true.
+func newPageCache(reload bool) *pageCache {
+	var files fs.FS = assets
+	if reload {
+		files = os.DirFS(penman.GetCurrentDir())
+	}
+	return &pageCache{files: files, reload: reload, pages: make(map[string]*template.Template)}
+}
+
+// get returns the parsed template of the page.
+func (c *pageCache) get(name string) (*template.Template, error) {
+	if c.reload {
+		return c.parse(name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.pages[name]; ok {
+		return t, nil
+	}
+	t, err := c.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	c.pages[name] = t
+	return t, nil
+}
+
+func (c *pageCache) parse(name string) (*template.Template, error) {
+	return template.ParseFS(c.files, "templates/layout.html", "templates/"+name+".html")
+}
+
+// static serves files of the static directory.
+func (c *pageCache) static() http.Handler {
+	files, err := fs.Sub(c.files, "static")
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	return http.FileServer(http.FS(files))
+}
+
+// render writes the page with status, the error page is written when it fails.
+// output is buffered so a failing template never sends half a page.
+func (c *pageCache) render(w http.ResponseWriter, status int, p page) {
+	p.GatewayURL = conf.GatewayURL
+	t, err := c.get(p.Name)
+	var buff bytes.Buffer
+	if err == nil {
+		err = t.ExecuteTemplate(&buff, "layout", p)
+	}
+	if err != nil {
+		log.Println(pageFailed, p.Name, err)
+		if p.Name == "error" {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		c.render(w, http.StatusInternalServerError, page{Name: "error", Title: "Error", Message: "Something went wrong, try again later."})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buff.WriteTo(w)
+}
+
+// pageHandle renders a page that needs no server side data.
+func pageHandle(name, title string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pages.render(w, http.StatusOK, page{Name: name, Title: title})
+	}
+}
+
+// notFoundHandle renders the error page for unknown paths.
+func notFoundHandle(w http.ResponseWriter, r *http.Request) {
+	pages.render(w, http.StatusNotFound, page{Name: "error", Title: "Not Found", Message: "This page does not exist."})
+}
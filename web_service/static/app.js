@@ -0,0 +1,426 @@
+// storefront pages, every api call goes to the gateway with the session cookie.
+(function () {
+  "use strict";
+
+  var gateway = document.body.dataset.gateway.replace(/\/+$/, "");
+  var page = document.body.dataset.page;
+  var cartKey = "ecomm_cart";
+  // address ids chosen at checkout, orders store snapshots of them
+  var checkoutKey = "ecomm_checkout";
+  var csrfToken = null;
+
+  // api responses are envelopes: {status, data, error, request_id}
+  function api(method, path, body) {
+    var ready = method === "GET" ? Promise.resolve() : csrf();
+    return ready.then(function () {
+      var headers = { "Content-Type": "application/json" };
+      if (method !== "GET") {
+        headers["X-CSRF-Token"] = csrfToken;
+      }
+      return fetch(gateway + path, {
+        method: method,
+        credentials: "include",
+        headers: headers,
+        body: body === undefined ? undefined : JSON.stringify(body)
+      });
+    }).then(function (resp) {
+      return resp.json().catch(function () {
+        return { status: "Failed", data: null, error: { code: "bad_response", message: resp.statusText } };
+      });
+    }).then(function (envelope) {
+      if (envelope.status === "Failed") {
+        var err = new Error((envelope.error && envelope.error.message) || "Request failed");
+        err.envelope = envelope;
+        throw err;
+      }
+      return envelope;
+    });
+  }
+
+  // csrf token is fetched once per page
+  function csrf() {
+    if (csrfToken) {
+      return Promise.resolve();
+    }
+    return fetch(gateway + "/csrf", { credentials: "include" })
+      .then(function (resp) { return resp.json(); })
+      .then(function (envelope) { csrfToken = envelope.data.csrf_token; });
+  }
+
+  function notice(message, isError) {
+    var el = document.getElementById("notice");
+    el.textContent = message;
+    el.className = isError ? "notice error" : "notice";
+    el.hidden = false;
+  }
+
+  function failed(err) {
+    var details = err.envelope && err.envelope.error && err.envelope.error.details;
+    var message = err.message;
+    if (details) {
+      message += ": " + Object.keys(details).map(function (k) { return k + " " + details[k]; }).join(", ");
+    }
+    notice(message, true);
+  }
+
+  function formData(form, keepEmpty) {
+    var data = {};
+    new FormData(form).forEach(function (value, key) {
+      if (value !== "" || keepEmpty) {
+        data[key] = value;
+      }
+    });
+    return data;
+  }
+
+  // pages of logged in users go to login on 401
+  function loginRequired(err) {
+    if (err.envelope && err.envelope.error && err.envelope.error.code === "unauthorized") {
+      window.location = "/login";
+      return;
+    }
+    failed(err);
+  }
+
+  // cart is kept in the browser until the order is placed
+  function cart() {
+    try {
+      return JSON.parse(localStorage.getItem(cartKey)) || {};
+    } catch (e) {
+      return {};
+    }
+  }
+
+  function saveCart(items) {
+    localStorage.setItem(cartKey, JSON.stringify(items));
+    cartCount();
+  }
+
+  function cartCount() {
+    var items = cart();
+    var count = Object.keys(items).reduce(function (n, id) { return n + items[id].quantity; }, 0);
+    document.getElementById("cart-count").textContent = count > 0 ? count : "";
+  }
+
+  function addressLine(a) {
+    return [a.full_name, a.line1, a.line2, a.postal_code + " " + a.city, a.region, a.country]
+      .filter(function (part) { return part && part.trim() !== ""; })
+      .join(", ");
+  }
+
+  // prices are integer cents
+  function money(cents) {
+    return (cents / 100).toFixed(2);
+  }
+
+  function cell(row, text) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    row.appendChild(td);
+    return td;
+  }
+
+  var pages = {
+    login: function () {
+      var login = document.getElementById("login-form");
+      var mfa = document.getElementById("mfa-form");
+      function done(envelope) {
+        if (envelope.data.auth === "mfa") {
+          login.hidden = true;
+          mfa.hidden = false;
+          notice("Second factor required.");
+          return;
+        }
+        window.location = "/profile";
+      }
+      login.addEventListener("submit", function (e) {
+        e.preventDefault();
+        var body = formData(login);
+        body.action = "login";
+        api("POST", "/login", body).then(done).catch(failed);
+      });
+      mfa.addEventListener("submit", function (e) {
+        e.preventDefault();
+        var body = formData(mfa);
+        body.action = "mfa";
+        api("POST", "/login", body).then(done).catch(failed);
+      });
+    },
+
+    signup: function () {
+      var form = document.getElementById("signup-form");
+      form.addEventListener("submit", function (e) {
+        e.preventDefault();
+        api("POST", "/signup", formData(form)).then(function () {
+          window.location = "/login";
+        }).catch(failed);
+      });
+    },
+
+    profile: function () {
+      var section = document.getElementById("profile");
+      var form = document.getElementById("profile-form");
+      var email = document.getElementById("email-form");
+      var password = document.getElementById("password-form");
+      function show(data) {
+        var p = data.profile;
+        document.getElementById("profile-username").textContent = p.username;
+        document.getElementById("profile-email").textContent = p.email;
+        var pending = document.getElementById("profile-pending");
+        pending.textContent = "Waiting for verification: " + data.email_pending;
+        pending.hidden = !data.email_pending;
+        Array.prototype.forEach.call(form.elements, function (el) {
+          if (el.name && p[el.name] !== undefined && p[el.name] !== null) {
+            // timestamps come back with a time part
+            el.value = el.name === "birth_date" ? String(p[el.name]).slice(0, 10) : p[el.name];
+          }
+        });
+        section.hidden = false;
+      }
+      api("POST", "/profile").then(function (envelope) { show(envelope.data); }).catch(loginRequired);
+      form.addEventListener("submit", function (e) {
+        e.preventDefault();
+        var body = formData(form, true);
+        // empty values can not be stored in these columns
+        ["gender", "birth_date"].forEach(function (key) {
+          if (body[key] === "") {
+            delete body[key];
+          }
+        });
+        api("POST", "/profile/update", body).then(function (envelope) {
+          show(envelope.data);
+          notice("Profile saved.");
+        }).catch(loginRequired);
+      });
+      email.addEventListener("submit", function (e) {
+        e.preventDefault();
+        api("POST", "/email/change", formData(email)).then(function (envelope) {
+          email.reset();
+          notice("Verification link sent to " + envelope.data.email_pending + ".");
+          var pending = document.getElementById("profile-pending");
+          pending.textContent = "Waiting for verification: " + envelope.data.email_pending;
+          pending.hidden = false;
+        }).catch(loginRequired);
+      });
+      password.addEventListener("submit", function (e) {
+        e.preventDefault();
+        api("POST", "/password/change", formData(password)).then(function () {
+          password.reset();
+          notice("Password changed.");
+        }).catch(loginRequired);
+      });
+    },
+
+    "verify-email": function () {
+      var status = document.getElementById("verify-status");
+      var token = new URLSearchParams(window.location.search).get("token");
+      if (!token) {
+        status.textContent = "The verification link is not complete.";
+        return;
+      }
+      api("POST", "/email/verify", { token: token }).then(function (envelope) {
+        status.textContent = "Your email address is now " + envelope.data.email + ".";
+      }).catch(function (err) {
+        status.textContent = err.message;
+      });
+    },
+
+    catalog: function () {
+      var list = document.getElementById("catalog");
+      api("GET", "/catalog").then(function (envelope) {
+        list.textContent = "";
+        (envelope.data || []).forEach(function (p) {
+          var card = document.createElement("div");
+          card.className = "card";
+          var name = document.createElement("h3");
+          name.textContent = p.name;
+          var description = document.createElement("p");
+          description.textContent = p.description;
+          var price = document.createElement("p");
+          price.textContent = money(p.price_cents);
+          var add = document.createElement("button");
+          add.textContent = "Add to cart";
+          add.addEventListener("click", function () {
+            var items = cart();
+            var item = items[p.product_id] || { name: p.name, price_cents: p.price_cents, quantity: 0 };
+            item.quantity++;
+            items[p.product_id] = item;
+            saveCart(items);
+            notice(p.name + " added to cart.");
+          });
+          [name, description, price, add].forEach(function (el) { card.appendChild(el); });
+          list.appendChild(card);
+        });
+      }).catch(failed);
+    },
+
+    cart: function () {
+      var body = document.getElementById("cart-items");
+      function render() {
+        var items = cart();
+        var total = 0;
+        body.textContent = "";
+        Object.keys(items).forEach(function (id) {
+          var item = items[id];
+          var row = document.createElement("tr");
+          cell(row, item.name);
+          cell(row, money(item.price_cents));
+          cell(row, item.quantity);
+          cell(row, money(item.price_cents * item.quantity));
+          var remove = document.createElement("button");
+          remove.textContent = "Remove";
+          remove.addEventListener("click", function () {
+            delete items[id];
+            saveCart(items);
+            render();
+          });
+          cell(row, "").appendChild(remove);
+          body.appendChild(row);
+          total += item.price_cents * item.quantity;
+        });
+        document.getElementById("cart-total").textContent = money(total);
+      }
+      render();
+    },
+
+    addresses: function () {
+      var body = document.getElementById("address-items");
+      var form = document.getElementById("address-form");
+      var cancel = document.getElementById("address-cancel");
+      function edit(a) {
+        form.reset();
+        document.getElementById("address-form-title").textContent = a ? "Edit address" : "New address";
+        cancel.hidden = !a;
+        if (!a) {
+          return;
+        }
+        Array.prototype.forEach.call(form.elements, function (el) {
+          if (!el.name || a[el.name] === undefined) {
+            return;
+          }
+          if (el.type === "checkbox") {
+            el.checked = String(a[el.name]) === "true";
+          } else {
+            el.value = a[el.name];
+          }
+        });
+        form.scrollIntoView();
+      }
+      function render() {
+        api("POST", "/address/list").then(function (envelope) {
+          body.textContent = "";
+          (envelope.data || []).forEach(function (a) {
+            var row = document.createElement("tr");
+            cell(row, a.kind);
+            cell(row, addressLine(a));
+            cell(row, String(a.is_default) === "true" ? "yes" : "");
+            var actions = cell(row, "");
+            var change = document.createElement("button");
+            change.textContent = "Edit";
+            change.addEventListener("click", function () { edit(a); });
+            var remove = document.createElement("button");
+            remove.textContent = "Delete";
+            remove.addEventListener("click", function () {
+              api("POST", "/address/delete", { address_id: a.address_id }).then(render).catch(loginRequired);
+            });
+            actions.appendChild(change);
+            actions.appendChild(remove);
+            body.appendChild(row);
+          });
+        }).catch(loginRequired);
+      }
+      form.addEventListener("submit", function (e) {
+        e.preventDefault();
+        var data = formData(form, true);
+        data.is_default = form.elements.is_default.checked ? "true" : "false";
+        var path = data.address_id ? "/address/update" : "/address/create";
+        if (!data.address_id) {
+          delete data.address_id;
+        }
+        api("POST", path, data).then(function () {
+          edit(null);
+          notice("Address saved.");
+          render();
+        }).catch(loginRequired);
+      });
+      cancel.addEventListener("click", function () { edit(null); });
+      render();
+    },
+
+    checkout: function () {
+      var items = cart();
+      var ids = Object.keys(items);
+      var summary = document.getElementById("checkout-summary");
+      var form = document.getElementById("checkout-form");
+      if (ids.length === 0) {
+        summary.textContent = "Your cart is empty.";
+        return;
+      }
+      var total = ids.reduce(function (n, id) { return n + items[id].price_cents * items[id].quantity; }, 0);
+      summary.textContent = ids.length + " products, total " + money(total);
+      var chosen = {};
+      try {
+        chosen = JSON.parse(localStorage.getItem(checkoutKey)) || {};
+      } catch (e) {
+        chosen = {};
+      }
+      api("POST", "/address/list").then(function (envelope) {
+        var addresses = envelope.data || [];
+        ["shipping", "billing"].forEach(function (kind) {
+          var select = form.elements[kind];
+          addresses.forEach(function (a) {
+            var option = document.createElement("option");
+            option.value = a.address_id;
+            option.textContent = (a.kind === kind ? "" : "(" + a.kind + ") ") + addressLine(a);
+            // the saved choice wins, the default of the kind otherwise
+            option.selected = chosen[kind] ? chosen[kind] === a.address_id : a.kind === kind && String(a.is_default) === "true";
+            select.appendChild(option);
+          });
+        });
+        if (addresses.length === 0) {
+          notice("Add an address to your address book first.");
+        }
+        form.hidden = false;
+      }).catch(loginRequired);
+      form.addEventListener("submit", function (e) {
+        e.preventDefault();
+        var data = formData(form);
+        if (!data.shipping || !data.billing) {
+          notice("Choose a shipping and a billing address.", true);
+          return;
+        }
+        localStorage.setItem(checkoutKey, JSON.stringify(data));
+        chosen = data;
+        Promise.all([
+          api("POST", "/address/snapshot", { address_id: data.shipping }),
+          api("POST", "/address/snapshot", { address_id: data.billing })
+        ]).then(function (snapshots) {
+          var order = { shipping: snapshots[0].data, billing: snapshots[1].data };
+          document.getElementById("checkout-addresses").textContent = JSON.stringify(order, null, 2);
+          document.getElementById("checkout-review").hidden = false;
+        }).catch(loginRequired);
+      });
+      // prices are taken from the catalog again, the cart only has ids and quantities
+      document.getElementById("checkout-place").addEventListener("click", function () {
+        var body = {
+          shipping_address_id: chosen.shipping,
+          billing_address_id: chosen.billing,
+          items: ids.map(function (id) { return { product_id: id, quantity: items[id].quantity }; })
+        };
+        api("POST", "/order/create", body).then(function (envelope) {
+          saveCart({});
+          localStorage.removeItem(checkoutKey);
+          form.hidden = true;
+          document.getElementById("checkout-review").hidden = true;
+          summary.textContent = "Order " + envelope.data.order_id + " placed, total " + money(envelope.data.total_cents) + ".";
+          notice("Thank you for your order.");
+        }).catch(loginRequired);
+      });
+    }
+  };
+
+  cartCount();
+  if (pages[page]) {
+    pages[page]();
+  }
+})();
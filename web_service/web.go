@@ -1,46 +1,92 @@
 package main
 
 import (
+	"ecomm/internal/config"
+	"ecomm/internal/cors"
+	"ecomm/internal/health"
+	"ecomm/internal/logging"
 	"log"
 	"net/http"
-	"penman"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-func main() {
+const (
+	// environment directories,
+	// 'curr' keyword is a wild card for 'currentDirectory'
+	envMainDir string = "curr/../.env_main"
+	envCorsDir string = "curr/.env_cors"
 
-	r := mux.NewRouter()
-	r.HandleFunc("/", rootHandle).Methods("GET")
-	r.HandleFunc("/home", rootHandle).Methods("GET")
-	r.HandleFunc("/login", loginHandle).Methods("GET", "POST")
-	r.HandleFunc("/signup", signupHandle).Methods("GET", "POST")
-	r.HandleFunc("/profile", profileHandle).Methods("GET")
-	err := http.ListenAndServe(":8080", r)
-	log.Fatal(err)
-}
+	// log strings
+	srvConfigErr string = ">> Web Service Configuration Failed. Error:"
+)
 
-func rootHandle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(`
-		<a href="http://localhost:8080/login">login</a><br>
-		<a href="http://localhost:8080/profile">profile</a><br>
-		<a href="http://localhost:8080/signup">signup</a>`))
-}
+// webConfig is the configuration of web service.
+type webConfig struct {
+	config.Logging
 
-func loginHandle(w http.ResponseWriter, r *http.Request) {
+	Port string `env:"web_service_port" default:"8080"`
+	// pages call the gateway from the browser
+	GatewayURL string `env:"gateway_url" required:"true"`
+	// templates and static files are read from disk on every request
+	DevReload bool `env:"web_dev_reload" default:"false"`
+}
 
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Origin, cache-control")
-	w.Header().Set("Content-Type", "text/html")
+var (
+	// service configuration
+	conf webConfig
+	// cross origin policies of the service
+	corsPolicy *cors.CORS
+	// parsed page templates
+	pages *pageCache
+)
 
-	w.Write(penman.Read(penman.GetCurrentDir() + penman.Sep() + "login.html"))
+func init() {
+	err := config.Load(&conf, config.Source{Path: envMainDir})
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	logging.Setup("web_service", conf.LogLevel, conf.LogRedact)
+	// cross origin policies
+	envCors, err := config.Map(config.Source{Path: envCorsDir})
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	corsPolicy, err = cors.New(envCors)
+	if err != nil {
+		log.Fatalln(srvConfigErr, err)
+	}
+	pages = newPageCache(conf.DevReload)
 }
 
-func signupHandle(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte(`<p>signup</p><br><a href="http://localhost:8080">home</a><br>`))
-}
+func main() {
 
-func profileHandle(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte(`<p>profile</p><br><a href="http://localhost:8080">home</a><br>`))
+	r := mux.NewRouter()
+	r.HandleFunc("/", pageHandle("home", "Home")).Methods("GET")
+	r.HandleFunc("/home", pageHandle("home", "Home")).Methods("GET")
+	r.HandleFunc("/login", pageHandle("login", "Login")).Methods("GET")
+	r.HandleFunc("/signup", pageHandle("signup", "Sign up")).Methods("GET")
+	r.HandleFunc("/profile", pageHandle("profile", "Profile")).Methods("GET")
+	r.HandleFunc("/verify-email", pageHandle("verify-email", "Verify Email")).Methods("GET")
+	r.HandleFunc("/catalog", pageHandle("catalog", "Catalog")).Methods("GET")
+	r.HandleFunc("/cart", pageHandle("cart", "Cart")).Methods("GET")
+	r.HandleFunc("/checkout", pageHandle("checkout", "Checkout")).Methods("GET")
+	r.HandleFunc("/addresses", pageHandle("addresses", "Addresses")).Methods("GET")
+	static := pages.static()
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", static)).Methods("GET")
+	r.Handle("/favicon.ico", static).Methods("GET")
+	r.NotFoundHandler = http.HandlerFunc(notFoundHandle)
+	// no dependencies, ready while alive
+	checks := health.New()
+	r.HandleFunc("/healthz", checks.LiveHandle).Methods("GET")
+	r.HandleFunc("/readyz", checks.ReadyHandle).Methods("GET")
+	server := &http.Server{
+		Addr:    ":" + conf.Port,
+		Handler: logging.Handler(nil, corsPolicy.Handler(r)),
+	}
+	err := checks.Serve(server, server.ListenAndServe, 15*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
 }